@@ -0,0 +1,9 @@
+-- +migrate Up
+-- Track when a user proved ownership of their email address
+ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMP WITH TIME ZONE;
+
+-- Accounts created before verification existed are treated as verified
+UPDATE users SET verified_at = created_at WHERE verified_at IS NULL;
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN IF EXISTS verified_at;
@@ -0,0 +1,10 @@
+-- +migrate Up
+-- Public profile fields shown to other members
+ALTER TABLE users ADD COLUMN IF NOT EXISTS display_name VARCHAR(50);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio VARCHAR(500);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar_url VARCHAR(255);
+
+-- +migrate Down
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_url;
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
+ALTER TABLE users DROP COLUMN IF EXISTS display_name;
@@ -0,0 +1,9 @@
+-- +migrate Up
+-- Placeholder author for channel messages of deleted accounts.
+-- The password is not a bcrypt hash, so nobody can sign in as this user.
+INSERT INTO users (id, username, email, password, verified_at, created_at, updated_at)
+VALUES ('00000000-0000-0000-0000-000000000000', 'deleted-user', 'deleted-user@invalid', '!', NOW(), NOW(), NOW())
+ON CONFLICT (id) DO NOTHING;
+
+-- +migrate Down
+DELETE FROM users WHERE id = '00000000-0000-0000-0000-000000000000';
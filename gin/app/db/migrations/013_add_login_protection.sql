@@ -0,0 +1,25 @@
+-- +migrate Up
+-- Consecutive failed sign-ins and the resulting lockout
+ALTER TABLE users ADD COLUMN IF NOT EXISTS failed_login_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE;
+
+-- Security-relevant authentication events for review by administrators
+CREATE TABLE IF NOT EXISTS auth_events (
+    id UUID PRIMARY KEY,
+    user_id UUID,
+    email VARCHAR(255),
+    event_type VARCHAR(50) NOT NULL,
+    ip_address VARCHAR(45),
+    user_agent TEXT,
+    details TEXT,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_auth_events_user_id ON auth_events(user_id);
+CREATE INDEX idx_auth_events_created_at ON auth_events(created_at);
+
+-- +migrate Down
+DROP TABLE IF EXISTS auth_events;
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS failed_login_count;
@@ -0,0 +1,13 @@
+-- +migrate Up
+-- Users who opt in can be found by anyone; others only by people they share a server with
+ALTER TABLE users ADD COLUMN IF NOT EXISTS discoverable BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Trigram indexes for fuzzy username and display name search
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX IF NOT EXISTS idx_users_username_trgm ON users USING GIN (username gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_users_display_name_trgm ON users USING GIN (display_name gin_trgm_ops);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_users_display_name_trgm;
+DROP INDEX IF EXISTS idx_users_username_trgm;
+ALTER TABLE users DROP COLUMN IF EXISTS discoverable;
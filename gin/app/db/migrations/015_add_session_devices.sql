@@ -0,0 +1,12 @@
+-- +migrate Up
+-- Device details shown in the session list
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS user_agent TEXT;
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS ip_address VARCHAR(45);
+ALTER TABLE user_sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+
+UPDATE user_sessions SET last_seen_at = updated_at WHERE last_seen_at IS NULL;
+
+-- +migrate Down
+ALTER TABLE user_sessions DROP COLUMN IF EXISTS last_seen_at;
+ALTER TABLE user_sessions DROP COLUMN IF EXISTS ip_address;
+ALTER TABLE user_sessions DROP COLUMN IF EXISTS user_agent;
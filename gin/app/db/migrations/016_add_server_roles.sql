@@ -0,0 +1,52 @@
+-- +migrate Up
+-- Custom roles with a permission bitfield (see models.Permission)
+CREATE TABLE IF NOT EXISTS server_roles (
+    id UUID PRIMARY KEY,
+    server_id UUID NOT NULL,
+    name VARCHAR(50) NOT NULL,
+    color VARCHAR(7) NOT NULL DEFAULT '',
+    position INT NOT NULL,
+    permissions BIGINT NOT NULL DEFAULT 0,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (server_id) REFERENCES servers(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_server_roles_server_id ON server_roles(server_id);
+CREATE UNIQUE INDEX idx_server_roles_default ON server_roles(server_id) WHERE is_default;
+
+-- Roles held by members; leaving the server removes them
+CREATE TABLE IF NOT EXISTS server_member_roles (
+    server_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    role_id UUID NOT NULL,
+    assigned_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (server_id, user_id, role_id),
+    FOREIGN KEY (server_id, user_id) REFERENCES server_members(server_id, user_id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES server_roles(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_server_member_roles_role_id ON server_member_roles(role_id);
+
+-- Every existing server gets an @everyone role with the default permissions
+-- (view channels, send messages, attach files, create invites)
+INSERT INTO server_roles (id, server_id, name, position, permissions, is_default, created_at, updated_at)
+SELECT gen_random_uuid(), s.id, '@everyone', 0, 23, TRUE, NOW(), NOW()
+FROM servers s;
+
+-- The old "admin" member role becomes an Admin role with every permission except administrator
+INSERT INTO server_roles (id, server_id, name, position, permissions, created_at, updated_at)
+SELECT gen_random_uuid(), s.id, 'Admin', 1, 2047, NOW(), NOW()
+FROM servers s
+WHERE EXISTS (SELECT 1 FROM server_members sm WHERE sm.server_id = s.id AND sm.role = 'admin');
+
+INSERT INTO server_member_roles (server_id, user_id, role_id, assigned_at)
+SELECT sm.server_id, sm.user_id, r.id, NOW()
+FROM server_members sm
+JOIN server_roles r ON r.server_id = sm.server_id AND r.name = 'Admin' AND NOT r.is_default
+WHERE sm.role = 'admin';
+
+-- +migrate Down
+DROP TABLE IF EXISTS server_member_roles;
+DROP TABLE IF EXISTS server_roles;
@@ -0,0 +1,27 @@
+-- +migrate Up
+-- Servers created from now on can only be joined through an invite
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS allow_direct_join BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Existing servers keep working the way they did before invites existed
+UPDATE servers SET allow_direct_join = TRUE;
+
+-- Invite codes with optional expiry, use limit and landing channel
+CREATE TABLE IF NOT EXISTS server_invites (
+    code VARCHAR(16) PRIMARY KEY,
+    server_id UUID NOT NULL,
+    channel_id UUID,
+    created_by UUID NOT NULL,
+    max_uses INT NOT NULL DEFAULT 0,
+    uses INT NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    FOREIGN KEY (server_id) REFERENCES servers(id) ON DELETE CASCADE,
+    FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE SET NULL,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_server_invites_server_id ON server_invites(server_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS server_invites;
+ALTER TABLE servers DROP COLUMN IF EXISTS allow_direct_join;
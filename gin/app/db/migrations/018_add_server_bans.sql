@@ -0,0 +1,16 @@
+-- +migrate Up
+-- Users banned from a server cannot join it again, directly or through an invite
+CREATE TABLE IF NOT EXISTS server_bans (
+    server_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    banned_by UUID,
+    reason VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (server_id, user_id),
+    FOREIGN KEY (server_id) REFERENCES servers(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (banned_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS server_bans;
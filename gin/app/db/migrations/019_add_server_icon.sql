@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Path under /uploads of the server's icon image
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS icon_url VARCHAR(255);
+
+-- +migrate Down
+ALTER TABLE servers DROP COLUMN IF EXISTS icon_url;
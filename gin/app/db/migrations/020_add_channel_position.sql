@@ -0,0 +1,14 @@
+-- +migrate Up
+-- Display order of a channel within its category (or among uncategorized channels)
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS position INT NOT NULL DEFAULT 0;
+
+-- Keep the alphabetical order channels were shown in until now
+UPDATE channels c SET position = ordered.position
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY server_id, category_id ORDER BY name) - 1 AS position
+    FROM channels
+) ordered
+WHERE c.id = ordered.id;
+
+-- +migrate Down
+ALTER TABLE channels DROP COLUMN IF EXISTS position;
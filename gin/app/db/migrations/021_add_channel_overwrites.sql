@@ -0,0 +1,17 @@
+-- +migrate Up
+-- Per-channel permission overwrites for a role or a member (see models.ChannelOverwrite)
+CREATE TABLE IF NOT EXISTS channel_overwrites (
+    channel_id UUID NOT NULL,
+    target_type VARCHAR(10) NOT NULL CHECK (target_type IN ('role', 'member')),
+    target_id UUID NOT NULL,
+    allow BIGINT NOT NULL DEFAULT 0,
+    deny BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (channel_id, target_type, target_id),
+    FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_channel_overwrites_target ON channel_overwrites(target_type, target_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS channel_overwrites;
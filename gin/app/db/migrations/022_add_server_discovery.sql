@@ -0,0 +1,19 @@
+-- +migrate Up
+-- Servers opt into the public directory with a category and tags
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS discoverable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS discovery_category VARCHAR(30) NOT NULL DEFAULT '';
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS tags TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE INDEX IF NOT EXISTS idx_servers_discoverable ON servers(discovery_category) WHERE discoverable;
+CREATE INDEX IF NOT EXISTS idx_servers_tags ON servers USING GIN (tags);
+
+-- Used to sort the directory by recent activity
+CREATE INDEX IF NOT EXISTS idx_channel_messages_channel_timestamp ON channel_messages(channel_id, timestamp DESC);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_channel_messages_channel_timestamp;
+DROP INDEX IF EXISTS idx_servers_tags;
+DROP INDEX IF EXISTS idx_servers_discoverable;
+ALTER TABLE servers DROP COLUMN IF EXISTS tags;
+ALTER TABLE servers DROP COLUMN IF EXISTS discovery_category;
+ALTER TABLE servers DROP COLUMN IF EXISTS discoverable;
@@ -0,0 +1,18 @@
+-- +migrate Up
+-- Saved server structures (categories, channels and roles, without messages)
+CREATE TABLE IF NOT EXISTS server_templates (
+    id UUID PRIMARY KEY,
+    name VARCHAR(50) NOT NULL,
+    description VARCHAR(200) NOT NULL DEFAULT '',
+    created_by UUID NOT NULL,
+    source_server_id UUID,
+    structure JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (source_server_id) REFERENCES servers(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_server_templates_created_by ON server_templates(created_by);
+
+-- +migrate Down
+DROP TABLE IF EXISTS server_templates;
@@ -0,0 +1,21 @@
+-- +migrate Up
+-- Record of administrative actions taken in a server
+CREATE TABLE IF NOT EXISTS audit_log (
+    id UUID PRIMARY KEY,
+    server_id UUID NOT NULL,
+    actor_id UUID,
+    action VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20) NOT NULL,
+    target_id VARCHAR(36) NOT NULL,
+    changes JSONB NOT NULL DEFAULT '[]',
+    reason VARCHAR(512) NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    FOREIGN KEY (server_id) REFERENCES servers(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX idx_audit_log_server_created ON audit_log(server_id, created_at DESC);
+CREATE INDEX idx_audit_log_server_actor ON audit_log(server_id, actor_id);
+
+-- +migrate Down
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,8 @@
+-- +migrate Up
+-- normal: anyone who may send messages posts; announcement: only members with Manage Messages post;
+-- archived: history stays readable but nobody posts
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS mode VARCHAR(20) NOT NULL DEFAULT 'normal'
+    CHECK (mode IN ('normal', 'announcement', 'archived'));
+
+-- +migrate Down
+ALTER TABLE channels DROP COLUMN IF EXISTS mode;
@@ -0,0 +1,18 @@
+-- +migrate Up
+-- Minimum number of seconds between a member's messages in a channel (0 disables slowmode)
+ALTER TABLE channels ADD COLUMN IF NOT EXISTS slowmode_seconds INTEGER NOT NULL DEFAULT 0
+    CHECK (slowmode_seconds >= 0 AND slowmode_seconds <= 21600);
+
+-- When each member last posted in a slowmode channel
+CREATE TABLE IF NOT EXISTS channel_slowmode (
+    channel_id UUID NOT NULL,
+    user_id UUID NOT NULL,
+    last_posted_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (channel_id, user_id),
+    FOREIGN KEY (channel_id) REFERENCES channels(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- +migrate Down
+DROP TABLE IF EXISTS channel_slowmode;
+ALTER TABLE channels DROP COLUMN IF EXISTS slowmode_seconds;
@@ -0,0 +1,6 @@
+-- +migrate Up
+-- Login states started by a signed-in user to re-authenticate before a sensitive action
+ALTER TABLE oidc_login_states ADD COLUMN IF NOT EXISTS user_id UUID REFERENCES users(id) ON DELETE CASCADE;
+
+-- +migrate Down
+ALTER TABLE oidc_login_states DROP COLUMN IF EXISTS user_id;
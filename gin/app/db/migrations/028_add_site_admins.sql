@@ -0,0 +1,12 @@
+-- +migrate Up
+-- Site administrators may review sign-in lockouts and authentication events.
+-- Grant with: UPDATE users SET is_admin = TRUE WHERE email = '...';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_locked_until ON users(locked_until) WHERE locked_until IS NOT NULL;
+CREATE INDEX IF NOT EXISTS idx_auth_events_event_type ON auth_events(event_type, created_at);
+
+-- +migrate Down
+DROP INDEX IF EXISTS idx_auth_events_event_type;
+DROP INDEX IF EXISTS idx_users_locked_until;
+ALTER TABLE users DROP COLUMN IF EXISTS is_admin;
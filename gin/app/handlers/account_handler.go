@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"app/models"
+	"app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccountHandler handles personal data export and account deletion requests
+type AccountHandler struct {
+	accountService *services.AccountService
+	wsService      *services.WebSocketService
+}
+
+// NewAccountHandler creates a new account handler
+func NewAccountHandler(accountService *services.AccountService) *AccountHandler {
+	return &AccountHandler{
+		accountService: accountService,
+	}
+}
+
+// SetWebSocketService はWebSocketServiceを設定する
+func (h *AccountHandler) SetWebSocketService(wsService *services.WebSocketService) {
+	h.wsService = wsService
+}
+
+// ExportData sends the current user's personal data as a zip archive
+func (h *AccountHandler) ExportData(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Build the archive in a temporary file so errors can still be reported
+	tmp, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export"})
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if err := h.accountService.ExportData(userID.(string), tmp); err != nil {
+		log.Printf("error exporting data for user %s: %v", userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create export"})
+		return
+	}
+
+	filename := fmt.Sprintf("account-export-%s.zip", time.Now().Format("20060102"))
+	c.FileAttachment(tmp.Name(), filename)
+}
+
+// DeleteAccount permanently deletes the current user's account
+func (h *AccountHandler) DeleteAccount(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.accountService.DeleteAccount(userID.(string), req.Password, req.ReauthToken); err != nil {
+		if errors.Is(err, services.ErrInvalidPassword) || errors.Is(err, services.ErrInvalidReauthToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 削除されたユーザーのWebSocket接続を切断
+	if h.wsService != nil {
+		h.wsService.DisconnectUser(userID.(string), "account deleted")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account has been deleted"})
+}
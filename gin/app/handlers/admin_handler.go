@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"app/models"
+	"app/services"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AdminHandler lets site administrators review sign-in lockouts
+type AdminHandler struct {
+	userService *services.UserService
+}
+
+// NewAdminHandler creates a new admin handler
+func NewAdminHandler(userService *services.UserService) *AdminHandler {
+	return &AdminHandler{userService: userService}
+}
+
+// ListLockedAccounts lists the accounts currently locked after failed sign-ins
+func (h *AdminHandler) ListLockedAccounts(c *gin.Context) {
+	accounts, err := h.userService.ListLockedAccounts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"accounts": accounts})
+}
+
+// ListAuthEvents returns a page of authentication events. Supports userId, email,
+// type, limit and offset query parameters.
+func (h *AdminHandler) ListAuthEvents(c *gin.Context) {
+	filter := models.AuthEventFilter{
+		UserId:    c.Query("userId"),
+		Email:     c.Query("email"),
+		EventType: c.Query("type"),
+	}
+	if filter.UserId != "" {
+		if _, err := uuid.Parse(filter.UserId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+			return
+		}
+	}
+	filter.Limit, _ = strconv.Atoi(c.Query("limit"))
+	filter.Offset, _ = strconv.Atoi(c.Query("offset"))
+
+	events, err := h.userService.ListAuthEvents(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// UnlockAccount lifts the lockout of an account before it expires
+func (h *AdminHandler) UnlockAccount(c *gin.Context) {
+	userID := c.Param("id")
+	if _, err := uuid.Parse(userID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": services.ErrAccountNotLocked.Error()})
+		return
+	}
+
+	if err := h.userService.UnlockAccount(userID, c.GetString("userID"), clientInfo(c)); err != nil {
+		if errors.Is(err, services.ErrAccountNotLocked) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Account has been unlocked"})
+}
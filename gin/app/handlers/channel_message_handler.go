@@ -3,8 +3,11 @@ package handlers
 import (
 	"app/models"
 	"app/services"
+	"errors"
 	"log"
+	"math"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,6 +19,7 @@ type ChannelMessageHandler struct {
 	channelMessageService *services.ChannelMessageService
 	serverService         *services.ServerService
 	wsService             *services.WebSocketService
+	auditService          *services.AuditLogService
 }
 
 // NewChannelMessageHandler creates a new channel message handler
@@ -31,6 +35,11 @@ func (h *ChannelMessageHandler) SetWebSocketService(wsService *services.WebSocke
 	h.wsService = wsService
 }
 
+// SetAuditLogService sets the service administrative actions are recorded with
+func (h *ChannelMessageHandler) SetAuditLogService(auditService *services.AuditLogService) {
+	h.auditService = auditService
+}
+
 // GetChannelMessages retrieves all messages for a specific channel
 func (h *ChannelMessageHandler) GetChannelMessages(c *gin.Context) {
 	channelId := c.Param("id")
@@ -40,15 +49,22 @@ func (h *ChannelMessageHandler) GetChannelMessages(c *gin.Context) {
 	}
 
 	// Check if user is authenticated
-	_, exists := c.Get("userID")
+	userId, exists := c.Get("userID")
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	// For now, we'll skip the channel membership check
-	// In a real implementation, you would check if the user is a member of the channel
-	// using a method like h.serverService.IsChannelMember(channelId, userId.(string))
+	// Check if user can see the channel
+	hasAccess, err := h.serverService.HasChannelAccess(channelId, userId.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !hasAccess {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have access to this channel"})
+		return
+	}
 
 	// Get messages
 	messages, err := h.channelMessageService.GetChannelMessages(channelId)
@@ -82,10 +98,6 @@ func (h *ChannelMessageHandler) CreateChannelMessage(c *gin.Context) {
 		return
 	}
 
-	// For now, we'll skip the channel membership check
-	// In a real implementation, you would check if the user is a member of the channel
-	// using a method like h.serverService.IsChannelMember(channelID, userId.(string))
-
 	// Parse request
 	var req models.ChannelMessageRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -93,6 +105,15 @@ func (h *ChannelMessageHandler) CreateChannelMessage(c *gin.Context) {
 		return
 	}
 
+	// Check if user may post this message in the channel
+	if !checkSendPermission(c, h.serverService, channelID, userId.(string), req.Content) {
+		return
+	}
+	claimedAt, ok := claimSlowmode(c, h.serverService, channelID, userId.(string))
+	if !ok {
+		return
+	}
+
 	// Create message
 	message := models.ChannelMessage{
 		ID:        uuid.New().String(),
@@ -106,6 +127,7 @@ func (h *ChannelMessageHandler) CreateChannelMessage(c *gin.Context) {
 
 	// Save message
 	if err := h.channelMessageService.SaveChannelMessage(message); err != nil {
+		releaseSlowmode(h.serverService, channelID, userId.(string), claimedAt)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -115,15 +137,17 @@ func (h *ChannelMessageHandler) CreateChannelMessage(c *gin.Context) {
 		// In a real implementation, you would process each attachment
 		// For now, we'll just log that there are attachments
 		c.JSON(http.StatusCreated, gin.H{
-			"message": message,
-			"note":    "Attachments were provided but not processed in this implementation",
+			"message":  message,
+			"cooldown": slowmodeCooldown(h.serverService, channelID, userId.(string)),
+			"note":     "Attachments were provided but not processed in this implementation",
 		})
 		return
 	}
 
 	// レスポンスを返す
 	c.JSON(http.StatusCreated, gin.H{
-		"message": message,
+		"message":  message,
+		"cooldown": slowmodeCooldown(h.serverService, channelID, userId.(string)),
 	})
 
 	// WebSocketでブロードキャスト（WebSocketサービスが設定されている場合）
@@ -167,9 +191,18 @@ func (h *ChannelMessageHandler) EditChannelMessage(c *gin.Context) {
 		return
 	}
 
+	original, err := h.channelMessageService.GetMessageByID(messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+	if !checkChannelNotArchived(c, h.serverService, original.ChannelId) {
+		return
+	}
+
 	// Edit message
 	if err := h.channelMessageService.EditChannelMessage(messageID, req.Content); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondMessageError(c, err)
 		return
 	}
 
@@ -215,14 +248,20 @@ func (h *ChannelMessageHandler) DeleteChannelMessage(c *gin.Context) {
 		return
 	}
 
-	// Check if user is the author of the message
-	isAuthor, err := h.channelMessageService.IsMessageAuthor(messageID, userId.(string))
+	// Authors can delete their own messages, members with Manage Messages anyone's
+	canDelete, err := h.channelMessageService.CanDeleteChannelMessage(messageID, userId.(string))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if !isAuthor {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the author of this message"})
+	if !canDelete {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to delete this message"})
+		return
+	}
+
+	message, err := h.channelMessageService.GetMessageByID(messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
@@ -231,6 +270,7 @@ func (h *ChannelMessageHandler) DeleteChannelMessage(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordMessageDelete(h.auditService, h.serverService, c, message)
 
 	// メッセージのチャンネルIDを取得
 	var channelID string
@@ -302,3 +342,98 @@ func (h *ChannelMessageHandler) GetChannelAttachment(c *gin.Context) {
 
 	c.File(attachment.FilePath)
 }
+
+// checkSendPermission checks that the user may post content in the channel and writes
+// an error response if not. Mentioning @everyone or @here needs its own permission.
+func checkSendPermission(c *gin.Context, serverService *services.ServerService, channelID, userID, content string) bool {
+	canSend, err := serverService.HasChannelPermission(channelID, userID, models.PermissionSendMessages)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	if !canSend {
+		// Explain when it is the channel's mode rather than the user's roles that forbids posting
+		channel, err := serverService.GetChannelByID(channelID)
+		switch {
+		case err == nil && channel.Mode == models.ChannelModeArchived:
+			c.JSON(http.StatusForbidden, gin.H{"error": "This channel is archived and no longer accepts messages", "mode": channel.Mode})
+		case err == nil && channel.Mode == models.ChannelModeAnnouncement:
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only moderators can post in this announcement channel", "mode": channel.Mode})
+		default:
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to send messages in this channel"})
+		}
+		return false
+	}
+
+	if strings.Contains(content, "@everyone") || strings.Contains(content, "@here") {
+		canMention, err := serverService.HasChannelPermission(channelID, userID, models.PermissionMentionEveryone)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return false
+		}
+		if !canMention {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to mention everyone in this channel"})
+			return false
+		}
+	}
+
+	return true
+}
+
+// claimSlowmode claims the user's next message in a slowmode channel and writes a 429 response
+// with the remaining cooldown if they posted too recently. Call it once the request has been
+// validated, and give the claim back with releaseSlowmode if the message is not saved.
+func claimSlowmode(c *gin.Context, serverService *services.ServerService, channelID, userID string) (time.Time, bool) {
+	claimedAt, retryAfter, err := serverService.ClaimSlowmode(channelID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return time.Time{}, false
+	}
+	if retryAfter > 0 {
+		respondTooManyRequests(c, retryAfter, "Slowmode is enabled in this channel, wait before sending another message")
+		return time.Time{}, false
+	}
+	return claimedAt, true
+}
+
+// releaseSlowmode gives back the slowmode claim of a message that could not be saved
+func releaseSlowmode(serverService *services.ServerService, channelID, userID string, claimedAt time.Time) {
+	if err := serverService.ReleaseSlowmode(channelID, userID, claimedAt); err != nil {
+		log.Printf("スローモードの解除に失敗: %v", err)
+	}
+}
+
+// slowmodeCooldown returns the whole seconds until the user may post in the channel again,
+// so clients can show a countdown after sending
+func slowmodeCooldown(serverService *services.ServerService, channelID, userID string) int {
+	cooldown, err := serverService.SlowmodeCooldown(channelID, userID)
+	if err != nil {
+		log.Printf("スローモードの確認に失敗: %v", err)
+		return 0
+	}
+	return int(math.Ceil(cooldown.Seconds()))
+}
+
+// checkChannelNotArchived writes an error response and returns false when the channel is archived.
+// Archived channels keep their history as it was, so messages in them cannot be edited.
+func checkChannelNotArchived(c *gin.Context, serverService *services.ServerService, channelID string) bool {
+	channel, err := serverService.GetChannelByID(channelID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Channel not found"})
+		return false
+	}
+	if channel.Mode == models.ChannelModeArchived {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This channel is archived and no longer accepts messages", "mode": channel.Mode})
+		return false
+	}
+	return true
+}
+
+// respondMessageError writes 404 for a message that does not exist and 500 otherwise
+func respondMessageError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrMessageNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
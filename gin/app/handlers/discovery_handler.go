@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"app/services"
+)
+
+// DiscoveryHandler handles the public server directory
+type DiscoveryHandler struct {
+	discoveryService *services.DiscoveryService
+}
+
+// NewDiscoveryHandler creates a new discovery handler
+func NewDiscoveryHandler(discoveryService *services.DiscoveryService) *DiscoveryHandler {
+	return &DiscoveryHandler{
+		discoveryService: discoveryService,
+	}
+}
+
+// ListServers searches the directory. Supports q, category, tag, sort (members or activity),
+// limit and offset query parameters.
+func (h *DiscoveryHandler) ListServers(c *gin.Context) {
+	sort := c.DefaultQuery("sort", services.DirectorySortMembers)
+	if sort != services.DirectorySortMembers && sort != services.DirectorySortActivity {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sortにはmembersまたはactivityを指定してください"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	results, err := h.discoveryService.ListServers(
+		c.GetString("userID"), c.Query("q"), c.Query("category"), c.Query("tag"), sort, limit, offset,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバー一覧の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// JoinServer joins a server listed in the directory
+func (h *DiscoveryHandler) JoinServer(c *gin.Context) {
+	err := h.discoveryService.JoinServer(c.Param("id"), c.GetString("userID"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrServerNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "サーバーが見つかりません"})
+		case errors.Is(err, services.ErrBanned):
+			c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーからBANされています"})
+		case errors.Is(err, services.ErrAlreadyMember):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "すでにこのサーバーのメンバーです"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーへの参加に失敗しました"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "サーバーに参加しました", "serverId": c.Param("id")})
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"app/models"
+	"app/services"
+)
+
+// InviteHandler handles server invite requests
+type InviteHandler struct {
+	inviteService *services.InviteService
+	serverService *services.ServerService
+	auditService  *services.AuditLogService
+}
+
+// NewInviteHandler creates a new invite handler
+func NewInviteHandler(inviteService *services.InviteService, serverService *services.ServerService) *InviteHandler {
+	return &InviteHandler{
+		inviteService: inviteService,
+		serverService: serverService,
+	}
+}
+
+// SetAuditLogService sets the service administrative actions are recorded with
+func (h *InviteHandler) SetAuditLogService(auditService *services.AuditLogService) {
+	h.auditService = auditService
+}
+
+// CreateInvite creates an invite code for a server
+func (h *InviteHandler) CreateInvite(c *gin.Context) {
+	var req models.CreateInviteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId, models.PermissionCreateInvites)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return
+	}
+	if !hasPermission {
+		c.JSON(http.StatusForbidden, gin.H{"error": "招待リンクを作成する権限がありません"})
+		return
+	}
+
+	invite, err := h.inviteService.CreateInvite(serverId, userId, req)
+	if err != nil {
+		respondInviteError(c, err, "招待リンクの作成に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditInviteCreate,
+		TargetType: models.AuditTargetInvite,
+		TargetId:   invite.Code,
+		Changes:    services.AuditDiff(nil, invite),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"invite": invite})
+}
+
+// ListInvites returns the invites of a server
+func (h *InviteHandler) ListInvites(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId, models.PermissionManageServer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return
+	}
+	if !hasPermission {
+		c.JSON(http.StatusForbidden, gin.H{"error": "招待リンクを管理する権限がありません"})
+		return
+	}
+
+	invites, err := h.inviteService.ListInvites(serverId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "招待リンクの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invites": invites})
+}
+
+// DeleteInvite revokes an invite. Creators may revoke their own invites.
+func (h *InviteHandler) DeleteInvite(c *gin.Context) {
+	userId := c.GetString("userID")
+
+	invite, err := h.inviteService.GetInvite(c.Param("code"))
+	if err != nil {
+		respondInviteError(c, err, "招待リンクの取得に失敗しました")
+		return
+	}
+
+	if invite.CreatedBy != userId {
+		hasPermission, err := h.serverService.HasServerPermission(invite.ServerId, userId, models.PermissionManageServer)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+			return
+		}
+		if !hasPermission {
+			c.JSON(http.StatusForbidden, gin.H{"error": "招待リンクを管理する権限がありません"})
+			return
+		}
+	}
+
+	if err := h.inviteService.DeleteInvite(invite.Code); err != nil {
+		respondInviteError(c, err, "招待リンクの削除に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   invite.ServerId,
+		Action:     models.AuditInviteDelete,
+		TargetType: models.AuditTargetInvite,
+		TargetId:   invite.Code,
+		Changes:    services.AuditDiff(invite, nil),
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "招待リンクを削除しました"})
+}
+
+// PreviewInvite shows the server an invite leads to
+func (h *InviteHandler) PreviewInvite(c *gin.Context) {
+	preview, err := h.inviteService.PreviewInvite(c.Param("code"))
+	if err != nil {
+		respondInviteError(c, err, "招待リンクの取得に失敗しました")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"invite": preview})
+}
+
+// AcceptInvite joins the server an invite leads to
+func (h *InviteHandler) AcceptInvite(c *gin.Context) {
+	invite, err := h.inviteService.AcceptInvite(c.Param("code"), c.GetString("userID"))
+	if err != nil {
+		respondInviteError(c, err, "サーバーへの参加に失敗しました")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":   "サーバーに参加しました",
+		"serverId":  invite.ServerId,
+		"channelId": invite.ChannelId,
+	})
+}
+
+// respondInviteError maps invite service errors to responses
+func respondInviteError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrInviteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "招待リンクが見つかりません"})
+	case errors.Is(err, services.ErrInviteExpired):
+		c.JSON(http.StatusGone, gin.H{"error": "招待リンクの有効期限が切れています"})
+	case errors.Is(err, services.ErrInviteChannel):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "指定されたチャンネルは招待に使用できません"})
+	case errors.Is(err, services.ErrBanned):
+		c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーからBANされています"})
+	case errors.Is(err, services.ErrAlreadyMember):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "すでにこのサーバーのメンバーです"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -18,6 +18,7 @@ type MessageHandler struct {
 	messageService *services.MessageService
 	serverService  *services.ServerService
 	wsService      *services.WebSocketService
+	auditService   *services.AuditLogService
 }
 
 // NewMessageHandler creates a new message handler
@@ -33,6 +34,11 @@ func (h *MessageHandler) SetWebSocketService(wsService *services.WebSocketServic
 	h.wsService = wsService
 }
 
+// SetAuditLogService sets the service administrative actions are recorded with
+func (h *MessageHandler) SetAuditLogService(auditService *services.AuditLogService) {
+	h.auditService = auditService
+}
+
 // SendChannelMessage sends a message to a channel
 func (h *MessageHandler) SendChannelMessage(c *gin.Context) {
 	channelID := c.Param("id")
@@ -48,17 +54,6 @@ func (h *MessageHandler) SendChannelMessage(c *gin.Context) {
 		return
 	}
 
-	// Check if user is a member of the channel
-	hasAccess, err := h.serverService.HasChannelAccess(channelID, userId.(string))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	if !hasAccess {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this channel"})
-		return
-	}
-
 	// Parse request
 	var req struct {
 		Content string `json:"content" binding:"required"`
@@ -68,6 +63,15 @@ func (h *MessageHandler) SendChannelMessage(c *gin.Context) {
 		return
 	}
 
+	// Check if user may post this message in the channel
+	if !checkSendPermission(c, h.serverService, channelID, userId.(string), req.Content) {
+		return
+	}
+	claimedAt, ok := claimSlowmode(c, h.serverService, channelID, userId.(string))
+	if !ok {
+		return
+	}
+
 	// Create message
 	messageId := uuid.New().String()
 	message := models.Message{
@@ -88,6 +92,7 @@ func (h *MessageHandler) SendChannelMessage(c *gin.Context) {
 			// Save file and get path
 			filePath, err := h.messageService.SaveAttachment(file, messageId)
 			if err != nil {
+				releaseSlowmode(h.serverService, channelID, userId.(string), claimedAt)
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "ファイルのアップロードに失敗しました"})
 				return
 			}
@@ -100,13 +105,15 @@ func (h *MessageHandler) SendChannelMessage(c *gin.Context) {
 
 	// Save message
 	if err := h.messageService.SaveMessage(message); err != nil {
+		releaseSlowmode(h.serverService, channelID, userId.(string), claimedAt)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	// レスポンスを返す
 	c.JSON(http.StatusCreated, gin.H{
-		"message": message,
+		"message":  message,
+		"cooldown": slowmodeCooldown(h.serverService, channelID, userId.(string)),
 	})
 
 	// WebSocketでブロードキャスト（WebSocketサービスが設定されている場合）
@@ -195,9 +202,18 @@ func (h *MessageHandler) EditMessage(c *gin.Context) {
 		return
 	}
 
+	original, err := h.messageService.GetChannelMessage(messageID)
+	if err != nil {
+		respondMessageError(c, err)
+		return
+	}
+	if !checkChannelNotArchived(c, h.serverService, original.ChannelId) {
+		return
+	}
+
 	// Edit message
 	if err := h.messageService.EditMessage(messageID, req.Content); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondMessageError(c, err)
 		return
 	}
 
@@ -254,11 +270,18 @@ func (h *MessageHandler) DeleteMessage(c *gin.Context) {
 		return
 	}
 
+	message, err := h.messageService.GetChannelMessage(messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Delete message
 	if err := h.messageService.DeleteMessage(messageID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	recordMessageDelete(h.auditService, h.serverService, c, message)
 
 	// メッセージのチャンネルIDを取得
 	var channelID string
@@ -315,14 +338,14 @@ func (h *MessageHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
-	// Check if user is a member of the channel
-	hasAccess, err := h.serverService.HasChannelAccess(channelID, userId.(string))
+	// Check if user may upload files to the channel
+	canAttach, err := h.serverService.HasChannelPermission(channelID, userId.(string), models.PermissionSendMessages|models.PermissionAttachFiles)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	if !hasAccess {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this channel"})
+	if !canAttach {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You don't have permission to upload files to this channel"})
 		return
 	}
 
@@ -333,6 +356,11 @@ func (h *MessageHandler) UploadFile(c *gin.Context) {
 		return
 	}
 
+	claimedAt, ok := claimSlowmode(c, h.serverService, channelID, userId.(string))
+	if !ok {
+		return
+	}
+
 	fmt.Println("File:", file)
 	fmt.Println("File name:", file.Filename)
 	fmt.Println("File size:", file.Size)
@@ -344,6 +372,7 @@ func (h *MessageHandler) UploadFile(c *gin.Context) {
 	filePath, err := h.messageService.SaveAttachment(file, messageID)
 	if err != nil {
 		fmt.Println("SaveAttachment error:", err)
+		releaseSlowmode(h.serverService, channelID, userId.(string), claimedAt)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "ファイルのアップロードに失敗しました: " + err.Error()})
 		return
 	}
@@ -362,12 +391,14 @@ func (h *MessageHandler) UploadFile(c *gin.Context) {
 
 	// Save message
 	if err := h.messageService.SaveMessage(message); err != nil {
+		releaseSlowmode(h.serverService, channelID, userId.(string), claimedAt)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "File uploaded successfully",
-		"path":    filePath,
+		"message":  "File uploaded successfully",
+		"path":     filePath,
+		"cooldown": slowmodeCooldown(h.serverService, channelID, userId.(string)),
 	})
 }
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"app/models"
 	"app/services"
+	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,17 +32,145 @@ func AuthMiddleware(userService *services.UserService) gin.HandlerFunc {
 			return
 		}
 
-		// Validate the token
+		// Validate the session token or personal access token
 		tokenString := parts[1]
-		userID, err := userService.ValidateToken(tokenString)
+		claims, err := userService.Authenticate(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		// Set the user ID in the context
-		c.Set("userID", userID)
+		// Set the user and session IDs in the context
+		c.Set("userID", claims.UserID)
+		c.Set("sessionID", claims.SessionID)
+		if claims.Scopes != nil {
+			c.Set("scopes", claims.Scopes)
+		}
+		c.Next()
+	}
+}
+
+// RequireVerifiedEmail blocks users who have not verified their email address.
+// It is a no-op unless required is true, and must run after authentication.
+func RequireVerifiedEmail(userService *services.UserService, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !required {
+			c.Next()
+			return
+		}
+
+		verified, err := userService.IsEmailVerified(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email verification"})
+			c.Abort()
+			return
+		}
+		if !verified {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Email address must be verified", "code": "email_not_verified"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAdmin limits a route to site administrators. It must run after authentication.
+func RequireAdmin(userService *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		admin, err := userService.IsAdmin(c.GetString("userID"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check administrator status"})
+			c.Abort()
+			return
+		}
+		if !admin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Administrator access required"})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireScopes restricts personal access tokens to the routes their scopes cover.
+// GET and HEAD requests need readScope, all other methods need writeScope.
+// Login sessions are not scoped and always pass.
+func RequireScopes(readScope, writeScope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := writeScope
+		if c.Request.Method == http.MethodGet || c.Request.Method == http.MethodHead {
+			scope = readScope
+		}
+
+		if !hasScope(c, scope) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Token is missing the required scope", "scope": scope})
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects personal access tokens on account security endpoints
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, scoped := c.Get("scopes"); scoped {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a login session"})
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
+
+// hasScope reports whether the request credentials grant a scope
+func hasScope(c *gin.Context, scope string) bool {
+	value, scoped := c.Get("scopes")
+	if !scoped {
+		return true
+	}
+	for _, s := range value.([]string) {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ThrottleByIP limits how often a client IP may call an endpoint. The IP is resolved
+// by gin, which only honours forwarding headers from the trusted proxies.
+func ThrottleByIP(limiter *services.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ok, retryAfter := limiter.Allow(c.ClientIP()); !ok {
+			log.Printf("Throttled %s %s from %s", c.Request.Method, c.FullPath(), c.ClientIP())
+			respondTooManyRequests(c, retryAfter, "Too many requests, try again later")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// respondTooManyRequests writes a 429 response with a Retry-After header in whole seconds
+func respondTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retryAfter": seconds})
+}
+
+// clientInfo returns the caller's IP address and user agent
+func clientInfo(c *gin.Context) models.ClientInfo {
+	return models.ClientInfo{
+		IPAddress: c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"app/models"
+	"app/services"
+)
+
+// ModerationHandler handles leaving servers, kicks and bans
+type ModerationHandler struct {
+	moderationService *services.ModerationService
+	serverService     *services.ServerService
+	wsService         *services.WebSocketService
+	auditService      *services.AuditLogService
+}
+
+// NewModerationHandler creates a new moderation handler
+func NewModerationHandler(moderationService *services.ModerationService, serverService *services.ServerService) *ModerationHandler {
+	return &ModerationHandler{
+		moderationService: moderationService,
+		serverService:     serverService,
+	}
+}
+
+// SetWebSocketService はWebSocketServiceを設定する
+func (h *ModerationHandler) SetWebSocketService(wsService *services.WebSocketService) {
+	h.wsService = wsService
+}
+
+// SetAuditLogService sets the service administrative actions are recorded with
+func (h *ModerationHandler) SetAuditLogService(auditService *services.AuditLogService) {
+	h.auditService = auditService
+}
+
+// LeaveServer removes the current user from a server
+func (h *ModerationHandler) LeaveServer(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	channelIds, err := h.moderationService.LeaveServer(serverId, userId)
+	if err != nil {
+		respondModerationError(c, err, "サーバーからの退出に失敗しました")
+		return
+	}
+	h.disconnect(userId, channelIds, "left server")
+
+	c.JSON(http.StatusOK, gin.H{"message": "サーバーから退出しました"})
+}
+
+// KickMember removes a member from a server
+func (h *ModerationHandler) KickMember(c *gin.Context) {
+	var req models.ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	targetId := c.Param("userId")
+	if !h.requirePermission(c, serverId, userId, models.PermissionKickMembers, "メンバーをキックする権限がありません") {
+		return
+	}
+
+	channelIds, err := h.moderationService.KickMember(serverId, userId, targetId)
+	if err != nil {
+		respondModerationError(c, err, "メンバーのキックに失敗しました")
+		return
+	}
+	h.disconnect(targetId, channelIds, "kicked from server")
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditMemberKick,
+		TargetType: models.AuditTargetUser,
+		TargetId:   targetId,
+		Reason:     req.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "メンバーをキックしました", "reason": req.Reason})
+}
+
+// BanMember bans a user from a server, removing them if they are a member
+func (h *ModerationHandler) BanMember(c *gin.Context) {
+	var req models.ModerationRequest
+	if err := c.ShouldBindJSON(&req); err != nil && c.Request.ContentLength > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	targetId := c.Param("userId")
+	if !h.requirePermission(c, serverId, userId, models.PermissionBanMembers, "メンバーをBANする権限がありません") {
+		return
+	}
+
+	channelIds, err := h.moderationService.BanMember(serverId, userId, targetId, req.Reason)
+	if err != nil {
+		respondModerationError(c, err, "メンバーのBANに失敗しました")
+		return
+	}
+	h.disconnect(targetId, channelIds, "banned from server")
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditMemberBan,
+		TargetType: models.AuditTargetUser,
+		TargetId:   targetId,
+		Reason:     req.Reason,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "メンバーをBANしました"})
+}
+
+// UnbanMember lifts a ban
+func (h *ModerationHandler) UnbanMember(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requirePermission(c, serverId, userId, models.PermissionBanMembers, "BANを管理する権限がありません") {
+		return
+	}
+
+	targetId := c.Param("userId")
+	if err := h.moderationService.UnbanMember(serverId, targetId); err != nil {
+		respondModerationError(c, err, "BANの解除に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditMemberUnban,
+		TargetType: models.AuditTargetUser,
+		TargetId:   targetId,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "BANを解除しました"})
+}
+
+// ListBans returns the bans of a server
+func (h *ModerationHandler) ListBans(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requirePermission(c, serverId, userId, models.PermissionBanMembers, "BANを管理する権限がありません") {
+		return
+	}
+
+	bans, err := h.moderationService.ListBans(serverId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "BAN一覧の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"bans": bans})
+}
+
+// requirePermission responds with 403 unless the user holds perm in the server
+func (h *ModerationHandler) requirePermission(c *gin.Context, serverId, userId string, perm models.Permission, message string) bool {
+	allowed, err := h.serverService.HasServerPermission(serverId, userId, perm)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": message})
+		return false
+	}
+	return true
+}
+
+// disconnect closes the user's live connections to the server's channels
+func (h *ModerationHandler) disconnect(userId string, channelIds []string, reason string) {
+	if h.wsService != nil {
+		h.wsService.DisconnectUserFromChannels(userId, channelIds, reason)
+	}
+}
+
+// respondModerationError maps moderation service errors to responses
+func respondModerationError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "サーバーが見つかりません"})
+	case errors.Is(err, services.ErrNotServerMember):
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーはこのサーバーのメンバーではありません"})
+	case errors.Is(err, services.ErrUserNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーが見つかりません"})
+	case errors.Is(err, services.ErrBanNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "BANが見つかりません"})
+	case errors.Is(err, services.ErrOwnerCannotLeave):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "オーナーは所有権を譲渡するまでサーバーから退出できません"})
+	case errors.Is(err, services.ErrCannotModerateSelf):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "自分自身をキックまたはBANすることはできません"})
+	case errors.Is(err, services.ErrRoleHierarchy):
+		c.JSON(http.StatusForbidden, gin.H{"error": "自分より上位のロールを持つメンバーには操作できません"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"app/models"
+	"app/services"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// oidcStateCookie binds an authorization request to the browser that started it
+const oidcStateCookie = "oidc_state"
+
+// OIDCHandler handles single sign-on through an OpenID Connect provider
+type OIDCHandler struct {
+	oidcService *services.OIDCService
+}
+
+// NewOIDCHandler creates a new OIDC handler
+func NewOIDCHandler(oidcService *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{oidcService: oidcService}
+}
+
+// Login redirects the browser to the identity provider
+func (h *OIDCHandler) Login(c *gin.Context) {
+	authorization, err := h.oidcService.AuthorizationURL()
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	redirectToProvider(c, authorization)
+}
+
+// Reauthenticate starts a fresh sign-in at the identity provider for the current user. The
+// callback then returns a reauthentication token accepted in place of the password.
+func (h *OIDCHandler) Reauthenticate(c *gin.Context) {
+	authorization, err := h.oidcService.ReauthenticationURL(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	redirectToProvider(c, authorization)
+}
+
+// redirectToProvider sets the state cookie and sends the browser to the provider.
+// SPA clients can ask for the URL instead of following a redirect.
+func redirectToProvider(c *gin.Context, authorization *services.OIDCAuthorization) {
+	setOIDCStateCookie(c, authorization.State, int(time.Until(authorization.ExpiresAt).Seconds()))
+
+	if c.Query("mode") == "json" {
+		c.JSON(http.StatusOK, gin.H{"authorizationUrl": authorization.URL})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authorization.URL)
+}
+
+// setOIDCStateCookie stores the state in an HttpOnly cookie, or removes it when maxAge is
+// negative. Lax lets it through on the provider's top-level redirect back to the callback.
+func setOIDCStateCookie(c *gin.Context, state string, maxAge int) {
+	secure := c.Request.TLS != nil || c.GetHeader("X-Forwarded-Proto") == "https"
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcStateCookie, state, maxAge, "/", "", secure, true)
+}
+
+// Callback completes the login with the authorization code returned by the provider.
+// It accepts the code and state as query parameters or as a JSON body.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if errParam := c.Query("error"); errParam != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errParam, "description": c.Query("error_description")})
+		return
+	}
+
+	var req models.OIDCCallbackRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Only the browser that started the login may complete it, so a victim cannot be
+	// signed in with someone else's code and state
+	cookieState, _ := c.Cookie(oidcStateCookie)
+	setOIDCStateCookie(c, "", -1)
+	if cookieState == "" || subtle.ConstantTimeCompare([]byte(cookieState), []byte(req.State)) != 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrInvalidOIDCState.Error()})
+		return
+	}
+
+	result, err := h.oidcService.HandleCallback(req.Code, req.State, clientInfo(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidOIDCState):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOIDCAccountConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOIDCIdentityMismatch):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	switch {
+	case result.Reauth != nil:
+		c.JSON(http.StatusOK, result.Reauth)
+	case result.Challenge != nil:
+		// Two-factor authentication is enabled; the client must call /login/totp next
+		c.JSON(http.StatusOK, result.Challenge)
+	default:
+		c.JSON(http.StatusOK, result.User)
+	}
+}
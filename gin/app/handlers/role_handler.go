@@ -0,0 +1,438 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"app/models"
+	"app/services"
+)
+
+// RoleHandler handles server role requests
+type RoleHandler struct {
+	roleService   *services.RoleService
+	serverService *services.ServerService
+	auditService  *services.AuditLogService
+	wsService     *services.WebSocketService
+}
+
+// NewRoleHandler creates a new role handler
+func NewRoleHandler(roleService *services.RoleService, serverService *services.ServerService) *RoleHandler {
+	return &RoleHandler{
+		roleService:   roleService,
+		serverService: serverService,
+	}
+}
+
+// SetAuditLogService sets the service administrative actions are recorded with
+func (h *RoleHandler) SetAuditLogService(auditService *services.AuditLogService) {
+	h.auditService = auditService
+}
+
+// SetWebSocketService sets the service used to update the channel state of connected users
+func (h *RoleHandler) SetWebSocketService(wsService *services.WebSocketService) {
+	h.wsService = wsService
+}
+
+// ListRoles returns the roles of a server from highest to lowest
+func (h *RoleHandler) ListRoles(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	isMember, err := h.serverService.IsServerMember(serverId, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーメンバーの確認に失敗しました"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーにアクセスする権限がありません"})
+		return
+	}
+
+	roles, err := h.roleService.ListRoles(serverId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ロールの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// GetMyPermissions returns the caller's effective permissions in a server; 0 for non-members
+func (h *RoleHandler) GetMyPermissions(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	permissions, err := h.serverService.GetServerPermissions(serverId, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の取得に失敗しました"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"permissions": permissions})
+}
+
+// CreateRole adds a new role to a server
+func (h *RoleHandler) CreateRole(c *gin.Context) {
+	var req models.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requireManageRoles(c, serverId, userId) {
+		return
+	}
+
+	role, err := h.roleService.CreateRole(serverId, userId, req)
+	if err != nil {
+		respondRoleError(c, err, "ロールの作成に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditRoleCreate,
+		TargetType: models.AuditTargetRole,
+		TargetId:   role.ID,
+		Changes:    services.AuditDiff(nil, role),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"role": role})
+}
+
+// UpdateRole changes the name, color and permissions of a role
+func (h *RoleHandler) UpdateRole(c *gin.Context) {
+	var req models.RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requireManageRoles(c, serverId, userId) {
+		return
+	}
+
+	before, err := h.roleService.GetRole(serverId, c.Param("roleId"))
+	if err != nil {
+		respondRoleError(c, err, "ロールの取得に失敗しました")
+		return
+	}
+
+	role, err := h.roleService.UpdateRole(serverId, before.ID, userId, req)
+	if err != nil {
+		respondRoleError(c, err, "ロールの更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditRoleUpdate,
+		TargetType: models.AuditTargetRole,
+		TargetId:   role.ID,
+		Changes:    services.AuditDiff(before, role),
+	})
+	h.refreshServerChannelStates(serverId)
+
+	c.JSON(http.StatusOK, gin.H{"role": role})
+}
+
+// DeleteRole removes a role from a server
+func (h *RoleHandler) DeleteRole(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requireManageRoles(c, serverId, userId) {
+		return
+	}
+
+	role, err := h.roleService.GetRole(serverId, c.Param("roleId"))
+	if err != nil {
+		respondRoleError(c, err, "ロールの取得に失敗しました")
+		return
+	}
+
+	if err := h.roleService.DeleteRole(serverId, role.ID, userId); err != nil {
+		respondRoleError(c, err, "ロールの削除に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditRoleDelete,
+		TargetType: models.AuditTargetRole,
+		TargetId:   role.ID,
+		Changes:    services.AuditDiff(role, nil),
+	})
+	h.refreshServerChannelStates(serverId)
+
+	c.JSON(http.StatusOK, gin.H{"message": "ロールを削除しました"})
+}
+
+// ReorderRoles changes the hierarchy of a server's roles
+func (h *RoleHandler) ReorderRoles(c *gin.Context) {
+	var req models.ReorderRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requireManageRoles(c, serverId, userId) {
+		return
+	}
+
+	before, err := h.roleService.ListRoles(serverId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "ロールの取得に失敗しました"})
+		return
+	}
+
+	roles, err := h.roleService.ReorderRoles(serverId, userId, req.RoleIds)
+	if err != nil {
+		respondRoleError(c, err, "ロールの並び替えに失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditRoleReorder,
+		TargetType: models.AuditTargetServer,
+		TargetId:   serverId,
+		Changes:    []models.AuditChange{{Key: "roleIds", Before: roleIds(before), After: roleIds(roles)}},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// SetMemberRoles replaces the roles held by a server member
+func (h *RoleHandler) SetMemberRoles(c *gin.Context) {
+	var req models.MemberRolesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requireManageRoles(c, serverId, userId) {
+		return
+	}
+
+	targetId := c.Param("userId")
+	before, err := h.roleService.GetMemberRoles(serverId, targetId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メンバーのロールの取得に失敗しました"})
+		return
+	}
+
+	roles, err := h.roleService.SetMemberRoles(serverId, userId, targetId, req.RoleIds)
+	if err != nil {
+		respondRoleError(c, err, "メンバーのロール更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditMemberRolesUpdate,
+		TargetType: models.AuditTargetUser,
+		TargetId:   targetId,
+		Changes:    []models.AuditChange{{Key: "roleIds", Before: roleIds(before), After: roleIds(roles)}},
+	})
+	h.refreshServerChannelStates(serverId)
+
+	c.JSON(http.StatusOK, gin.H{"roles": roles})
+}
+
+// ListOverwrites returns the permission overwrites of a channel
+func (h *RoleHandler) ListOverwrites(c *gin.Context) {
+	channelId := c.Param("id")
+	if _, ok := h.requireManageRolesInChannel(c, channelId, c.GetString("userID")); !ok {
+		return
+	}
+
+	overwrites, err := h.roleService.ListOverwrites(channelId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の上書き設定の取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"overwrites": overwrites})
+}
+
+// SetOverwrite allows or denies permissions in a channel for a role or member
+func (h *RoleHandler) SetOverwrite(c *gin.Context) {
+	var req models.OverwriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channelId := c.Param("id")
+	userId := c.GetString("userID")
+	serverId, ok := h.requireManageRolesInChannel(c, channelId, userId)
+	if !ok {
+		return
+	}
+
+	before, err := h.findOverwrite(channelId, c.Param("targetType"), c.Param("targetId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の上書き設定の取得に失敗しました"})
+		return
+	}
+
+	overwrite, err := h.roleService.SetOverwrite(channelId, userId, c.Param("targetType"), c.Param("targetId"), req)
+	if err != nil {
+		respondRoleError(c, err, "権限の上書き設定の保存に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditOverwriteUpdate,
+		TargetType: models.AuditTargetChannel,
+		TargetId:   channelId,
+		Changes:    services.AuditDiff(before, overwrite),
+	})
+	refreshChannelState(h.wsService, h.serverService, channelId)
+
+	c.JSON(http.StatusOK, gin.H{"overwrite": overwrite})
+}
+
+// DeleteOverwrite removes a channel's overwrite for a role or member
+func (h *RoleHandler) DeleteOverwrite(c *gin.Context) {
+	channelId := c.Param("id")
+	userId := c.GetString("userID")
+	serverId, ok := h.requireManageRolesInChannel(c, channelId, userId)
+	if !ok {
+		return
+	}
+
+	before, err := h.findOverwrite(channelId, c.Param("targetType"), c.Param("targetId"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の上書き設定の取得に失敗しました"})
+		return
+	}
+
+	if err := h.roleService.DeleteOverwrite(channelId, userId, c.Param("targetType"), c.Param("targetId")); err != nil {
+		respondRoleError(c, err, "権限の上書き設定の削除に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditOverwriteDelete,
+		TargetType: models.AuditTargetChannel,
+		TargetId:   channelId,
+		Changes:    services.AuditDiff(before, nil),
+	})
+	refreshChannelState(h.wsService, h.serverService, channelId)
+
+	c.JSON(http.StatusOK, gin.H{"message": "権限の上書き設定を削除しました"})
+}
+
+// requireManageRolesInChannel responds with 404 or 403 unless the user may manage roles in
+// the channel itself, which needs view access to it, and returns the channel's server ID
+func (h *RoleHandler) requireManageRolesInChannel(c *gin.Context, channelId, userId string) (string, bool) {
+	serverId, err := h.serverService.GetServerIdByChannelId(channelId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+		return "", false
+	}
+
+	allowed, err := h.serverService.HasChannelPermission(channelId, userId, models.PermissionManageRoles)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return "", false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このチャンネルのロールを管理する権限がありません"})
+		return "", false
+	}
+	return serverId, true
+}
+
+// refreshServerChannelStates refreshes the channel state of every channel in the server,
+// after a change to roles that may alter permissions anywhere in it
+func (h *RoleHandler) refreshServerChannelStates(serverId string) {
+	if h.wsService == nil {
+		return
+	}
+	channelIds, err := h.serverService.GetServerChannelIds(serverId)
+	if err != nil {
+		log.Printf("チャンネル一覧の取得に失敗: %v", err)
+		return
+	}
+	for _, channelId := range channelIds {
+		refreshChannelState(h.wsService, h.serverService, channelId)
+	}
+}
+
+// findOverwrite returns the channel's overwrite for the target, or nil if it has none
+func (h *RoleHandler) findOverwrite(channelId, targetType, targetId string) (*models.ChannelOverwrite, error) {
+	overwrites, err := h.roleService.ListOverwrites(channelId)
+	if err != nil {
+		return nil, err
+	}
+	for i := range overwrites {
+		if overwrites[i].TargetType == targetType && overwrites[i].TargetId == targetId {
+			return &overwrites[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// roleIds returns the IDs of the roles in order
+func roleIds(roles []models.Role) []string {
+	ids := make([]string, len(roles))
+	for i, role := range roles {
+		ids[i] = role.ID
+	}
+	return ids
+}
+
+// requireManageRoles responds with 403 unless the user may manage roles in the server
+func (h *RoleHandler) requireManageRoles(c *gin.Context, serverId, userId string) bool {
+	allowed, err := h.serverService.HasServerPermission(serverId, userId, models.PermissionManageRoles)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "ロールを管理する権限がありません"})
+		return false
+	}
+	return true
+}
+
+// respondRoleError maps role service errors to responses
+func respondRoleError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "サーバーが見つかりません"})
+	case errors.Is(err, services.ErrRoleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "ロールが見つかりません"})
+	case errors.Is(err, services.ErrChannelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+	case errors.Is(err, services.ErrInvalidOverwrite):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "上書きできるのは閲覧・送信・ファイル添付・メッセージ管理の権限のみで、許可と拒否を同時に指定することはできません"})
+	case errors.Is(err, services.ErrNotServerMember):
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーはこのサーバーのメンバーではありません"})
+	case errors.Is(err, services.ErrRoleHierarchy):
+		c.JSON(http.StatusForbidden, gin.H{"error": "自分の最上位ロールより下のロールとメンバーのみ管理できます"})
+	case errors.Is(err, services.ErrPermissionEscalation):
+		c.JSON(http.StatusForbidden, gin.H{"error": "自分が持っていない権限は付与できません"})
+	case errors.Is(err, services.ErrDefaultRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "@everyoneロールは削除・移動・付与できません"})
+	case errors.Is(err, services.ErrInvalidRoleOrder):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "@everyone以外のすべてのロールを一度ずつ指定してください"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
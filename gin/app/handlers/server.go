@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -14,6 +17,8 @@ import (
 // ServerHandler handles server-related requests
 type ServerHandler struct {
 	serverService *services.ServerService
+	wsService     *services.WebSocketService
+	auditService  *services.AuditLogService
 }
 
 // NewServerHandler creates a new server handler
@@ -23,6 +28,16 @@ func NewServerHandler(serverService *services.ServerService) *ServerHandler {
 	}
 }
 
+// SetWebSocketService はWebSocketServiceを設定する
+func (h *ServerHandler) SetWebSocketService(wsService *services.WebSocketService) {
+	h.wsService = wsService
+}
+
+// SetAuditLogService sets the service administrative actions are recorded with
+func (h *ServerHandler) SetAuditLogService(auditService *services.AuditLogService) {
+	h.auditService = auditService
+}
+
 // CreateServer handles the creation of a new server
 func (h *ServerHandler) CreateServer(c *gin.Context) {
 	var req models.ServerRequest
@@ -47,40 +62,53 @@ func (h *ServerHandler) CreateServer(c *gin.Context) {
 		UpdatedAt:   time.Now(),
 	}
 
-	if err := h.serverService.CreateServer(server); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーの作成に失敗しました"})
-		return
-	}
+	// Servers created from a template get its channels and roles instead of "general"
+	if req.Template != "" {
+		template, err := h.serverService.GetTemplate(req.Template, server.OwnerId)
+		if err != nil {
+			respondServerError(c, err, "テンプレートの取得に失敗しました")
+			return
+		}
+		if err := h.serverService.CreateServerFromTemplate(server, template.Structure); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーの作成に失敗しました"})
+			return
+		}
+	} else {
+		if err := h.serverService.CreateServer(server); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーの作成に失敗しました"})
+			return
+		}
 
-	// Create default "general" channel
-	channel := models.Channel{
-		ID:          uuid.New().String(),
-		ServerId:    server.ID,
-		Name:        "general",
-		Description: "General discussion",
-		IsPrivate:   false,
-		CreatedAt:   time.Now(),
-		UpdatedAt:   time.Now(),
-	}
+		// Create default "general" channel
+		channel := models.Channel{
+			ID:          uuid.New().String(),
+			ServerId:    server.ID,
+			Name:        "general",
+			Description: "General discussion",
+			IsPrivate:   false,
+			CreatedAt:   time.Now(),
+			UpdatedAt:   time.Now(),
+		}
 
-	if err := h.serverService.CreateChannel(channel); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルの作成に失敗しました"})
-		return
-	}
+		if err := h.serverService.CreateChannel(&channel); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルの作成に失敗しました"})
+			return
+		}
 
-	// Add owner as a member with "owner" role
-	member := models.ServerMember{
-		ID:        uuid.New().String(),
-		ServerId:  server.ID,
-		UserId:    userId.(string),
-		Role:      "owner",
-		JoinedAt:  time.Now(),
-		UpdatedAt: time.Now(),
-	}
+		// Add owner as a member with "owner" role
+		member := models.ServerMember{
+			ID:        uuid.New().String(),
+			ServerId:  server.ID,
+			UserId:    userId.(string),
+			Role:      "owner",
+			JoinedAt:  time.Now(),
+			UpdatedAt: time.Now(),
+		}
 
-	if err := h.serverService.AddServerMember(member); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "メンバーの追加に失敗しました"})
-		return
+		if err := h.serverService.AddServerMember(member); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "メンバーの追加に失敗しました"})
+			return
+		}
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -90,6 +118,7 @@ func (h *ServerHandler) CreateServer(c *gin.Context) {
 			Name:        server.Name,
 			Description: server.Description,
 			OwnerId:     server.OwnerId,
+			Tags:        []string{},
 			CreatedAt:   server.CreatedAt,
 			MemberCount: 1,
 		},
@@ -113,6 +142,273 @@ func (h *ServerHandler) GetUserServers(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"servers": servers})
 }
 
+// GetServer returns a server's settings
+func (h *ServerHandler) GetServer(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	isMember, err := h.serverService.IsServerMember(serverId, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーメンバーの確認に失敗しました"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーにアクセスする権限がありません"})
+		return
+	}
+
+	server, err := h.serverService.GetServer(serverId)
+	if err != nil {
+		respondServerError(c, err, "サーバーの取得に失敗しました")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"server": server})
+}
+
+// UpdateServer changes a server's name, description and join settings
+func (h *ServerHandler) UpdateServer(c *gin.Context) {
+	var req models.UpdateServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	if !h.requireManageServer(c, serverId, c.GetString("userID")) {
+		return
+	}
+
+	before, err := h.serverService.GetServer(serverId)
+	if err != nil {
+		respondServerError(c, err, "サーバーの取得に失敗しました")
+		return
+	}
+
+	server, err := h.serverService.UpdateServer(serverId, req)
+	if err != nil {
+		respondServerError(c, err, "サーバー設定の更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditServerUpdate,
+		TargetType: models.AuditTargetServer,
+		TargetId:   serverId,
+		Changes:    services.AuditDiff(before, server),
+	})
+	c.JSON(http.StatusOK, gin.H{"server": server})
+}
+
+// SetDirectJoin enables or disables joining a server by ID without an invite. It predates
+// UpdateServer and is kept so that existing clients keep working.
+func (h *ServerHandler) SetDirectJoin(c *gin.Context) {
+	var req models.DirectJoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	if !h.requireManageServer(c, serverId, c.GetString("userID")) {
+		return
+	}
+
+	before, err := h.serverService.GetServer(serverId)
+	if err != nil {
+		respondServerError(c, err, "サーバーの取得に失敗しました")
+		return
+	}
+
+	server, err := h.serverService.UpdateServer(serverId, models.UpdateServerRequest{AllowDirectJoin: &req.AllowDirectJoin})
+	if err != nil {
+		respondServerError(c, err, "サーバー設定の更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditServerUpdate,
+		TargetType: models.AuditTargetServer,
+		TargetId:   serverId,
+		Changes:    services.AuditDiff(before, server),
+	})
+	c.JSON(http.StatusOK, gin.H{"allowDirectJoin": server.AllowDirectJoin})
+}
+
+// UploadServerIcon replaces a server's icon image
+func (h *ServerHandler) UploadServerIcon(c *gin.Context) {
+	serverId := c.Param("id")
+	if !h.requireManageServer(c, serverId, c.GetString("userID")) {
+		return
+	}
+
+	file, err := c.FormFile("icon")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "アイコン画像が必要です"})
+		return
+	}
+
+	before, err := h.serverService.GetServer(serverId)
+	if err != nil {
+		respondServerError(c, err, "サーバーの取得に失敗しました")
+		return
+	}
+
+	server, err := h.serverService.UpdateServerIcon(serverId, file)
+	if err != nil {
+		respondServerError(c, err, "アイコンの更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditServerUpdate,
+		TargetType: models.AuditTargetServer,
+		TargetId:   serverId,
+		Changes:    services.AuditDiff(before, server),
+	})
+	c.JSON(http.StatusOK, gin.H{"server": server})
+}
+
+// TransferOwnership hands the server to another member
+func (h *ServerHandler) TransferOwnership(c *gin.Context) {
+	var req models.TransferOwnershipRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if req.UserId == userId {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "すでにこのサーバーのオーナーです"})
+		return
+	}
+
+	if err := h.serverService.TransferOwnership(serverId, userId, req.UserId); err != nil {
+		respondServerError(c, err, "所有権の譲渡に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditServerTransfer,
+		TargetType: models.AuditTargetServer,
+		TargetId:   serverId,
+		Changes:    []models.AuditChange{{Key: "ownerId", Before: userId, After: req.UserId}},
+	})
+	c.JSON(http.StatusOK, gin.H{"message": "サーバーの所有権を譲渡しました", "ownerId": req.UserId})
+}
+
+// DeleteServer permanently deletes a server and everything in it
+func (h *ServerHandler) DeleteServer(c *gin.Context) {
+	serverId := c.Param("id")
+
+	server, err := h.serverService.GetServer(serverId)
+	if err != nil {
+		respondServerError(c, err, "サーバーの取得に失敗しました")
+		return
+	}
+	if server.OwnerId != c.GetString("userID") {
+		c.JSON(http.StatusForbidden, gin.H{"error": "サーバーを削除できるのはオーナーのみです"})
+		return
+	}
+
+	channelIds, err := h.serverService.DeleteServer(serverId)
+	if err != nil {
+		respondServerError(c, err, "サーバーの削除に失敗しました")
+		return
+	}
+
+	// 削除されたチャンネルのWebSocket接続を切断
+	if h.wsService != nil {
+		h.wsService.DisconnectChannels(channelIds, "server deleted")
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "サーバーを削除しました"})
+}
+
+// requireManageServer responds with 403 unless the user may change the server's settings
+func (h *ServerHandler) requireManageServer(c *gin.Context, serverId, userId string) bool {
+	allowed, err := h.serverService.HasServerPermission(serverId, userId, models.PermissionManageServer)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "サーバー設定を変更する権限がありません"})
+		return false
+	}
+	return true
+}
+
+// respondServerError maps server service errors to responses
+func respondServerError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrServerNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "サーバーが見つかりません"})
+	case errors.Is(err, services.ErrNotServerOwner):
+		c.JSON(http.StatusForbidden, gin.H{"error": "この操作はサーバーのオーナーのみ実行できます"})
+	case errors.Is(err, services.ErrNotServerMember):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ユーザーはこのサーバーのメンバーではありません"})
+	case errors.Is(err, services.ErrInvalidServerIcon):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "アイコンは5MB以下の画像にしてください"})
+	case errors.Is(err, services.ErrTemplateNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "テンプレートが見つかりません"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// ListMembers returns a page of the server's members with their roles and online status.
+// The role query parameter limits the list to members holding that role.
+func (h *ServerHandler) ListMembers(c *gin.Context) {
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+
+	isMember, err := h.serverService.IsServerMember(serverId, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーメンバーの確認に失敗しました"})
+		return
+	}
+	if !isMember {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーにアクセスする権限がありません"})
+		return
+	}
+
+	roleId := c.Query("role")
+	if roleId != "" {
+		if _, err := uuid.Parse(roleId); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ロールIDが不正です"})
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	members, err := h.serverService.ListServerMembers(serverId, roleId, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "メンバー一覧の取得に失敗しました"})
+		return
+	}
+
+	// オンライン状態はWebSocketハブの接続から判定する
+	if h.wsService != nil {
+		userIds := make([]string, len(members.Members))
+		for i, member := range members.Members {
+			userIds[i] = member.UserId
+		}
+		online := h.wsService.OnlineUsers(userIds)
+		for i := range members.Members {
+			members.Members[i].Online = online[members.Members[i].UserId]
+		}
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
 // GetServerChannels returns all channels in a server
 func (h *ServerHandler) GetServerChannels(c *gin.Context) {
 	// Get server ID from URL parameter
@@ -172,15 +468,15 @@ func (h *ServerHandler) CreateChannel(c *gin.Context) {
 		return
 	}
 
-	// Check if user has permission to create channels (owner only)
-	hasPermission, err := h.serverService.HasChannelManagementPermission(serverId, userId.(string))
+	// Check if user has permission to create channels
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId.(string), models.PermissionManageChannels)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
 		return
 	}
 
 	if !hasPermission {
-		c.JSON(http.StatusForbidden, gin.H{"error": "チャンネルを作成できるのはサーバーの作成者のみです"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "チャンネルを作成する権限がありません"})
 		return
 	}
 
@@ -205,11 +501,13 @@ func (h *ServerHandler) CreateChannel(c *gin.Context) {
 		Name:        req.Name,
 		Description: req.Description,
 		IsPrivate:   req.IsPrivate,
+		Mode:        req.Mode,
+		Slowmode:    req.Slowmode,
 		CreatedAt:   time.Now(),
 		UpdatedAt:   time.Now(),
 	}
 
-	if err := h.serverService.CreateChannel(channel); err != nil {
+	if err := h.serverService.CreateChannel(&channel); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルの作成に失敗しました"})
 		return
 	}
@@ -229,17 +527,29 @@ func (h *ServerHandler) CreateChannel(c *gin.Context) {
 		}
 	}
 
+	response := models.ChannelResponse{
+		ID:          channel.ID,
+		ServerId:    channel.ServerId,
+		CategoryId:  channel.CategoryId,
+		Name:        channel.Name,
+		Description: channel.Description,
+		IsPrivate:   channel.IsPrivate,
+		Mode:        channel.Mode,
+		Slowmode:    channel.Slowmode,
+		Position:    channel.Position,
+		CreatedAt:   channel.CreatedAt,
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditChannelCreate,
+		TargetType: models.AuditTargetChannel,
+		TargetId:   channel.ID,
+		Changes:    services.AuditDiff(nil, response),
+	})
 	c.JSON(http.StatusCreated, gin.H{
 		"message": "チャンネルが作成されました",
-		"channel": models.ChannelResponse{
-			ID:          channel.ID,
-			ServerId:    channel.ServerId,
-			CategoryId:  channel.CategoryId,
-			Name:        channel.Name,
-			Description: channel.Description,
-			IsPrivate:   channel.IsPrivate,
-			CreatedAt:   channel.CreatedAt,
-		},
+		"channel": response,
 	})
 }
 
@@ -260,7 +570,7 @@ func (h *ServerHandler) AddChannelMember(c *gin.Context) {
 	}
 
 	var req struct {
-		UserId string `json:"userId" binding:"required"`
+		UserId string `json:"userId" binding:"required,uuid"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -281,7 +591,13 @@ func (h *ServerHandler) AddChannelMember(c *gin.Context) {
 	}
 
 	// Check if user has permission to add members
-	hasPermission, err := h.serverService.HasChannelManagementPermission(channelId, userId.(string))
+	serverId, err := h.serverService.GetServerIdByChannelId(channelId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバー情報の取得に失敗しました"})
+		return
+	}
+
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId.(string), models.PermissionManageChannels)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
 		return
@@ -293,12 +609,6 @@ func (h *ServerHandler) AddChannelMember(c *gin.Context) {
 	}
 
 	// Check if target user is a member of the server
-	serverId, err := h.serverService.GetServerIdByChannelId(channelId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバー情報の取得に失敗しました"})
-		return
-	}
-
 	isMember, err := h.serverService.IsServerMember(serverId, req.UserId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "サーバーメンバーの確認に失敗しました"})
@@ -319,10 +629,21 @@ func (h *ServerHandler) AddChannelMember(c *gin.Context) {
 	}
 
 	if err := h.serverService.AddChannelMember(channelMember); err != nil {
+		if errors.Is(err, services.ErrAlreadyChannelMember) {
+			c.JSON(http.StatusConflict, gin.H{"error": "ユーザーはすでにこのチャンネルのメンバーです"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルメンバーの追加に失敗しました"})
 		return
 	}
 
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditChannelMemberAdd,
+		TargetType: models.AuditTargetUser,
+		TargetId:   req.UserId,
+		Changes:    []models.AuditChange{{Key: "channelId", After: channelId}},
+	})
 	c.JSON(http.StatusOK, gin.H{"message": "メンバーがチャンネルに追加されました"})
 }
 
@@ -342,6 +663,17 @@ func (h *ServerHandler) JoinServer(c *gin.Context) {
 		return
 	}
 
+	// Servers that disable direct join can only be joined through an invite
+	allowDirectJoin, err := h.serverService.AllowsDirectJoin(serverId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "サーバーが見つかりません"})
+		return
+	}
+	if !allowDirectJoin {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーには招待リンクからのみ参加できます"})
+		return
+	}
+
 	// Check if user is already a member
 	isMember, err := h.serverService.IsServerMember(serverId, userId.(string))
 	if err != nil {
@@ -354,6 +686,16 @@ func (h *ServerHandler) JoinServer(c *gin.Context) {
 		return
 	}
 
+	banned, err := h.serverService.IsBanned(serverId, userId.(string))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "BAN状態の確認に失敗しました"})
+		return
+	}
+	if banned {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このサーバーからBANされています"})
+		return
+	}
+
 	// Add user as a member with "member" role
 	member := models.ServerMember{
 		ID:        uuid.New().String(),
@@ -394,15 +736,15 @@ func (h *ServerHandler) CreateCategory(c *gin.Context) {
 		return
 	}
 
-	// Check if user has permission to create categories (owner only)
-	hasPermission, err := h.serverService.HasChannelManagementPermission(serverId, userId.(string))
+	// Check if user has permission to create categories
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId.(string), models.PermissionManageChannels)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
 		return
 	}
 
 	if !hasPermission {
-		c.JSON(http.StatusForbidden, gin.H{"error": "カテゴリーを作成できるのはサーバーの作成者のみです"})
+		c.JSON(http.StatusForbidden, gin.H{"error": "カテゴリーを作成する権限がありません"})
 		return
 	}
 
@@ -432,15 +774,24 @@ func (h *ServerHandler) CreateCategory(c *gin.Context) {
 		return
 	}
 
+	response := models.CategoryResponse{
+		ID:        category.ID,
+		ServerId:  category.ServerId,
+		Name:      category.Name,
+		Position:  category.Position,
+		CreatedAt: category.CreatedAt,
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditCategoryCreate,
+		TargetType: models.AuditTargetCategory,
+		TargetId:   category.ID,
+		Changes:    services.AuditDiff(nil, response),
+	})
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "カテゴリーが作成されました",
-		"category": models.CategoryResponse{
-			ID:        category.ID,
-			ServerId:  category.ServerId,
-			Name:      category.Name,
-			Position:  category.Position,
-			CreatedAt: category.CreatedAt,
-		},
+		"message":  "カテゴリーが作成されました",
+		"category": response,
 	})
 }
 
@@ -514,7 +865,7 @@ func (h *ServerHandler) UpdateChannelCategory(c *gin.Context) {
 	}
 
 	// Check if user has permission to manage channels
-	hasPermission, err := h.serverService.HasChannelManagementPermission(serverId, userId.(string))
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId.(string), models.PermissionManageChannels)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
 		return
@@ -525,12 +876,29 @@ func (h *ServerHandler) UpdateChannelCategory(c *gin.Context) {
 		return
 	}
 
+	before, err := h.serverService.GetChannelByID(channelId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+		return
+	}
+
 	// Update the channel's category
 	if err := h.serverService.UpdateChannelCategory(channelId, req.CategoryId); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルの更新に失敗しました"})
 		return
 	}
 
+	if channel, err := h.serverService.GetChannelByID(channelId); err == nil {
+		recordAudit(h.auditService, c, models.AuditLogEntry{
+			ServerId:   serverId,
+			Action:     models.AuditChannelUpdate,
+			TargetType: models.AuditTargetChannel,
+			TargetId:   channelId,
+			Changes:    services.AuditDiff(before, channel),
+		})
+		h.broadcastChannelEvent(channel, "channel_update", channel)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"message": "チャンネルのカテゴリーが更新されました"})
 }
 
@@ -550,24 +918,45 @@ func (h *ServerHandler) DeleteChannel(c *gin.Context) {
 		return
 	}
 
-	// Check if the user is the owner of the server that contains this channel
-	isOwner, err := h.serverService.IsUserServerOwnerByChannelId(channelId, userId.(string))
+	// Check if the user can manage channels in the server that contains this channel
+	serverId, err := h.serverService.GetServerIdByChannelId(channelId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+		return
+	}
+
+	hasPermission, err := h.serverService.HasServerPermission(serverId, userId.(string), models.PermissionManageChannels)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
-	if !isOwner {
+	if !hasPermission {
 		c.JSON(http.StatusForbidden, gin.H{"error": "このチャンネルを削除する権限がありません"})
 		return
 	}
 
+	channel, err := h.serverService.GetChannelByID(channelId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+		return
+	}
+
 	// Delete the channel
 	if err := h.serverService.DeleteChannel(channelId); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditChannelDelete,
+		TargetType: models.AuditTargetChannel,
+		TargetId:   channelId,
+		Changes:    services.AuditDiff(channel, nil),
+	})
+	h.broadcastChannelEvent(channel, "channel_delete", gin.H{"id": channel.ID, "serverId": channel.ServerId})
+
 	c.JSON(http.StatusOK, gin.H{"message": "チャンネルが削除されました"})
 }
 
@@ -601,3 +990,387 @@ func (h *ServerHandler) GetChannel(c *gin.Context) {
 
 	c.JSON(http.StatusOK, channel)
 }
+
+// UpdateChannel changes a channel's name, description, mode or slowmode interval
+func (h *ServerHandler) UpdateChannel(c *gin.Context) {
+	var req models.UpdateChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	channel, err := h.serverService.GetChannelByID(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+		return
+	}
+	if !h.requireManageChannels(c, channel.ServerId, c.GetString("userID")) {
+		return
+	}
+
+	before := channel
+	channel, err = h.serverService.UpdateChannel(channel.ID, req)
+	if err != nil {
+		respondLayoutError(c, err, "チャンネルの更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   channel.ServerId,
+		Action:     models.AuditChannelUpdate,
+		TargetType: models.AuditTargetChannel,
+		TargetId:   channel.ID,
+		Changes:    services.AuditDiff(before, channel),
+	})
+
+	h.broadcastChannelEvent(channel, "channel_update", channel)
+	if channel.Mode != before.Mode || channel.Slowmode != before.Slowmode {
+		h.broadcastChannelState(channel.ID)
+	}
+	c.JSON(http.StatusOK, gin.H{"channel": channel})
+}
+
+// UpdateCategory renames a category
+func (h *ServerHandler) UpdateCategory(c *gin.Context) {
+	var req models.CategoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	categoryId := c.Param("categoryId")
+	if _, err := uuid.Parse(categoryId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "カテゴリーが見つかりません"})
+		return
+	}
+	if !h.requireManageChannels(c, serverId, c.GetString("userID")) {
+		return
+	}
+
+	before, err := h.serverService.GetCategory(serverId, categoryId)
+	if err != nil {
+		respondLayoutError(c, err, "カテゴリーの取得に失敗しました")
+		return
+	}
+
+	category, err := h.serverService.UpdateCategory(serverId, categoryId, req.Name)
+	if err != nil {
+		respondLayoutError(c, err, "カテゴリーの更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditCategoryUpdate,
+		TargetType: models.AuditTargetCategory,
+		TargetId:   categoryId,
+		Changes:    services.AuditDiff(before, category),
+	})
+
+	h.broadcastServerEvent(serverId, "category_update", category)
+	c.JSON(http.StatusOK, gin.H{"category": category})
+}
+
+// DeleteCategory deletes a category. Its channels move to the category given in the
+// moveTo query parameter, or out of any category when it is omitted.
+func (h *ServerHandler) DeleteCategory(c *gin.Context) {
+	serverId := c.Param("id")
+	categoryId := c.Param("categoryId")
+	moveTo := c.Query("moveTo")
+	if _, err := uuid.Parse(categoryId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "カテゴリーが見つかりません"})
+		return
+	}
+	if moveTo != "" {
+		if _, err := uuid.Parse(moveTo); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "移動先のカテゴリーIDが不正です"})
+			return
+		}
+	}
+	if !h.requireManageChannels(c, serverId, c.GetString("userID")) {
+		return
+	}
+
+	category, err := h.serverService.GetCategory(serverId, categoryId)
+	if err != nil {
+		respondLayoutError(c, err, "カテゴリーの取得に失敗しました")
+		return
+	}
+
+	if err := h.serverService.DeleteCategory(serverId, categoryId, moveTo); err != nil {
+		respondLayoutError(c, err, "カテゴリーの削除に失敗しました")
+		return
+	}
+
+	changes := services.AuditDiff(category, nil)
+	if moveTo != "" {
+		changes = append(changes, models.AuditChange{Key: "moveTo", After: moveTo})
+	}
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditCategoryDelete,
+		TargetType: models.AuditTargetCategory,
+		TargetId:   categoryId,
+		Changes:    changes,
+	})
+
+	h.broadcastServerEvent(serverId, "category_delete", gin.H{"id": categoryId, "serverId": serverId, "moveTo": moveTo})
+	c.JSON(http.StatusOK, gin.H{"message": "カテゴリーが削除されました"})
+}
+
+// ReorderLayout reorders a server's categories and moves and reorders its channels atomically
+func (h *ServerHandler) ReorderLayout(c *gin.Context) {
+	var req models.LayoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	if !h.requireManageChannels(c, serverId, c.GetString("userID")) {
+		return
+	}
+
+	if err := h.serverService.ReorderLayout(serverId, req); err != nil {
+		respondLayoutError(c, err, "並び順の更新に失敗しました")
+		return
+	}
+
+	recordAudit(h.auditService, c, models.AuditLogEntry{
+		ServerId:   serverId,
+		Action:     models.AuditLayoutUpdate,
+		TargetType: models.AuditTargetServer,
+		TargetId:   serverId,
+		Changes:    services.AuditDiff(nil, req),
+	})
+
+	// 非公開チャンネルの情報を漏らさないよう、クライアントには再取得だけを促す
+	h.broadcastServerEvent(serverId, "layout_update", gin.H{"serverId": serverId})
+	c.JSON(http.StatusOK, gin.H{"message": "並び順が更新されました"})
+}
+
+// requireManageChannels responds with 403 unless the user may manage channels in the server
+func (h *ServerHandler) requireManageChannels(c *gin.Context, serverId, userId string) bool {
+	allowed, err := h.serverService.HasServerPermission(serverId, userId, models.PermissionManageChannels)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "権限の確認に失敗しました"})
+		return false
+	}
+	if !allowed {
+		c.JSON(http.StatusForbidden, gin.H{"error": "チャンネルを管理する権限がありません"})
+		return false
+	}
+	return true
+}
+
+// broadcastServerEvent はサーバー内のすべてのチャンネルにイベントを送信する
+func (h *ServerHandler) broadcastServerEvent(serverId, eventType string, payload interface{}) {
+	if h.wsService == nil {
+		return
+	}
+	channelIds, err := h.serverService.GetServerChannelIds(serverId)
+	if err != nil {
+		log.Printf("チャンネル一覧の取得に失敗しました: %v", err)
+		return
+	}
+	if err := h.wsService.BroadcastServerEvent(channelIds, eventType, payload); err != nil {
+		log.Printf("%sのブロードキャストに失敗しました: %v", eventType, err)
+	}
+}
+
+// broadcastChannelEvent はチャンネルの変更を送信する。変更内容はそのチャンネル内だけに送る。
+// 上書き設定で一部のメンバーから隠されている場合があるため、サーバーの他のチャンネルには
+// layout_updateと同じくIDだけを再取得の合図として送る。非公開チャンネルの変更は合図も送らない。
+func (h *ServerHandler) broadcastChannelEvent(channel models.Channel, eventType string, payload interface{}) {
+	if h.wsService == nil {
+		return
+	}
+	if !channel.IsPrivate {
+		channelIds, err := h.serverService.GetServerChannelIds(channel.ServerId)
+		if err != nil {
+			log.Printf("チャンネル一覧の取得に失敗しました: %v", err)
+		}
+		others := make([]string, 0, len(channelIds))
+		for _, id := range channelIds {
+			if id != channel.ID {
+				others = append(others, id)
+			}
+		}
+		hint := gin.H{"id": channel.ID, "serverId": channel.ServerId}
+		if err := h.wsService.BroadcastServerEvent(others, eventType, hint); err != nil {
+			log.Printf("%sのブロードキャストに失敗しました: %v", eventType, err)
+		}
+	}
+	if err := h.wsService.BroadcastServerEvent([]string{channel.ID}, eventType, payload); err != nil {
+		log.Printf("%sのブロードキャストに失敗しました: %v", eventType, err)
+	}
+}
+
+// respondLayoutError maps channel and category errors to responses
+func respondLayoutError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrChannelNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+	case errors.Is(err, services.ErrCategoryNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "カテゴリーが見つかりません"})
+	case errors.Is(err, services.ErrInvalidLayout):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "すべてのカテゴリーとチャンネルを一度ずつ、重複しない位置で指定してください"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
+
+// broadcastChannelState tells each user connected to the channel whether they may still post in it
+func (h *ServerHandler) broadcastChannelState(channelId string) {
+	refreshChannelState(h.wsService, h.serverService, channelId)
+}
+
+// refreshChannelState sends each user connected to the channel its current channel_state,
+// and disconnects those who can no longer view the channel
+func refreshChannelState(wsService *services.WebSocketService, serverService *services.ServerService, channelId string) {
+	if wsService == nil {
+		return
+	}
+	states := make(map[string]models.ChannelState)
+	for _, userId := range wsService.ChannelUsers(channelId) {
+		canView, err := serverService.HasChannelAccess(channelId, userId)
+		if err != nil {
+			log.Printf("チャンネルアクセスの確認に失敗: %v", err)
+			continue
+		}
+		if !canView {
+			wsService.DisconnectUserFromChannels(userId, []string{channelId}, "channel access revoked")
+			continue
+		}
+		state, err := serverService.GetChannelState(channelId, userId)
+		if err != nil {
+			log.Printf("チャンネル状態の取得に失敗: %v", err)
+			continue
+		}
+		states[userId] = state
+	}
+	if err := wsService.BroadcastChannelState(channelId, states); err != nil {
+		log.Printf("WebSocketブロードキャストエラー: %v", err)
+	}
+}
+
+// ListChannelMembers returns the members of a private channel
+func (h *ServerHandler) ListChannelMembers(c *gin.Context) {
+	channelId := c.Param("id")
+
+	hasAccess, err := h.serverService.HasChannelAccess(channelId, c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルアクセスの確認に失敗しました"})
+		return
+	}
+	if !hasAccess {
+		c.JSON(http.StatusForbidden, gin.H{"error": "このチャンネルにアクセスする権限がありません"})
+		return
+	}
+
+	members, err := h.serverService.ListChannelMembers(channelId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルメンバーの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"members": members})
+}
+
+// RemoveChannelMember removes a user from a private channel. Members may remove themselves.
+func (h *ServerHandler) RemoveChannelMember(c *gin.Context) {
+	channelId := c.Param("id")
+	targetId := c.Param("userId")
+	userId := c.GetString("userID")
+
+	if _, err := uuid.Parse(targetId); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーはこのチャンネルのメンバーではありません"})
+		return
+	}
+
+	serverId, err := h.serverService.GetServerIdByChannelId(channelId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "チャンネルが見つかりません"})
+		return
+	}
+	if targetId != userId && !h.requireManageChannels(c, serverId, userId) {
+		return
+	}
+
+	if err := h.serverService.RemoveChannelMember(channelId, targetId); err != nil {
+		if errors.Is(err, services.ErrNotChannelMember) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "ユーザーはこのチャンネルのメンバーではありません"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "チャンネルメンバーの削除に失敗しました"})
+		return
+	}
+
+	// 自分で抜けた場合は管理操作ではないので記録しない
+	if targetId != userId {
+		recordAudit(h.auditService, c, models.AuditLogEntry{
+			ServerId:   serverId,
+			Action:     models.AuditChannelMemberRemove,
+			TargetType: models.AuditTargetUser,
+			TargetId:   targetId,
+			Changes:    []models.AuditChange{{Key: "channelId", Before: channelId}},
+		})
+	}
+
+	// 管理者や上書き設定でまだ閲覧できる場合を除き、WebSocket接続を切断する
+	if h.wsService != nil {
+		hasAccess, err := h.serverService.HasChannelAccess(channelId, targetId)
+		if err != nil {
+			log.Printf("チャンネルアクセスの確認に失敗しました: %v", err)
+		} else if !hasAccess {
+			h.wsService.DisconnectUserFromChannels(targetId, []string{channelId}, "removed from channel")
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "メンバーがチャンネルから削除されました"})
+}
+
+// SaveTemplate saves the server's categories, channels and roles as a template
+func (h *ServerHandler) SaveTemplate(c *gin.Context) {
+	var req models.SaveTemplateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	serverId := c.Param("id")
+	userId := c.GetString("userID")
+	if !h.requireManageServer(c, serverId, userId) {
+		return
+	}
+
+	template, err := h.serverService.SaveTemplate(serverId, userId, req)
+	if err != nil {
+		respondServerError(c, err, "テンプレートの保存に失敗しました")
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"template": template})
+}
+
+// ListTemplates returns the built-in presets and the user's saved templates
+func (h *ServerHandler) ListTemplates(c *gin.Context) {
+	templates, err := h.serverService.ListTemplates(c.GetString("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "テンプレートの取得に失敗しました"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"templates": templates})
+}
+
+// DeleteTemplate deletes one of the user's saved templates
+func (h *ServerHandler) DeleteTemplate(c *gin.Context) {
+	if err := h.serverService.DeleteTemplate(c.Param("id"), c.GetString("userID")); err != nil {
+		respondServerError(c, err, "テンプレートの削除に失敗しました")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "テンプレートが削除されました"})
+}
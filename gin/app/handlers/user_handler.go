@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"app/models"
+	"app/services"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UserHandler handles profile management requests for the signed-in user
+type UserHandler struct {
+	userService *services.UserService
+	wsService   *services.WebSocketService
+}
+
+// NewUserHandler creates a new user handler
+func NewUserHandler(userService *services.UserService) *UserHandler {
+	return &UserHandler{
+		userService: userService,
+	}
+}
+
+// SetWebSocketService はWebSocketServiceを設定する
+func (h *UserHandler) SetWebSocketService(wsService *services.WebSocketService) {
+	h.wsService = wsService
+}
+
+// UpdateProfile changes the current user's display name, username and bio
+func (h *UserHandler) UpdateProfile(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req models.UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.userService.UpdateProfile(userID.(string), req)
+	if err != nil {
+		if errors.Is(err, services.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrInvalidUsername) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.broadcastProfile(user)
+	c.JSON(http.StatusOK, user)
+}
+
+// UploadAvatar replaces the current user's avatar image
+func (h *UserHandler) UploadAvatar(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Get file
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		return
+	}
+
+	user, err := h.userService.UpdateAvatar(userID.(string), file)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAvatar) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.broadcastProfile(user)
+	c.JSON(http.StatusOK, user)
+}
+
+// ChangePassword changes the current user's password and signs out their other sessions
+func (h *UserHandler) ChangePassword(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	sessionID, _ := c.Get("sessionID")
+
+	var req models.ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	revoked, err := h.userService.ChangePassword(userID.(string), sessionID.(string), req.CurrentPassword, req.ReauthToken, req.NewPassword)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidPassword) || errors.Is(err, services.ErrInvalidReauthToken) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.disconnectSessions(revoked, "password changed")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password has been changed"})
+}
+
+// ListSessions lists the devices the current user is signed in on
+func (h *UserHandler) ListSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := h.userService.ListSessions(userID.(string), c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"sessions": sessions})
+}
+
+// RevokeSession signs out one of the current user's sessions
+func (h *UserHandler) RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessionID := c.Param("sessionId")
+	if err := h.userService.RevokeSession(sessionID, userID.(string)); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.disconnectSessions([]string{sessionID}, "session revoked")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked"})
+}
+
+// RevokeOtherSessions signs out every session except the current one
+func (h *UserHandler) RevokeOtherSessions(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	revoked, err := h.userService.RevokeOtherSessions(userID.(string), c.GetString("sessionID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	h.disconnectSessions(revoked, "session revoked")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked", "revoked": len(revoked)})
+}
+
+// SearchUsers finds users by username or display name for invitations
+func (h *UserHandler) SearchUsers(c *gin.Context) {
+	userID, exists := c.Get("userID")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	query := c.Query("q")
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Query parameter q is required"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	offset, _ := strconv.Atoi(c.Query("offset"))
+
+	results, err := h.userService.SearchUsers(userID.(string), query, limit, offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// broadcastProfile は他のメンバーにプロフィールの変更を通知する
+func (h *UserHandler) broadcastProfile(user *models.User) {
+	if h.wsService == nil {
+		return
+	}
+
+	channelIDs, err := h.userService.GetSharedChannelIDs(user.ID)
+	if err != nil {
+		log.Printf("プロフィール通知先のチャンネル取得エラー: %v", err)
+		return
+	}
+	if err := h.wsService.BroadcastUserUpdate(channelIDs, user.Profile()); err != nil {
+		log.Printf("WebSocketブロードキャストエラー: %v", err)
+	}
+}
+
+// disconnectSessions は失効したセッションのWebSocket接続を切断する
+func (h *UserHandler) disconnectSessions(sessionIDs []string, reason string) {
+	if h.wsService == nil {
+		return
+	}
+	for _, sessionID := range sessionIDs {
+		h.wsService.DisconnectSession(sessionID, reason)
+	}
+}
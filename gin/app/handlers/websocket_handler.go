@@ -66,12 +66,17 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
-	// トークンを検証
-	userID, err := h.userService.ValidateToken(token)
+	// トークンを検証（セッションまたはパーソナルアクセストークン）
+	claims, err := h.userService.Authenticate(token)
 	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "無効な認証トークンです"})
 		return
 	}
+	if !claims.HasScope(models.ScopeMessagesRead) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "トークンにmessages:readスコープがありません"})
+		return
+	}
+	userID := claims.UserID
 
 	// チャンネルの存在確認とアクセス権限の確認
 	hasAccess, err := h.serverService.UserHasChannelAccess(userID, channelID)
@@ -96,10 +101,18 @@ func (h *WebSocketHandler) HandleWebSocket(c *gin.Context) {
 		ID:        h.wsService.GenerateClientID(),
 		Conn:      conn,
 		UserID:    userID,
+		SessionID: claims.SessionID,
 		ChannelID: channelID,
 		Send:      make(chan []byte, 256),
 	}
 
+	// チャンネルのモードと投稿可否を最初に伝える
+	if state, err := h.serverService.GetChannelState(channelID, userID); err != nil {
+		log.Printf("チャンネル状態の取得に失敗: %v", err)
+	} else if err := h.wsService.SendChannelState(client, state); err != nil {
+		log.Printf("チャンネル状態の送信に失敗: %v", err)
+	}
+
 	// クライアントを登録
 	h.wsService.Hub.Register <- client
 
@@ -9,6 +9,7 @@ import (
 
 	"app/db"
 	"app/handlers"
+	"app/models"
 	"app/services"
 
 	"github.com/gin-contrib/cors"
@@ -33,16 +34,20 @@ func authMiddleware(userService *services.UserService) gin.HandlerFunc {
 			tokenString = authHeader[7:]
 		}
 
-		// Validate the token
-		userID, err := userService.ValidateToken(tokenString)
+		// Validate the session token or personal access token
+		claims, err := userService.Authenticate(tokenString)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			c.Abort()
 			return
 		}
 
-		// Set the user ID in the context
-		c.Set("userID", userID)
+		// Set the user and session IDs in the context
+		c.Set("userID", claims.UserID)
+		c.Set("sessionID", claims.SessionID)
+		if claims.Scopes != nil {
+			c.Set("scopes", claims.Scopes)
+		}
 		c.Next()
 	}
 }
@@ -61,10 +66,10 @@ func main() {
 	}
 	openaiClient := openai.NewClient(apiKey)
 
-	// JWT secret の確認
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		panic("JWT_SECRET is not set")
+	// JWT署名鍵の読み込み
+	keySet, err := services.LoadKeySetFromEnv()
+	if err != nil {
+		panic(fmt.Sprintf("JWT署名鍵の読み込みに失敗しました: %s", err))
 	}
 
 	// データベース接続の初期化
@@ -79,12 +84,28 @@ func main() {
 	chatHandler := handlers.NewChatHandler(chatService)
 
 	// ユーザー認証サービスとハンドラーの初期化
-	userService := services.NewUserService(db)
+	userService := services.NewUserService(db, keySet)
+	userService.SetMailer(services.NewMailerFromEnv())
 	authHandler := handlers.NewAuthHandler(userService)
+	userHandler := handlers.NewUserHandler(userService)
+	accountHandler := handlers.NewAccountHandler(services.NewAccountService(db, userService))
+	adminHandler := handlers.NewAdminHandler(userService)
+
+	// OpenID Connectによるシングルサインオン（OIDC_ISSUERが設定されている場合のみ）
+	var oidcHandler *handlers.OIDCHandler
+	if oidcConfig, ok := services.OIDCConfigFromEnv(); ok {
+		oidcHandler = handlers.NewOIDCHandler(services.NewOIDCService(db, userService, oidcConfig))
+	}
 
 	// サーバーとメッセージのサービスとハンドラーの初期化
 	serverService := services.NewServerService(db)
 	serverHandler := handlers.NewServerHandler(serverService)
+	roleHandler := handlers.NewRoleHandler(services.NewRoleService(db), serverService)
+	inviteHandler := handlers.NewInviteHandler(services.NewInviteService(db), serverService)
+	moderationHandler := handlers.NewModerationHandler(services.NewModerationService(db), serverService)
+	discoveryHandler := handlers.NewDiscoveryHandler(services.NewDiscoveryService(db))
+	auditService := services.NewAuditLogService(db)
+	auditHandler := handlers.NewAuditLogHandler(auditService, serverService)
 
 	// チャンネルメッセージサービスとハンドラーの初期化
 	channelMessageService := services.NewChannelMessageService(db)
@@ -98,6 +119,17 @@ func main() {
 	wsService := services.NewWebSocketService()
 	wsHandler := handlers.NewWebSocketHandler(wsService, userService, serverService)
 
+	// 未確認ユーザーのサーバー参加・投稿を制限するかどうか
+	requireVerifiedEmail := handlers.RequireVerifiedEmail(userService, os.Getenv("REQUIRE_EMAIL_VERIFICATION") == "true")
+
+	// パーソナルアクセストークンのスコープ制御
+	sessionOnly := handlers.RequireSession()
+	serverScopes := handlers.RequireScopes(models.ScopeServersRead, models.ScopeServersWrite)
+
+	// ログイン・登録へのIPアドレス単位の試行回数制限
+	loginThrottle := handlers.ThrottleByIP(services.NewRateLimiter(20, 5*time.Minute))
+	registerThrottle := handlers.ThrottleByIP(services.NewRateLimiter(5, time.Hour))
+
 	engine := gin.Default()
 
 	// 信頼するプロキシを設定
@@ -128,20 +160,58 @@ func main() {
 		// 認証関連のエンドポイント
 		auth := api.Group("/auth")
 		{
-			auth.POST("/register", authHandler.Register)
-			auth.POST("/login", authHandler.Login)
-			auth.GET("/me", authMiddleware(userService), authHandler.GetCurrentUser)
+			auth.POST("/register", registerThrottle, authHandler.Register)
+			auth.POST("/login", loginThrottle, authHandler.Login)
+			auth.POST("/login/totp", loginThrottle, authHandler.LoginTOTP)
+			auth.POST("/refresh", authHandler.Refresh)
+			auth.POST("/logout", authMiddleware(userService), sessionOnly, authHandler.Logout)
+			auth.POST("/forgot-password", loginThrottle, authHandler.ForgotPassword)
+			auth.POST("/reset-password", authHandler.ResetPassword)
+			auth.POST("/verify-email", authHandler.VerifyEmail)
+			auth.POST("/verify-email/resend", authMiddleware(userService), sessionOnly, authHandler.ResendVerification)
+			auth.GET("/me", authMiddleware(userService), handlers.RequireScopes(models.ScopeProfileRead, models.ScopeProfileWrite), authHandler.GetCurrentUser)
+
+			if oidcHandler != nil {
+				auth.GET("/oidc/login", oidcHandler.Login)
+				auth.GET("/oidc/callback", oidcHandler.Callback)
+				auth.POST("/oidc/callback", oidcHandler.Callback)
+				auth.GET("/oidc/reauth", authMiddleware(userService), sessionOnly, oidcHandler.Reauthenticate)
+			}
+		}
+
+		// サイト管理者向けのエンドポイント（ロックされたアカウントの確認）
+		admin := api.Group("/admin", authMiddleware(userService), sessionOnly, handlers.RequireAdmin(userService))
+		{
+			admin.GET("/locked-accounts", adminHandler.ListLockedAccounts)
+			admin.POST("/locked-accounts/:id/unlock", adminHandler.UnlockAccount)
+			admin.GET("/auth-events", adminHandler.ListAuthEvents)
 		}
 
 		// ユーザー関連のエンドポイント
-		users := api.Group("/users", authMiddleware(userService))
+		users := api.Group("/users", authMiddleware(userService), handlers.RequireScopes(models.ScopeProfileRead, models.ScopeProfileWrite))
 		{
 			users.GET("/me", authHandler.GetCurrentUser) // /api/auth/meと同じ機能
-			users.GET("/:id", authHandler.GetUserById)   // 特定のユーザー情報を取得
+			users.PUT("/me", userHandler.UpdateProfile)
+			users.POST("/me/avatar", userHandler.UploadAvatar)
+			users.POST("/me/password", sessionOnly, userHandler.ChangePassword)
+			users.GET("/me/export", sessionOnly, accountHandler.ExportData)
+			users.DELETE("/me", sessionOnly, accountHandler.DeleteAccount)
+			users.POST("/me/2fa/setup", sessionOnly, authHandler.SetupTOTP)
+			users.POST("/me/2fa/enable", sessionOnly, authHandler.EnableTOTP)
+			users.POST("/me/2fa/disable", sessionOnly, authHandler.DisableTOTP)
+			users.POST("/me/2fa/recovery-codes", sessionOnly, authHandler.RegenerateRecoveryCodes)
+			users.GET("/me/sessions", sessionOnly, userHandler.ListSessions)
+			users.DELETE("/me/sessions", sessionOnly, userHandler.RevokeOtherSessions)
+			users.DELETE("/me/sessions/:sessionId", sessionOnly, userHandler.RevokeSession)
+			users.GET("/me/tokens", sessionOnly, authHandler.ListAccessTokens)
+			users.POST("/me/tokens", sessionOnly, authHandler.CreateAccessToken)
+			users.DELETE("/me/tokens/:tokenId", sessionOnly, authHandler.RevokeAccessToken)
+			users.GET("/search", userHandler.SearchUsers) // ユーザー名・表示名で検索
+			users.GET("/:id", authHandler.GetUserById)    // 特定のユーザー情報を取得
 		}
 
 		// チャット関連のエンドポイント
-		chats := api.Group("/chats", authMiddleware(userService))
+		chats := api.Group("/chats", authMiddleware(userService), handlers.RequireScopes(models.ScopeChatsRead, models.ScopeChatsWrite))
 		{
 			chats.GET("", chatHandler.GetChatHistory)
 			chats.POST("", chatHandler.CreateChat)
@@ -150,57 +220,138 @@ func main() {
 		}
 
 		// メッセージ編集・削除用のエンドポイント
-		messages := api.Group("/messages", authMiddleware(userService))
+		messages := api.Group("/messages", authMiddleware(userService), handlers.RequireScopes(models.ScopeChatsRead, models.ScopeChatsWrite))
 		{
 			messages.PUT("/:messageId", chatHandler.EditChatMessage)
 			messages.DELETE("/:messageId", chatHandler.DeleteChatMessage)
 		}
 
 		// サーバー関連のエンドポイント
-		servers := api.Group("/servers", authMiddleware(userService))
+		servers := api.Group("/servers", authMiddleware(userService), serverScopes)
 		{
 			servers.POST("", serverHandler.CreateServer)
 			servers.GET("", serverHandler.GetUserServers)
 			servers.GET("/:id/channels", serverHandler.GetServerChannels)
 			servers.POST("/:id/channels", serverHandler.CreateChannel)
 			servers.POST("/:id/categories", serverHandler.CreateCategory)
-			servers.POST("/:id/join", serverHandler.JoinServer)
+			servers.POST("/:id/join", requireVerifiedEmail, serverHandler.JoinServer)
 			servers.GET("/:id/categories", serverHandler.GetServerCategories)
+			servers.PUT("/:id/categories/:categoryId", serverHandler.UpdateCategory)
+			servers.DELETE("/:id/categories/:categoryId", serverHandler.DeleteCategory)
+			servers.PUT("/:id/layout", serverHandler.ReorderLayout)
+			servers.GET("/:id", serverHandler.GetServer)
+			servers.GET("/:id/members", serverHandler.ListMembers)
+			servers.PUT("/:id", serverHandler.UpdateServer)
+			servers.PUT("/:id/direct-join", serverHandler.SetDirectJoin) // 旧クライアント向け（PUT /:id でも変更可能）
+			servers.POST("/:id/icon", serverHandler.UploadServerIcon)
+			servers.POST("/:id/transfer", sessionOnly, serverHandler.TransferOwnership)
+			servers.DELETE("/:id", sessionOnly, serverHandler.DeleteServer)
+			servers.POST("/:id/leave", moderationHandler.LeaveServer)
+
+			// キックとBAN
+			servers.POST("/:id/members/:userId/kick", moderationHandler.KickMember)
+			servers.GET("/:id/bans", moderationHandler.ListBans)
+			servers.PUT("/:id/bans/:userId", moderationHandler.BanMember)
+			servers.DELETE("/:id/bans/:userId", moderationHandler.UnbanMember)
+
+			// 招待リンク
+			servers.GET("/:id/invites", inviteHandler.ListInvites)
+			servers.POST("/:id/invites", inviteHandler.CreateInvite)
+
+			// ロールと権限
+			servers.GET("/:id/permissions", roleHandler.GetMyPermissions)
+			servers.GET("/:id/roles", roleHandler.ListRoles)
+			servers.POST("/:id/roles", roleHandler.CreateRole)
+			servers.PUT("/:id/roles/positions", roleHandler.ReorderRoles)
+			servers.PUT("/:id/roles/:roleId", roleHandler.UpdateRole)
+			servers.DELETE("/:id/roles/:roleId", roleHandler.DeleteRole)
+			servers.PUT("/:id/members/:userId/roles", roleHandler.SetMemberRoles)
+
+			// 監査ログ
+			servers.GET("/:id/audit-log", auditHandler.ListEntries)
+
+			// テンプレートとして保存
+			servers.POST("/:id/templates", serverHandler.SaveTemplate)
+		}
+
+		// サーバーテンプレート
+		templates := api.Group("/templates", authMiddleware(userService), serverScopes)
+		{
+			templates.GET("", serverHandler.ListTemplates)
+			templates.DELETE("/:id", serverHandler.DeleteTemplate)
+		}
+
+		// 公開サーバーディレクトリ
+		discovery := api.Group("/discovery", authMiddleware(userService), serverScopes)
+		{
+			discovery.GET("/servers", discoveryHandler.ListServers)
+			discovery.POST("/servers/:id/join", requireVerifiedEmail, discoveryHandler.JoinServer)
+		}
+
+		// 招待リンクのプレビューと参加
+		invites := api.Group("/invites", authMiddleware(userService), serverScopes)
+		{
+			invites.GET("/:code", inviteHandler.PreviewInvite)
+			invites.POST("/:code/accept", requireVerifiedEmail, inviteHandler.AcceptInvite)
+			invites.DELETE("/:code", inviteHandler.DeleteInvite)
 		}
 
 		// チャンネル関連のエンドポイント（従来のハンドラー - 後方互換性のため）
-		channels := api.Group("/channels", authMiddleware(userService))
+		channels := api.Group("/channels", authMiddleware(userService), handlers.RequireScopes(models.ScopeMessagesRead, models.ScopeMessagesWrite))
 		{
 			channels.GET("/:id", serverHandler.GetChannel)
 			channels.GET("/:id/messages", messageHandler.GetChannelMessages)
-			channels.POST("/:id/messages", messageHandler.SendChannelMessage)
+			channels.POST("/:id/messages", requireVerifiedEmail, messageHandler.SendChannelMessage)
 			channels.PUT("/messages/:id", messageHandler.EditMessage)
 			channels.DELETE("/messages/:id", messageHandler.DeleteMessage)
-			channels.POST("/:id/upload", messageHandler.UploadFile)
+			channels.POST("/:id/upload", requireVerifiedEmail, messageHandler.UploadFile)
 			channels.GET("/attachments/:id", messageHandler.GetAttachment)
-			channels.POST("/:id/members", serverHandler.AddChannelMember)
-			channels.POST("/:id/category", serverHandler.UpdateChannelCategory)
-			channels.DELETE("/:id", serverHandler.DeleteChannel)
+			channels.GET("/:id/members", serverScopes, serverHandler.ListChannelMembers)
+			channels.POST("/:id/members", serverScopes, serverHandler.AddChannelMember)
+			channels.DELETE("/:id/members/:userId", serverScopes, serverHandler.RemoveChannelMember)
+			channels.POST("/:id/category", serverScopes, serverHandler.UpdateChannelCategory)
+			channels.PUT("/:id", serverScopes, serverHandler.UpdateChannel)
+			channels.GET("/:id/overwrites", serverScopes, roleHandler.ListOverwrites)
+			channels.PUT("/:id/overwrites/:targetType/:targetId", serverScopes, roleHandler.SetOverwrite)
+			channels.DELETE("/:id/overwrites/:targetType/:targetId", serverScopes, roleHandler.DeleteOverwrite)
+			channels.DELETE("/:id", serverScopes, serverHandler.DeleteChannel)
 		}
 
 		// 新しいチャンネルメッセージエンドポイント
-		channelMessages := api.Group("/channel-messages", authMiddleware(userService))
+		channelMessages := api.Group("/channel-messages", authMiddleware(userService), handlers.RequireScopes(models.ScopeMessagesRead, models.ScopeMessagesWrite))
 		{
 			channelMessages.GET("/:id", channelMessageHandler.GetChannelMessages)
-			channelMessages.POST("/:id", channelMessageHandler.CreateChannelMessage)
+			channelMessages.POST("/:id", requireVerifiedEmail, channelMessageHandler.CreateChannelMessage)
 			channelMessages.PUT("/:id", channelMessageHandler.EditChannelMessage)
 			channelMessages.DELETE("/:id", channelMessageHandler.DeleteChannelMessage)
-			channelMessages.POST("/attachments", channelMessageHandler.UploadChannelAttachment)
+			channelMessages.POST("/attachments", requireVerifiedEmail, channelMessageHandler.UploadChannelAttachment)
 			channelMessages.GET("/attachments/:id", channelMessageHandler.GetChannelAttachment)
 		}
 	}
 
+	// トークン検証用の公開鍵
+	engine.GET("/.well-known/jwks.json", authHandler.GetJWKS)
+
 	// WebSocketエンドポイント
 	engine.GET("/ws/channels/:channelId", wsHandler.HandleWebSocket)
 
-	// メッセージの更新・削除時にWebSocketでブロードキャストするためのフックを設定
+	// メッセージやプロフィールの更新時にWebSocketでブロードキャストするためのフックを設定
 	messageHandler.SetWebSocketService(wsService)
 	channelMessageHandler.SetWebSocketService(wsService)
+	authHandler.SetWebSocketService(wsService)
+	userHandler.SetWebSocketService(wsService)
+	accountHandler.SetWebSocketService(wsService)
+	moderationHandler.SetWebSocketService(wsService)
+	serverHandler.SetWebSocketService(wsService)
+	roleHandler.SetWebSocketService(wsService)
+
+	// 管理操作を監査ログに記録する
+	serverHandler.SetAuditLogService(auditService)
+	roleHandler.SetAuditLogService(auditService)
+	inviteHandler.SetAuditLogService(auditService)
+	moderationHandler.SetAuditLogService(auditService)
+	messageHandler.SetAuditLogService(auditService)
+	channelMessageHandler.SetAuditLogService(auditService)
 
 	// サーバーの設定と起動
 	server := &http.Server{
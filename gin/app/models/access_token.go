@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+)
+
+// Scopes that can be granted to a personal access token
+const (
+	ScopeProfileRead   = "profile:read"
+	ScopeProfileWrite  = "profile:write"
+	ScopeChatsRead     = "chats:read"
+	ScopeChatsWrite    = "chats:write"
+	ScopeServersRead   = "servers:read"
+	ScopeServersWrite  = "servers:write"
+	ScopeMessagesRead  = "messages:read"
+	ScopeMessagesWrite = "messages:write"
+)
+
+// ValidScopes lists every scope a token may be granted
+var ValidScopes = map[string]bool{
+	ScopeProfileRead:   true,
+	ScopeProfileWrite:  true,
+	ScopeChatsRead:     true,
+	ScopeChatsWrite:    true,
+	ScopeServersRead:   true,
+	ScopeServersWrite:  true,
+	ScopeMessagesRead:  true,
+	ScopeMessagesWrite: true,
+}
+
+// PersonalAccessToken is a long-lived token for scripts and bots. The secret itself is never stored.
+type PersonalAccessToken struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"` // First characters of the token, for recognising it in lists
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+}
+
+// CreateAccessTokenRequest represents the request to create a personal access token
+type CreateAccessTokenRequest struct {
+	Name          string   `json:"name" binding:"required,max=100"`
+	Scopes        []string `json:"scopes" binding:"required,min=1"`
+	ExpiresInDays int      `json:"expiresInDays" binding:"min=0,max=365"` // 0 means the token never expires
+}
+
+// CreateAccessTokenResponse includes the token secret, which is only shown once
+type CreateAccessTokenResponse struct {
+	PersonalAccessToken
+	Token string `json:"token"`
+}
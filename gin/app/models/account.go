@@ -0,0 +1,36 @@
+package models
+
+import (
+	"time"
+)
+
+// DeletedUserID is the placeholder user that takes over the channel messages of deleted accounts
+const DeletedUserID = "00000000-0000-0000-0000-000000000000"
+
+// DeleteAccountRequest represents the request to permanently delete the current account
+type DeleteAccountRequest struct {
+	Password    string `json:"password" binding:"required_without=ReauthToken"`
+	ReauthToken string `json:"reauthToken"`
+}
+
+// ExportedChat is a chatbot conversation in a personal data export
+type ExportedChat struct {
+	ID        string           `json:"id"`
+	Title     string           `json:"title"`
+	CreatedAt time.Time        `json:"createdAt"`
+	Messages  []ChatbotMessage `json:"messages"`
+}
+
+// ExportedChannelMessage is a channel message in a personal data export
+type ExportedChannelMessage struct {
+	ID          string              `json:"id"`
+	ServerID    string              `json:"serverId"`
+	ServerName  string              `json:"serverName"`
+	ChannelID   string              `json:"channelId"`
+	ChannelName string              `json:"channelName"`
+	Content     string              `json:"content"`
+	Timestamp   time.Time           `json:"timestamp"`
+	IsEdited    bool                `json:"isEdited"`
+	IsDeleted   bool                `json:"isDeleted"`
+	Attachments []ChannelAttachment `json:"attachments"`
+}
@@ -0,0 +1,84 @@
+package models
+
+import (
+	"time"
+)
+
+// Audit log actions
+const (
+	AuditServerUpdate        = "server_update"
+	AuditServerTransfer      = "server_transfer"
+	AuditChannelCreate       = "channel_create"
+	AuditChannelUpdate       = "channel_update"
+	AuditChannelDelete       = "channel_delete"
+	AuditChannelMemberAdd    = "channel_member_add"
+	AuditChannelMemberRemove = "channel_member_remove"
+	AuditCategoryCreate      = "category_create"
+	AuditCategoryUpdate      = "category_update"
+	AuditCategoryDelete      = "category_delete"
+	AuditLayoutUpdate        = "layout_update"
+	AuditRoleCreate          = "role_create"
+	AuditRoleUpdate          = "role_update"
+	AuditRoleDelete          = "role_delete"
+	AuditRoleReorder         = "role_reorder"
+	AuditMemberRolesUpdate   = "member_roles_update"
+	AuditOverwriteUpdate     = "overwrite_update"
+	AuditOverwriteDelete     = "overwrite_delete"
+	AuditMemberKick          = "member_kick"
+	AuditMemberBan           = "member_ban"
+	AuditMemberUnban         = "member_unban"
+	AuditInviteCreate        = "invite_create"
+	AuditInviteDelete        = "invite_delete"
+	AuditMessageDelete       = "message_delete"
+)
+
+// Audit log target types
+const (
+	AuditTargetServer   = "server"
+	AuditTargetChannel  = "channel"
+	AuditTargetCategory = "category"
+	AuditTargetRole     = "role"
+	AuditTargetUser     = "user"
+	AuditTargetInvite   = "invite"
+	AuditTargetMessage  = "message"
+)
+
+// AuditLogEntry records one administrative action in a server
+type AuditLogEntry struct {
+	ID            string        `json:"id"`
+	ServerId      string        `json:"serverId"`
+	ActorId       string        `json:"actorId"`
+	ActorUsername string        `json:"actorUsername"`
+	Action        string        `json:"action"`
+	TargetType    string        `json:"targetType"`
+	TargetId      string        `json:"targetId"`
+	Changes       []AuditChange `json:"changes"`
+	Reason        string        `json:"reason"`
+	CreatedAt     time.Time     `json:"createdAt"`
+}
+
+// AuditChange is one field changed by an action. Before is omitted for
+// created values and After for removed ones.
+type AuditChange struct {
+	Key    string      `json:"key"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// AuditLogFilter narrows an audit log query. Empty fields match everything.
+type AuditLogFilter struct {
+	ActorId    string
+	Action     string
+	TargetType string
+	TargetId   string
+	Limit      int
+	Offset     int
+}
+
+// AuditLogResponse is a page of audit log entries, newest first
+type AuditLogResponse struct {
+	Entries []AuditLogEntry `json:"entries"`
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+	HasMore bool            `json:"hasMore"`
+}
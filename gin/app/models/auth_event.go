@@ -0,0 +1,41 @@
+package models
+
+import "time"
+
+// LockedAccount is an account currently locked after repeated failed sign-ins
+type LockedAccount struct {
+	UserId           string    `json:"userId"`
+	Username         string    `json:"username"`
+	Email            string    `json:"email"`
+	FailedLoginCount int       `json:"failedLoginCount"`
+	LockedUntil      time.Time `json:"lockedUntil"`
+}
+
+// AuthEvent is a recorded sign-in failure, lockout or unlock
+type AuthEvent struct {
+	ID        string    `json:"id"`
+	UserId    string    `json:"userId"`
+	Email     string    `json:"email"`
+	EventType string    `json:"eventType"`
+	IPAddress string    `json:"ipAddress"`
+	UserAgent string    `json:"userAgent"`
+	Details   string    `json:"details"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// AuthEventFilter narrows an authentication event query. Empty fields match everything.
+type AuthEventFilter struct {
+	UserId    string
+	Email     string
+	EventType string
+	Limit     int
+	Offset    int
+}
+
+// AuthEventListResponse is a page of authentication events, newest first
+type AuthEventListResponse struct {
+	Events  []AuthEvent `json:"events"`
+	Limit   int         `json:"limit"`
+	Offset  int         `json:"offset"`
+	HasMore bool        `json:"hasMore"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+)
+
+// Invite is a code that lets users join a server
+type Invite struct {
+	Code      string     `json:"code"`
+	ServerId  string     `json:"serverId"`
+	ChannelId string     `json:"channelId,omitempty"`
+	CreatedBy string     `json:"createdBy"`
+	MaxUses   int        `json:"maxUses"` // 0 means unlimited
+	Uses      int        `json:"uses"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// CreateInviteRequest represents the request to create an invite
+type CreateInviteRequest struct {
+	MaxUses   int    `json:"maxUses" binding:"min=0,max=1000"`
+	ExpiresIn int    `json:"expiresIn" binding:"min=0,max=2592000"` // seconds, 0 means never
+	ChannelId string `json:"channelId" binding:"omitempty,uuid"`
+}
+
+// InvitePreview is what a user sees about a server before accepting an invite
+type InvitePreview struct {
+	Code              string     `json:"code"`
+	ServerId          string     `json:"serverId"`
+	ServerName        string     `json:"serverName"`
+	ServerDescription string     `json:"serverDescription"`
+	MemberCount       int        `json:"memberCount"`
+	ChannelId         string     `json:"channelId,omitempty"`
+	ChannelName       string     `json:"channelName,omitempty"`
+	ExpiresAt         *time.Time `json:"expiresAt,omitempty"`
+}
+
+// DirectJoinRequest toggles whether a server can be joined by ID without an invite.
+// Kept for existing clients; PUT /servers/:id accepts allowDirectJoin as well.
+type DirectJoinRequest struct {
+	AllowDirectJoin bool `json:"allowDirectJoin"`
+}
@@ -0,0 +1,101 @@
+package models
+
+import (
+	"time"
+)
+
+// Permission is a bitfield of actions a member may take in a server
+type Permission int64
+
+// Server permissions. New permissions must be appended so stored values keep their meaning.
+const (
+	PermissionViewChannels Permission = 1 << iota
+	PermissionSendMessages
+	PermissionAttachFiles
+	PermissionMentionEveryone
+	PermissionCreateInvites
+	PermissionManageMessages
+	PermissionManageChannels
+	PermissionManageRoles
+	PermissionManageServer
+	PermissionKickMembers
+	PermissionBanMembers
+	PermissionAdministrator // Grants every permission and bypasses channel restrictions
+	PermissionViewAuditLog
+)
+
+// AllPermissions is every permission bit, as held by the server owner
+const AllPermissions = PermissionViewChannels | PermissionSendMessages | PermissionAttachFiles |
+	PermissionMentionEveryone | PermissionCreateInvites | PermissionManageMessages |
+	PermissionManageChannels | PermissionManageRoles | PermissionManageServer |
+	PermissionKickMembers | PermissionBanMembers | PermissionAdministrator | PermissionViewAuditLog
+
+// DefaultPermissions are granted to every member through the @everyone role of a new server
+const DefaultPermissions = PermissionViewChannels | PermissionSendMessages | PermissionAttachFiles |
+	PermissionCreateInvites
+
+// Has reports whether every bit of perm is set
+func (p Permission) Has(perm Permission) bool {
+	return p&perm == perm
+}
+
+// DefaultRoleName is the name of the role every member implicitly holds
+const DefaultRoleName = "@everyone"
+
+// Role is a named set of permissions in a server. Roles with a higher position
+// outrank lower ones; the @everyone role is always at position 0.
+type Role struct {
+	ID          string     `json:"id"`
+	ServerId    string     `json:"serverId"`
+	Name        string     `json:"name"`
+	Color       string     `json:"color"`
+	Position    int        `json:"position"`
+	Permissions Permission `json:"permissions"`
+	IsDefault   bool       `json:"isDefault"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+}
+
+// RoleRequest represents the request to create or update a role
+type RoleRequest struct {
+	Name        string      `json:"name" binding:"required,min=1,max=50"`
+	Color       string      `json:"color" binding:"omitempty,hexcolor"`
+	Permissions *Permission `json:"permissions"`
+}
+
+// ReorderRolesRequest lists every role except @everyone from lowest to highest
+type ReorderRolesRequest struct {
+	RoleIds []string `json:"roleIds" binding:"required"`
+}
+
+// MemberRolesRequest sets the roles held by a member
+type MemberRolesRequest struct {
+	RoleIds []string `json:"roleIds"`
+}
+
+// OverwritablePermissions are the permissions a channel overwrite may allow or deny
+const OverwritablePermissions = PermissionViewChannels | PermissionSendMessages | PermissionAttachFiles |
+	PermissionManageMessages
+
+// Channel overwrite targets
+const (
+	OverwriteTargetRole   = "role"
+	OverwriteTargetMember = "member"
+)
+
+// ChannelOverwrite allows or denies permissions in one channel for a role or a member.
+// Denies are applied before allows; @everyone first, then roles, then the member.
+type ChannelOverwrite struct {
+	ChannelId  string     `json:"channelId"`
+	TargetType string     `json:"targetType"`
+	TargetId   string     `json:"targetId"`
+	Allow      Permission `json:"allow"`
+	Deny       Permission `json:"deny"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+}
+
+// OverwriteRequest sets the permissions a channel overwrite allows and denies
+type OverwriteRequest struct {
+	Allow Permission `json:"allow"`
+	Deny  Permission `json:"deny"`
+}
@@ -10,6 +10,7 @@ type Server struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	OwnerId     string    `json:"ownerId"`
+	IconURL     string    `json:"iconUrl"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
@@ -32,10 +33,20 @@ type Channel struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	IsPrivate   bool      `json:"isPrivate"`
+	Mode        string    `json:"mode"`
+	Slowmode    int       `json:"slowmode"` // seconds between a member's messages, 0 when off
+	Position    int       `json:"position"`
 	CreatedAt   time.Time `json:"createdAt"`
 	UpdatedAt   time.Time `json:"updatedAt"`
 }
 
+// Channel modes
+const (
+	ChannelModeNormal       = "normal"       // members with Send Messages may post
+	ChannelModeAnnouncement = "announcement" // only members with Manage Messages may post
+	ChannelModeArchived     = "archived"     // history is readable but nobody may post
+)
+
 // ServerMember represents a user's membership in a server
 type ServerMember struct {
 	ID        string    `json:"id"`
@@ -54,10 +65,12 @@ type ChannelMember struct {
 	AddedAt   time.Time `json:"addedAt"`
 }
 
-// ServerRequest represents the request to create a new server
+// ServerRequest represents the request to create a new server.
+// Template is the ID of a saved template or the key of a built-in preset.
 type ServerRequest struct {
 	Name        string `json:"name" binding:"required,min=3,max=50"`
 	Description string `json:"description" binding:"max=200"`
+	Template    string `json:"template"`
 }
 
 // ChannelRequest represents the request to create a new channel
@@ -66,16 +79,39 @@ type ChannelRequest struct {
 	Description string `json:"description" binding:"max=200"`
 	IsPrivate   bool   `json:"isPrivate"`
 	CategoryId  string `json:"categoryId"`
+	Mode        string `json:"mode" binding:"omitempty,oneof=normal announcement archived"`
+	Slowmode    int    `json:"slowmode" binding:"min=0,max=21600"`
 }
 
 // ServerResponse represents the server data returned to clients
 type ServerResponse struct {
-	ID          string    `json:"id"`
-	Name        string    `json:"name"`
-	Description string    `json:"description"`
-	OwnerId     string    `json:"ownerId"`
-	CreatedAt   time.Time `json:"createdAt"`
-	MemberCount int       `json:"memberCount"`
+	ID                string    `json:"id"`
+	Name              string    `json:"name"`
+	Description       string    `json:"description"`
+	OwnerId           string    `json:"ownerId"`
+	IconURL           string    `json:"iconUrl"`
+	AllowDirectJoin   bool      `json:"allowDirectJoin"`
+	Discoverable      bool      `json:"discoverable"`
+	DiscoveryCategory string    `json:"discoveryCategory"`
+	Tags              []string  `json:"tags"`
+	CreatedAt         time.Time `json:"createdAt"`
+	MemberCount       int       `json:"memberCount"`
+}
+
+// UpdateServerRequest represents the request to change a server's settings.
+// Omitted fields are left unchanged.
+type UpdateServerRequest struct {
+	Name              *string   `json:"name" binding:"omitempty,min=3,max=50"`
+	Description       *string   `json:"description" binding:"omitempty,max=200"`
+	AllowDirectJoin   *bool     `json:"allowDirectJoin"`
+	Discoverable      *bool     `json:"discoverable"`
+	DiscoveryCategory *string   `json:"discoveryCategory" binding:"omitempty,oneof=gaming music education science technology entertainment art community other"`
+	Tags              *[]string `json:"tags" binding:"omitempty,max=5,dive,min=1,max=20,alphanum"`
+}
+
+// TransferOwnershipRequest represents the request to hand a server to another member
+type TransferOwnershipRequest struct {
+	UserId string `json:"userId" binding:"required,uuid"`
 }
 
 // ChannelResponse represents the channel data returned to clients
@@ -86,9 +122,34 @@ type ChannelResponse struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	IsPrivate   bool      `json:"isPrivate"`
+	Mode        string    `json:"mode"`
+	Slowmode    int       `json:"slowmode"`
+	Position    int       `json:"position"`
 	CreatedAt   time.Time `json:"createdAt"`
 }
 
+// UpdateChannelRequest represents the request to change a channel's name, description, mode
+// or slowmode interval. Omitted fields are left unchanged.
+type UpdateChannelRequest struct {
+	Name        *string `json:"name" binding:"omitempty,min=3,max=50"`
+	Description *string `json:"description" binding:"omitempty,max=200"`
+	Mode        *string `json:"mode" binding:"omitempty,oneof=normal announcement archived"`
+	Slowmode    *int    `json:"slowmode" binding:"omitempty,min=0,max=21600"`
+}
+
+// LayoutRequest reorders a server's categories and channels in one call. It must list all of them.
+type LayoutRequest struct {
+	Categories []string          `json:"categories" binding:"dive,uuid"` // category IDs in display order
+	Channels   []ChannelPosition `json:"channels" binding:"dive"`
+}
+
+// ChannelPosition places a channel in a category (empty for none) at a position
+type ChannelPosition struct {
+	ID         string `json:"id" binding:"required,uuid"`
+	CategoryId string `json:"categoryId" binding:"omitempty,uuid"`
+	Position   int    `json:"position" binding:"min=0"`
+}
+
 // CategoryRequest represents the request to create a new category
 type CategoryRequest struct {
 	Name string `json:"name" binding:"required,min=1,max=50"`
@@ -102,3 +163,68 @@ type CategoryResponse struct {
 	Position  int       `json:"position"`
 	CreatedAt time.Time `json:"createdAt"`
 }
+
+// Ban records that a user may not join a server
+type Ban struct {
+	ServerId  string    `json:"serverId"`
+	UserId    string    `json:"userId"`
+	Username  string    `json:"username"`
+	BannedBy  string    `json:"bannedBy,omitempty"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ModerationRequest carries the optional reason for a kick or ban
+type ModerationRequest struct {
+	Reason string `json:"reason" binding:"max=512"`
+}
+
+// MemberResponse represents a server member returned in the member list
+type MemberResponse struct {
+	UserId      string    `json:"userId"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl"`
+	IsOwner     bool      `json:"isOwner"`
+	Roles       []Role    `json:"roles"`
+	JoinedAt    time.Time `json:"joinedAt"`
+	Online      bool      `json:"online"`
+}
+
+// MemberListResponse is one page of a server's members
+type MemberListResponse struct {
+	Members []MemberResponse `json:"members"`
+	Limit   int              `json:"limit"`
+	Offset  int              `json:"offset"`
+	HasMore bool             `json:"hasMore"`
+}
+
+// ChannelMemberResponse represents a user with access to a private channel
+type ChannelMemberResponse struct {
+	UserId      string    `json:"userId"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	AvatarURL   string    `json:"avatarUrl"`
+	AddedAt     time.Time `json:"addedAt"`
+}
+
+// DirectoryServer is a server listed in the public discovery directory
+type DirectoryServer struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Description    string     `json:"description"`
+	IconURL        string     `json:"iconUrl"`
+	Category       string     `json:"category"`
+	Tags           []string   `json:"tags"`
+	MemberCount    int        `json:"memberCount"`
+	LastActivityAt *time.Time `json:"lastActivityAt,omitempty"`
+	IsMember       bool       `json:"isMember"`
+}
+
+// DirectoryResponse is one page of the discovery directory
+type DirectoryResponse struct {
+	Servers []DirectoryServer `json:"servers"`
+	Limit   int               `json:"limit"`
+	Offset  int               `json:"offset"`
+	HasMore bool              `json:"hasMore"`
+}
@@ -0,0 +1,62 @@
+package models
+
+import (
+	"time"
+)
+
+// ServerTemplate is a reusable server structure, either saved by a user or built in
+type ServerTemplate struct {
+	ID             string            `json:"id"` // the preset key for built-in presets
+	Name           string            `json:"name"`
+	Description    string            `json:"description"`
+	CreatedBy      string            `json:"createdBy,omitempty"`
+	SourceServerId string            `json:"sourceServerId,omitempty"`
+	IsPreset       bool              `json:"isPreset"`
+	Structure      TemplateStructure `json:"structure"`
+	CreatedAt      time.Time         `json:"createdAt"`
+}
+
+// TemplateStructure describes the categories, channels and roles a server is created with
+type TemplateStructure struct {
+	EveryonePermissions Permission         `json:"everyonePermissions"`
+	Roles               []TemplateRole     `json:"roles"`      // lowest to highest, not including @everyone
+	Categories          []TemplateCategory `json:"categories"` // in display order
+	Channels            []TemplateChannel  `json:"channels"`   // in display order
+}
+
+// TemplateRole is a role in a template
+type TemplateRole struct {
+	Name        string     `json:"name"`
+	Color       string     `json:"color"`
+	Permissions Permission `json:"permissions"`
+}
+
+// TemplateCategory is a category in a template
+type TemplateCategory struct {
+	Name string `json:"name"`
+}
+
+// TemplateChannel is a channel in a template. Category is an index into Categories.
+type TemplateChannel struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	IsPrivate   bool                `json:"isPrivate"`
+	Mode        string              `json:"mode,omitempty"`
+	Slowmode    int                 `json:"slowmode,omitempty"`
+	Category    *int                `json:"category,omitempty"`
+	Overwrites  []TemplateOverwrite `json:"overwrites,omitempty"`
+}
+
+// TemplateOverwrite is a role overwrite on a template channel. Role is an index into Roles,
+// or nil for @everyone.
+type TemplateOverwrite struct {
+	Role  *int       `json:"role,omitempty"`
+	Allow Permission `json:"allow"`
+	Deny  Permission `json:"deny"`
+}
+
+// SaveTemplateRequest represents the request to save a server as a template
+type SaveTemplateRequest struct {
+	Name        string `json:"name" binding:"required,min=1,max=50"`
+	Description string `json:"description" binding:"max=200"`
+}
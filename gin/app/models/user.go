@@ -6,21 +6,98 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	Password  string    `json:"-"` // Password is not included in JSON responses
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID           string     `json:"id"`
+	Username     string     `json:"username"`
+	Email        string     `json:"email"`
+	Password     string     `json:"-"` // Password is not included in JSON responses
+	DisplayName  string     `json:"displayName"`
+	Bio          string     `json:"bio"`
+	AvatarURL    string     `json:"avatarUrl"`
+	Discoverable bool       `json:"discoverable"` // Whether users without a shared server can find this user
+	VerifiedAt   *time.Time `json:"verifiedAt"`
+	TOTPEnabled  bool       `json:"twoFactorEnabled"`
+	CreatedAt    time.Time  `json:"createdAt"`
+	UpdatedAt    time.Time  `json:"updatedAt"`
+}
+
+// PublicProfile is the part of a user that other users may see
+type PublicProfile struct {
+	ID          string    `json:"id"`
+	Username    string    `json:"username"`
+	DisplayName string    `json:"displayName"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatarUrl"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Profile returns the public part of the user
+func (u *User) Profile() PublicProfile {
+	return PublicProfile{
+		ID:          u.ID,
+		Username:    u.Username,
+		DisplayName: u.DisplayName,
+		Bio:         u.Bio,
+		AvatarURL:   u.AvatarURL,
+		CreatedAt:   u.CreatedAt,
+	}
+}
+
+// UpdateProfileRequest represents a partial profile update. Omitted fields are left unchanged.
+type UpdateProfileRequest struct {
+	DisplayName  *string `json:"displayName" binding:"omitempty,max=50"`
+	Username     *string `json:"username" binding:"omitempty,min=3,max=30"`
+	Bio          *string `json:"bio" binding:"omitempty,max=500"`
+	Discoverable *bool   `json:"discoverable"`
+}
+
+// UserSearchResponse is one page of user search results
+type UserSearchResponse struct {
+	Users   []PublicProfile `json:"users"`
+	Limit   int             `json:"limit"`
+	Offset  int             `json:"offset"`
+	HasMore bool            `json:"hasMore"`
+}
+
+// ChangePasswordRequest represents the request to change the password of a signed-in user
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required_without=ReauthToken"`
+	ReauthToken     string `json:"reauthToken"`
+	NewPassword     string `json:"newPassword" binding:"required,min=6"`
 }
 
 // UserResponse is the data structure returned to clients after authentication
 type UserResponse struct {
-	ID        string    `json:"id"`
-	Username  string    `json:"username"`
-	Email     string    `json:"email"`
-	CreatedAt time.Time `json:"createdAt"`
-	Token     string    `json:"token"`
+	ID            string    `json:"id"`
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"emailVerified"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Token         string    `json:"token"`
+	RefreshToken  string    `json:"refreshToken"`
+	ExpiresAt     time.Time `json:"expiresAt"` // Expiry of the access token
+}
+
+// TokenPair is the access/refresh token pair issued for a session
+type TokenPair struct {
+	Token        string    `json:"token"`
+	RefreshToken string    `json:"refreshToken"`
+	ExpiresAt    time.Time `json:"expiresAt"` // Expiry of the access token
+}
+
+// Session is a signed-in device as shown in the session list
+type Session struct {
+	ID         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IPAddress  string    `json:"ipAddress"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Current    bool      `json:"current"` // Whether this is the session making the request
+}
+
+// ClientInfo identifies where a request came from
+type ClientInfo struct {
+	IPAddress string
+	UserAgent string
 }
 
 // LoginRequest represents the login request data structure
@@ -35,3 +112,70 @@ type RegisterRequest struct {
 	Email    string `json:"email" binding:"required,email"`
 	Password string `json:"password" binding:"required,min=6"`
 }
+
+// RefreshRequest represents the request to rotate a refresh token
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" binding:"required"`
+}
+
+// ForgotPasswordRequest represents the request to send a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents the request to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// VerifyEmailRequest represents the request to confirm an email address
+type VerifyEmailRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// MFAChallengeResponse is returned by login when a second factor is required
+type MFAChallengeResponse struct {
+	MFARequired bool      `json:"mfaRequired"`
+	MFAToken    string    `json:"mfaToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// LoginTOTPRequest represents the second login step with a TOTP or recovery code
+type LoginTOTPRequest struct {
+	MFAToken     string `json:"mfaToken" binding:"required"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// TOTPSetupResponse contains the pending secret and its provisioning URI for QR codes
+type TOTPSetupResponse struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioningUri"`
+}
+
+// TOTPCodeRequest represents a request confirmed with a TOTP code
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// DisableTOTPRequest represents the request to turn off two-factor authentication
+type DisableTOTPRequest struct {
+	Password     string `json:"password" binding:"required_without=ReauthToken"`
+	ReauthToken  string `json:"reauthToken"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// ReauthResponse is returned when a signed-in user re-authenticates at the identity provider.
+// The token stands in for the password when confirming sensitive account changes.
+type ReauthResponse struct {
+	ReauthToken string    `json:"reauthToken"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+}
+
+// OIDCCallbackRequest carries the authorization response forwarded by the frontend
+type OIDCCallbackRequest struct {
+	Code  string `json:"code" form:"code" binding:"required"`
+	State string `json:"state" form:"state" binding:"required"`
+}
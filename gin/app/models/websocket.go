@@ -12,18 +12,30 @@ type WebSocketClient struct {
 	ID        string
 	Conn      *websocket.Conn
 	UserID    string
+	SessionID string // パーソナルアクセストークンで接続した場合は空
 	ChannelID string
 	Send      chan []byte
 }
 
 // WebSocketMessage はWebSocketを通じて送受信されるメッセージの構造体
 type WebSocketMessage struct {
-	Type      string      `json:"type"`                // メッセージタイプ: "message", "message_update", "message_delete"
-	Message   interface{} `json:"message,omitempty"`   // メッセージ本体（新規または更新）
+	Type      string      `json:"type"`                // メッセージタイプ: "message", "message_update", "message_delete", "user_update", "channel_update", "channel_delete", "category_update", "category_delete", "layout_update", "channel_state"
+	Message   interface{} `json:"message,omitempty"`   // メッセージ本体（新規または更新）、user_updateではプロフィール、channel_stateではChannelState、その他のイベントでは変更内容
 	MessageID string      `json:"messageId,omitempty"` // メッセージID（削除時に使用）
 	Timestamp time.Time   `json:"timestamp"`           // タイムスタンプ
 }
 
+// ChannelState はチャンネルのモードと、接続中のユーザーが投稿できるかどうかを表す。
+// 接続直後とモードやスローモードの変更時にchannel_stateとして各クライアントへ個別に送信される。
+type ChannelState struct {
+	ChannelId      string `json:"channelId"`
+	Mode           string `json:"mode"`
+	CanSend        bool   `json:"canSend"`
+	CanAttachFiles bool   `json:"canAttachFiles"`
+	Slowmode       int    `json:"slowmode"` // チャンネルのスローモード間隔（秒）
+	Cooldown       int    `json:"cooldown"` // このユーザーが次に投稿できるまでの残り秒数（免除されている場合は0）
+}
+
 // WebSocketHub はWebSocket接続を管理するハブ
 type WebSocketHub struct {
 	// チャンネルIDごとのクライアントマップ
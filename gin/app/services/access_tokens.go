@@ -0,0 +1,166 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// accessTokenPrefix marks personal access tokens so they can be told apart from JWTs
+const accessTokenPrefix = "pat_"
+
+var (
+	// ErrInvalidAccessToken is returned when a personal access token is unknown, revoked or expired
+	ErrInvalidAccessToken = errors.New("invalid or expired access token")
+
+	// ErrAccessTokenNotFound is returned when revoking a token the user does not own
+	ErrAccessTokenNotFound = errors.New("access token not found")
+)
+
+// HasScope reports whether the credentials grant a scope. Login sessions are not scoped.
+func (c *TokenClaims) HasScope(scope string) bool {
+	if c.Scopes == nil {
+		return true
+	}
+	for _, s := range c.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate validates either a login access token or a personal access token
+func (s *UserService) Authenticate(tokenString string) (*TokenClaims, error) {
+	if strings.HasPrefix(tokenString, accessTokenPrefix) {
+		return s.validateAccessToken(tokenString)
+	}
+	return s.ParseAccessToken(tokenString)
+}
+
+// CreateAccessToken creates a personal access token. The returned secret is not stored.
+func (s *UserService) CreateAccessToken(userID string, req models.CreateAccessTokenRequest) (*models.CreateAccessTokenResponse, error) {
+	for _, scope := range req.Scopes {
+		if !models.ValidScopes[scope] {
+			return nil, fmt.Errorf("unknown scope: %s", scope)
+		}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error generating access token: %w", err)
+	}
+	token := accessTokenPrefix + secret
+
+	now := time.Now()
+	var expiresAt *time.Time
+	if req.ExpiresInDays > 0 {
+		t := now.AddDate(0, 0, req.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	pat := models.PersonalAccessToken{
+		ID:        uuid.New().String(),
+		Name:      req.Name,
+		Prefix:    token[:len(accessTokenPrefix)+6],
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+		CreatedAt: now,
+	}
+
+	_, err = s.db.Exec(
+		"INSERT INTO personal_access_tokens (id, user_id, name, token_hash, token_prefix, scopes, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		pat.ID, userID, pat.Name, hashToken(token), pat.Prefix, pq.Array(pat.Scopes), pat.ExpiresAt, pat.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating access token: %w", err)
+	}
+
+	return &models.CreateAccessTokenResponse{PersonalAccessToken: pat, Token: token}, nil
+}
+
+// ListAccessTokens returns the user's active personal access tokens
+func (s *UserService) ListAccessTokens(userID string) ([]models.PersonalAccessToken, error) {
+	rows, err := s.db.Query(`
+		SELECT id, name, token_prefix, scopes, expires_at, last_used_at, created_at
+		FROM personal_access_tokens
+		WHERE user_id = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		var token models.PersonalAccessToken
+		if err := rows.Scan(
+			&token.ID, &token.Name, &token.Prefix, pq.Array(&token.Scopes),
+			&token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// RevokeAccessToken revokes one of the user's personal access tokens
+func (s *UserService) RevokeAccessToken(userID, tokenID string) error {
+	result, err := s.db.Exec(
+		"UPDATE personal_access_tokens SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		time.Now(), tokenID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+// validateAccessToken looks up a personal access token by its hash
+func (s *UserService) validateAccessToken(token string) (*TokenClaims, error) {
+	var tokenID, userID string
+	var scopes []string
+	var expiresAt sql.NullTime
+	err := s.db.QueryRow(
+		"SELECT id, user_id, scopes, expires_at FROM personal_access_tokens WHERE token_hash = $1 AND revoked_at IS NULL",
+		hashToken(token),
+	).Scan(&tokenID, &userID, pq.Array(&scopes), &expiresAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidAccessToken
+		}
+		return nil, fmt.Errorf("error finding access token: %w", err)
+	}
+
+	now := time.Now()
+	if expiresAt.Valid && now.After(expiresAt.Time) {
+		return nil, ErrInvalidAccessToken
+	}
+
+	// Track usage at most once a minute
+	_, err = s.db.Exec(
+		"UPDATE personal_access_tokens SET last_used_at = $1 WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)",
+		now, tokenID, now.Add(-time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error updating access token: %w", err)
+	}
+
+	if scopes == nil {
+		scopes = []string{}
+	}
+	return &TokenClaims{UserID: userID, Scopes: scopes}, nil
+}
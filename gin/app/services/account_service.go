@@ -0,0 +1,389 @@
+package services
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"app/models"
+)
+
+// AccountService handles self-service export and deletion of a user's personal data
+type AccountService struct {
+	db          *sql.DB
+	userService *UserService
+}
+
+// NewAccountService creates a new AccountService
+func NewAccountService(db *sql.DB, userService *UserService) *AccountService {
+	return &AccountService{
+		db:          db,
+		userService: userService,
+	}
+}
+
+// ExportData writes a zip archive with the user's profile, chatbot conversations,
+// channel messages and uploaded files to w
+func (s *AccountService) ExportData(userID string, w io.Writer) error {
+	user, err := s.userService.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	chats, err := s.exportChats(userID)
+	if err != nil {
+		return fmt.Errorf("error exporting chats: %w", err)
+	}
+
+	messages, err := s.exportChannelMessages(userID)
+	if err != nil {
+		return fmt.Errorf("error exporting channel messages: %w", err)
+	}
+
+	archive := zip.NewWriter(w)
+
+	// Copy uploaded files and point the export at the copies
+	for i := range messages {
+		for j := range messages[i].Attachments {
+			attachment := &messages[i].Attachments[j]
+			name := "attachments/" + attachment.ID + "-" + filepath.Base(attachment.FileName)
+			if err := addFileToArchive(archive, name, attachment.FilePath); err != nil {
+				log.Printf("error exporting attachment %s: %v", attachment.ID, err)
+				name = ""
+			}
+			attachment.FilePath = name
+		}
+	}
+	if strings.HasPrefix(user.AvatarURL, avatarURLPath) {
+		name := "avatar" + filepath.Ext(user.AvatarURL)
+		if err := addFileToArchive(archive, name, filepath.Join(avatarDir, filepath.Base(user.AvatarURL))); err != nil {
+			log.Printf("error exporting avatar of user %s: %v", userID, err)
+		}
+	}
+
+	if err := addJSONToArchive(archive, "profile.json", user); err != nil {
+		return err
+	}
+	if err := addJSONToArchive(archive, "chats.json", chats); err != nil {
+		return err
+	}
+	if err := addJSONToArchive(archive, "channel_messages.json", messages); err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
+
+// exportChats loads the user's chatbot conversations with their messages
+func (s *AccountService) exportChats(userID string) ([]models.ExportedChat, error) {
+	rows, err := s.db.Query(
+		"SELECT id, COALESCE(title, ''), created_at FROM chats WHERE user_id = $1 ORDER BY created_at ASC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	chats := []models.ExportedChat{}
+	for rows.Next() {
+		chat := models.ExportedChat{Messages: []models.ChatbotMessage{}}
+		if err := rows.Scan(&chat.ID, &chat.Title, &chat.CreatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chats = append(chats, chat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range chats {
+		messageRows, err := s.db.Query(
+			"SELECT id, chat_id, content, role, timestamp FROM chatbot_messages WHERE chat_id = $1 ORDER BY timestamp ASC",
+			chats[i].ID,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for messageRows.Next() {
+			var message models.ChatbotMessage
+			if err := messageRows.Scan(&message.ID, &message.ChatId, &message.Content, &message.Role, &message.Timestamp); err != nil {
+				messageRows.Close()
+				return nil, err
+			}
+			chats[i].Messages = append(chats[i].Messages, message)
+		}
+		messageRows.Close()
+		if err := messageRows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return chats, nil
+}
+
+// exportChannelMessages loads every channel message the user wrote, including deleted ones
+func (s *AccountService) exportChannelMessages(userID string) ([]models.ExportedChannelMessage, error) {
+	rows, err := s.db.Query(`
+		SELECT m.id, s.id, s.name, c.id, c.name, m.content, m.timestamp, m.is_edited, m.is_deleted
+		FROM channel_messages m
+		JOIN channels c ON m.channel_id = c.id
+		JOIN servers s ON c.server_id = s.id
+		WHERE m.user_id = $1
+		ORDER BY m.timestamp ASC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := []models.ExportedChannelMessage{}
+	index := make(map[string]int)
+	for rows.Next() {
+		message := models.ExportedChannelMessage{Attachments: []models.ChannelAttachment{}}
+		if err := rows.Scan(
+			&message.ID, &message.ServerID, &message.ServerName, &message.ChannelID, &message.ChannelName,
+			&message.Content, &message.Timestamp, &message.IsEdited, &message.IsDeleted,
+		); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		index[message.ID] = len(messages)
+		messages = append(messages, message)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attachmentRows, err := s.db.Query(`
+		SELECT a.id, a.message_id, a.file_name, a.file_type, a.file_path, a.file_size, a.uploaded_at
+		FROM channel_attachments a
+		JOIN channel_messages m ON a.message_id = m.id
+		WHERE m.user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer attachmentRows.Close()
+
+	for attachmentRows.Next() {
+		var attachment models.ChannelAttachment
+		if err := attachmentRows.Scan(
+			&attachment.ID, &attachment.MessageId, &attachment.FileName, &attachment.FileType,
+			&attachment.FilePath, &attachment.FileSize, &attachment.UploadedAt,
+		); err != nil {
+			return nil, err
+		}
+		if i, ok := index[attachment.MessageId]; ok {
+			messages[i].Attachments = append(messages[i].Attachments, attachment)
+		}
+	}
+
+	return messages, attachmentRows.Err()
+}
+
+// DeleteAccount permanently deletes a user after checking their password, or the
+// reauthentication token of a user signed in through an identity provider.
+// Channel messages are handed to the deleted-user placeholder, owned servers go to
+// the highest-ranked remaining member, and servers without other members are deleted.
+func (s *AccountService) DeleteAccount(userID, password, reauthToken string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var hashedPassword, avatarURL string
+	err = tx.QueryRow(
+		"SELECT password, COALESCE(avatar_url, '') FROM users WHERE id = $1 FOR UPDATE",
+		userID,
+	).Scan(&hashedPassword, &avatarURL)
+	if err != nil {
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if err := s.userService.confirmIdentity(userID, hashedPassword, password, reauthToken); err != nil {
+		return err
+	}
+
+	// Files to remove once the deletion is committed
+	var files []string
+	if strings.HasPrefix(avatarURL, avatarURLPath) {
+		files = append(files, filepath.Join(avatarDir, filepath.Base(avatarURL)))
+	}
+
+	deletedServerFiles, err := s.releaseOwnedServers(tx, userID)
+	if err != nil {
+		return err
+	}
+	files = append(files, deletedServerFiles...)
+
+	// Anonymize the remaining channel messages
+	if _, err := tx.Exec("UPDATE channel_messages SET user_id = $1 WHERE user_id = $2", models.DeletedUserID, userID); err != nil {
+		return fmt.Errorf("error anonymizing channel messages: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM channel_members WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error removing channel memberships: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM channel_overwrites WHERE target_type = 'member' AND target_id = $1", userID); err != nil {
+		return fmt.Errorf("error removing channel overwrites: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM server_members WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error removing server memberships: %w", err)
+	}
+
+	// Chats, sessions, tokens and the rest of the user's rows cascade
+	if _, err := tx.Exec("DELETE FROM users WHERE id = $1", userID); err != nil {
+		return fmt.Errorf("error deleting user: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("error removing file %s: %v", file, err)
+		}
+	}
+
+	return nil
+}
+
+// releaseOwnedServers transfers each server owned by the user to the member with the
+// highest role, or failing that the longest-standing member. Servers without other
+// members are deleted and the paths of their attachments are returned so they can be
+// removed from disk.
+func (s *AccountService) releaseOwnedServers(tx *sql.Tx, userID string) ([]string, error) {
+	rows, err := tx.Query("SELECT id FROM servers WHERE owner_id = $1", userID)
+	if err != nil {
+		return nil, fmt.Errorf("error finding owned servers: %w", err)
+	}
+	var serverIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		serverIDs = append(serverIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var files []string
+	now := time.Now()
+	for _, serverID := range serverIDs {
+		// The member with the highest role wins, then whoever joined first
+		var successorID string
+		err := tx.QueryRow(`
+			SELECT sm.user_id FROM server_members sm
+			WHERE sm.server_id = $1 AND sm.user_id <> $2
+			ORDER BY COALESCE((
+			  SELECT MAX(sr.position)
+			  FROM server_member_roles mr
+			  JOIN server_roles sr ON sr.id = mr.role_id
+			  WHERE mr.server_id = sm.server_id AND mr.user_id = sm.user_id
+			), 0) DESC, sm.joined_at ASC
+			LIMIT 1
+		`, serverID, userID).Scan(&successorID)
+
+		if err == sql.ErrNoRows {
+			serverFiles, err := serverFilePaths(tx, serverID)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := tx.Exec("DELETE FROM servers WHERE id = $1", serverID); err != nil {
+				return nil, fmt.Errorf("error deleting server: %w", err)
+			}
+			files = append(files, serverFiles...)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error finding new server owner: %w", err)
+		}
+
+		if _, err := tx.Exec("UPDATE servers SET owner_id = $1, updated_at = $2 WHERE id = $3", successorID, now, serverID); err != nil {
+			return nil, fmt.Errorf("error transferring server: %w", err)
+		}
+		if _, err := tx.Exec(
+			"UPDATE server_members SET role = 'owner', updated_at = $1 WHERE server_id = $2 AND user_id = $3",
+			now, serverID, successorID,
+		); err != nil {
+			return nil, fmt.Errorf("error transferring server: %w", err)
+		}
+	}
+
+	return files, nil
+}
+
+// serverFilePaths returns the files stored for a server: its icon and the attachments in its channels
+func serverFilePaths(tx *sql.Tx, serverID string) ([]string, error) {
+	var iconURL string
+	if err := tx.QueryRow("SELECT COALESCE(icon_url, '') FROM servers WHERE id = $1", serverID).Scan(&iconURL); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	if strings.HasPrefix(iconURL, serverIconURLPath) {
+		paths = append(paths, filepath.Join(serverIconDir, filepath.Base(iconURL)))
+	}
+
+	rows, err := tx.Query(`
+		SELECT a.file_path
+		FROM channel_attachments a
+		JOIN channel_messages m ON a.message_id = m.id
+		JOIN channels c ON m.channel_id = c.id
+		WHERE c.server_id = $1
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, rows.Err()
+}
+
+// addJSONToArchive writes v as indented JSON into the archive
+func addJSONToArchive(archive *zip.Writer, name string, v interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// addFileToArchive copies a file from disk into the archive
+func addFileToArchive(archive *zip.Writer, name, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
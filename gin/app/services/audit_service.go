@@ -0,0 +1,168 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultAuditLogLimit = 50
+	maxAuditLogLimit     = 100
+	maxAuditReasonLength = 512
+)
+
+// auditIgnoredKeys are fields that change on every write or identify the target,
+// so they are left out of diffs
+var auditIgnoredKeys = map[string]bool{
+	"id":        true,
+	"serverId":  true,
+	"createdAt": true,
+	"updatedAt": true,
+}
+
+// AuditLogService records administrative actions taken in servers
+type AuditLogService struct {
+	db *sql.DB
+}
+
+// NewAuditLogService creates a new audit log service
+func NewAuditLogService(db *sql.DB) *AuditLogService {
+	return &AuditLogService{db: db}
+}
+
+// Record stores an audit log entry
+func (s *AuditLogService) Record(entry models.AuditLogEntry) error {
+	if entry.Changes == nil {
+		entry.Changes = []models.AuditChange{}
+	}
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	// Cut on a character boundary so multibyte reasons stay valid UTF-8
+	if reason := []rune(entry.Reason); len(reason) > maxAuditReasonLength {
+		entry.Reason = string(reason[:maxAuditReasonLength])
+	}
+
+	var actorId interface{}
+	if entry.ActorId != "" {
+		actorId = entry.ActorId
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO audit_log (id, server_id, actor_id, action, target_type, target_id, changes, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`, uuid.New().String(), entry.ServerId, actorId, entry.Action, entry.TargetType, entry.TargetId, changes, entry.Reason, time.Now())
+	if err != nil {
+		return fmt.Errorf("error recording audit log entry: %w", err)
+	}
+	return nil
+}
+
+// ListEntries returns a page of a server's audit log, newest first
+func (s *AuditLogService) ListEntries(serverId string, filter models.AuditLogFilter) (*models.AuditLogResponse, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuditLogLimit
+	}
+	limit = min(limit, maxAuditLogLimit)
+	offset := max(filter.Offset, 0)
+
+	response := &models.AuditLogResponse{Entries: []models.AuditLogEntry{}, Limit: limit, Offset: offset}
+
+	// Fetch one extra row to tell whether there is another page
+	rows, err := s.db.Query(`
+		SELECT a.id, a.server_id, COALESCE(a.actor_id::text, ''), COALESCE(u.username, ''),
+		       a.action, a.target_type, a.target_id, a.changes, a.reason, a.created_at
+		FROM audit_log a
+		LEFT JOIN users u ON u.id = a.actor_id
+		WHERE a.server_id = $1
+		  AND ($2 = '' OR a.actor_id::text = $2)
+		  AND ($3 = '' OR a.action = $3)
+		  AND ($4 = '' OR a.target_type = $4)
+		  AND ($5 = '' OR a.target_id = $5)
+		ORDER BY a.created_at DESC, a.id DESC
+		LIMIT $6 OFFSET $7
+	`, serverId, filter.ActorId, filter.Action, filter.TargetType, filter.TargetId, limit+1, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error listing audit log: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entry models.AuditLogEntry
+		var changes []byte
+		err := rows.Scan(
+			&entry.ID, &entry.ServerId, &entry.ActorId, &entry.ActorUsername,
+			&entry.Action, &entry.TargetType, &entry.TargetId, &changes, &entry.Reason, &entry.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(changes, &entry.Changes); err != nil {
+			return nil, fmt.Errorf("error reading audit log changes: %w", err)
+		}
+		response.Entries = append(response.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Entries) > limit {
+		response.Entries = response.Entries[:limit]
+		response.HasMore = true
+	}
+	return response, nil
+}
+
+// AuditDiff compares the JSON form of two values and returns the fields that differ.
+// A nil before records a creation and a nil after a removal.
+func AuditDiff(before, after interface{}) []models.AuditChange {
+	beforeFields := auditFields(before)
+	afterFields := auditFields(after)
+
+	keys := make([]string, 0, len(beforeFields)+len(afterFields))
+	for key := range beforeFields {
+		keys = append(keys, key)
+	}
+	for key := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []models.AuditChange{}
+	for _, key := range keys {
+		if auditIgnoredKeys[key] {
+			continue
+		}
+		oldValue, newValue := beforeFields[key], afterFields[key]
+		if reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+		changes = append(changes, models.AuditChange{Key: key, Before: oldValue, After: newValue})
+	}
+	return changes
+}
+
+// auditFields converts a value to a map of its JSON fields
+func auditFields(value interface{}) map[string]interface{} {
+	fields := map[string]interface{}{}
+	if value == nil || reflect.ValueOf(value).Kind() == reflect.Ptr && reflect.ValueOf(value).IsNil() {
+		return fields
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fields
+	}
+	json.Unmarshal(data, &fields)
+	return fields
+}
@@ -0,0 +1,239 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"app/models"
+)
+
+var (
+	// ErrCategoryNotFound is returned when a category does not exist in the server
+	ErrCategoryNotFound = errors.New("category not found")
+
+	// ErrChannelNotFound is returned when a channel does not exist in the server
+	ErrChannelNotFound = errors.New("channel not found")
+
+	// ErrInvalidLayout is returned when a layout leaves out or repeats a category or channel,
+	// or puts two channels at the same position in a category
+	ErrInvalidLayout = errors.New("layout must list every category and channel exactly once, at distinct positions")
+)
+
+// GetServerChannelIds returns the IDs of every channel in a server
+func (s *ServerService) GetServerChannelIds(serverId string) ([]string, error) {
+	return serverChannelIDs(s.db, serverId)
+}
+
+// UpdateChannel changes the name, description, mode and slowmode interval of a channel
+func (s *ServerService) UpdateChannel(channelId string, req models.UpdateChannelRequest) (models.Channel, error) {
+	result, err := s.db.Exec(`
+		UPDATE channels SET
+			name = COALESCE($1, name),
+			description = COALESCE($2, description),
+			mode = COALESCE($3, mode),
+			slowmode_seconds = COALESCE($4, slowmode_seconds),
+			updated_at = $5
+		WHERE id = $6
+	`, req.Name, req.Description, req.Mode, req.Slowmode, time.Now(), channelId)
+	if err != nil {
+		return models.Channel{}, fmt.Errorf("error updating channel: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return models.Channel{}, ErrChannelNotFound
+	}
+	return s.GetChannelByID(channelId)
+}
+
+// GetCategory returns a category in the server
+func (s *ServerService) GetCategory(serverId, categoryId string) (*models.CategoryResponse, error) {
+	var category models.CategoryResponse
+	err := s.db.QueryRow(
+		"SELECT id, server_id, name, position, created_at FROM categories WHERE id = $1 AND server_id = $2",
+		categoryId, serverId,
+	).Scan(&category.ID, &category.ServerId, &category.Name, &category.Position, &category.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &category, nil
+}
+
+// UpdateCategory renames a category
+func (s *ServerService) UpdateCategory(serverId, categoryId, name string) (*models.CategoryResponse, error) {
+	var category models.CategoryResponse
+	err := s.db.QueryRow(`
+		UPDATE categories SET name = $1, updated_at = $2
+		WHERE id = $3 AND server_id = $4
+		RETURNING id, server_id, name, position, created_at
+	`, name, time.Now(), categoryId, serverId).Scan(
+		&category.ID, &category.ServerId, &category.Name, &category.Position, &category.CreatedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrCategoryNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating category: %w", err)
+	}
+	return &category, nil
+}
+
+// DeleteCategory deletes a category and moves its channels to the end of moveToId,
+// or out of any category when moveToId is empty
+func (s *ServerService) DeleteCategory(serverId, categoryId, moveToId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := checkCategoryInServer(tx, serverId, categoryId); err != nil {
+		return err
+	}
+
+	var target interface{}
+	if moveToId != "" {
+		if moveToId == categoryId {
+			return ErrCategoryNotFound
+		}
+		if err := checkCategoryInServer(tx, serverId, moveToId); err != nil {
+			return err
+		}
+		target = moveToId
+	}
+
+	// Append the channels after the ones already in the target, keeping their relative order
+	_, err = tx.Exec(`
+		UPDATE channels c SET
+			category_id = $3::uuid,
+			position = moved.offset_base + moved.rank,
+			updated_at = $4
+		FROM (
+			SELECT id,
+			       ROW_NUMBER() OVER (ORDER BY position, name) - 1 AS rank,
+			       (SELECT COALESCE(MAX(position) + 1, 0) FROM channels WHERE server_id = $1::uuid AND category_id IS NOT DISTINCT FROM $3::uuid) AS offset_base
+			FROM channels
+			WHERE category_id = $2::uuid
+		) moved
+		WHERE c.id = moved.id
+	`, serverId, categoryId, target, time.Now())
+	if err != nil {
+		return fmt.Errorf("error moving channels: %w", err)
+	}
+
+	if _, err := tx.Exec("DELETE FROM categories WHERE id = $1", categoryId); err != nil {
+		return fmt.Errorf("error deleting category: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ReorderLayout sets the order of categories and the category and position of channels
+// in one transaction. The layout must list every category and channel of the server.
+func (s *ServerService) ReorderLayout(serverId string, req models.LayoutRequest) error {
+	if !validLayout(req) {
+		return ErrInvalidLayout
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Every ID is checked to belong to the server below, so equal counts mean nothing is missing
+	var categoryCount, channelCount int
+	err = tx.QueryRow(
+		"SELECT (SELECT COUNT(*) FROM categories WHERE server_id = $1), (SELECT COUNT(*) FROM channels WHERE server_id = $1)",
+		serverId,
+	).Scan(&categoryCount, &channelCount)
+	if err != nil {
+		return fmt.Errorf("error counting layout: %w", err)
+	}
+	if categoryCount != len(req.Categories) || channelCount != len(req.Channels) {
+		return ErrInvalidLayout
+	}
+
+	now := time.Now()
+	for position, categoryId := range req.Categories {
+		result, err := tx.Exec(
+			"UPDATE categories SET position = $1, updated_at = $2 WHERE id = $3 AND server_id = $4",
+			position, now, categoryId, serverId,
+		)
+		if err != nil {
+			return fmt.Errorf("error reordering categories: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrCategoryNotFound
+		}
+	}
+
+	for _, channel := range req.Channels {
+		var categoryId interface{}
+		if channel.CategoryId != "" {
+			if err := checkCategoryInServer(tx, serverId, channel.CategoryId); err != nil {
+				return err
+			}
+			categoryId = channel.CategoryId
+		}
+		result, err := tx.Exec(
+			"UPDATE channels SET category_id = $1::uuid, position = $2, updated_at = $3 WHERE id = $4 AND server_id = $5",
+			categoryId, channel.Position, now, channel.ID, serverId,
+		)
+		if err != nil {
+			return fmt.Errorf("error reordering channels: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return ErrChannelNotFound
+		}
+	}
+
+	return tx.Commit()
+}
+
+// validLayout reports whether a layout names each category and channel once and gives
+// every channel in a category its own position
+func validLayout(req models.LayoutRequest) bool {
+	categories := make(map[string]bool, len(req.Categories))
+	for _, id := range req.Categories {
+		if categories[id] {
+			return false
+		}
+		categories[id] = true
+	}
+
+	type slot struct {
+		categoryId string
+		position   int
+	}
+	channels := make(map[string]bool, len(req.Channels))
+	slots := make(map[slot]bool, len(req.Channels))
+	for _, channel := range req.Channels {
+		s := slot{channel.CategoryId, channel.Position}
+		if channels[channel.ID] || slots[s] {
+			return false
+		}
+		channels[channel.ID] = true
+		slots[s] = true
+	}
+	return true
+}
+
+// checkCategoryInServer returns ErrCategoryNotFound unless the category belongs to the server
+func checkCategoryInServer(tx *sql.Tx, serverId, categoryId string) error {
+	var exists bool
+	err := tx.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM categories WHERE id = $1 AND server_id = $2)",
+		categoryId, serverId,
+	).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrCategoryNotFound
+	}
+	return nil
+}
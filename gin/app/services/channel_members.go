@@ -0,0 +1,52 @@
+package services
+
+import (
+	"errors"
+
+	"app/models"
+)
+
+var (
+	// ErrAlreadyChannelMember is returned when adding a user who already has access to the channel
+	ErrAlreadyChannelMember = errors.New("user is already a member of this channel")
+
+	// ErrNotChannelMember is returned when removing a user who is not a member of the channel
+	ErrNotChannelMember = errors.New("user is not a member of this channel")
+)
+
+// ListChannelMembers returns the users on a private channel's allow-list
+func (s *ServerService) ListChannelMembers(channelId string) ([]models.ChannelMemberResponse, error) {
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), cm.added_at
+		FROM channel_members cm
+		JOIN users u ON u.id = cm.user_id
+		WHERE cm.channel_id = $1
+		ORDER BY u.username ASC
+	`, channelId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.ChannelMemberResponse{}
+	for rows.Next() {
+		var member models.ChannelMemberResponse
+		if err := rows.Scan(&member.UserId, &member.Username, &member.DisplayName, &member.AvatarURL, &member.AddedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// RemoveChannelMember takes a user off a private channel's allow-list
+func (s *ServerService) RemoveChannelMember(channelId, userId string) error {
+	result, err := s.db.Exec("DELETE FROM channel_members WHERE channel_id = $1 AND user_id = $2", channelId, userId)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotChannelMember
+	}
+	return nil
+}
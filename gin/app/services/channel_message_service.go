@@ -2,27 +2,29 @@ package services
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
-	"io"
 	"mime/multipart"
 	"os"
-	"path/filepath"
 	"time"
 
-	"github.com/google/uuid"
-
 	"app/models"
 )
 
+// ErrMessageNotFound is returned when a message does not exist
+var ErrMessageNotFound = errors.New("message not found")
+
 // ChannelMessageService handles channel message operations
 type ChannelMessageService struct {
-	DB *sql.DB
+	DB          *sql.DB
+	permissions *PermissionResolver
 }
 
 // NewChannelMessageService creates a new ChannelMessageService
 func NewChannelMessageService(db *sql.DB) *ChannelMessageService {
 	return &ChannelMessageService{
-		DB: db,
+		DB:          db,
+		permissions: NewPermissionResolver(db),
 	}
 }
 
@@ -102,15 +104,41 @@ func (s *ChannelMessageService) IsMessageAuthor(messageId, userId string) (bool,
 	return count > 0, nil
 }
 
+// CanDeleteChannelMessage checks if a user can delete a message: its author always can,
+// other members need the Manage Messages permission in the channel
+func (s *ChannelMessageService) CanDeleteChannelMessage(messageId, userId string) (bool, error) {
+	var authorId, channelId string
+	err := s.DB.QueryRow(
+		"SELECT user_id, channel_id FROM channel_messages WHERE id = $1",
+		messageId,
+	).Scan(&authorId, &channelId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if authorId == userId {
+		return true, nil
+	}
+	return s.permissions.HasChannelPermission(channelId, userId, models.PermissionManageMessages)
+}
+
 // EditChannelMessage edits a channel message
 func (s *ChannelMessageService) EditChannelMessage(messageId, content string) error {
-	_, err := s.DB.Exec(`
+	result, err := s.DB.Exec(`
 		UPDATE channel_messages 
 		SET content = $1, is_edited = true, edited_at = $2
 		WHERE id = $3
 	`, content, time.Now(), messageId)
-
-	return err
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrMessageNotFound
+	}
+	return nil
 }
 
 // DeleteChannelMessage marks a channel message as deleted
@@ -126,39 +154,10 @@ func (s *ChannelMessageService) DeleteChannelMessage(messageId string) error {
 
 // SaveChannelAttachment saves a file attachment for a channel message
 func (s *ChannelMessageService) SaveChannelAttachment(file *multipart.FileHeader, messageId string) (string, error) {
-	// Create uploads directory if it doesn't exist
-	uploadsDir := "./uploads/channel_attachments"
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create uploads directory: %v", err)
-	}
-
-	// Generate a unique ID for the attachment
-	attachmentId := uuid.New().String()
-
-	// Get the file extension
-	fileExt := filepath.Ext(file.Filename)
-
-	// Create a unique filename
-	filename := fmt.Sprintf("%s%s", attachmentId, fileExt)
-	filePath := filepath.Join(uploadsDir, filename)
-
-	// Open the source file
-	src, err := file.Open()
-	if err != nil {
-		return "", fmt.Errorf("failed to open uploaded file: %v", err)
-	}
-	defer src.Close()
-
-	// Create the destination file
-	dst, err := os.Create(filePath)
+	// Store the file on disk
+	attachmentId, filePath, err := saveUpload(file, "./uploads/channel_attachments")
 	if err != nil {
-		return "", fmt.Errorf("failed to create destination file: %v", err)
-	}
-	defer dst.Close()
-
-	// Copy the file content
-	if _, err = io.Copy(dst, src); err != nil {
-		return "", fmt.Errorf("failed to copy file content: %v", err)
+		return "", err
 	}
 
 	// Save attachment info to database
@@ -240,6 +239,9 @@ func (s *ChannelMessageService) GetMessageByID(messageID string) (*models.Channe
 		&message.IsDeleted,
 	)
 
+	if err == sql.ErrNoRows {
+		return nil, ErrMessageNotFound
+	}
 	if err != nil {
 		return nil, fmt.Errorf("メッセージの取得に失敗しました: %w", err)
 	}
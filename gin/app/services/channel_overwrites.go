@@ -0,0 +1,150 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrInvalidOverwrite is returned for overwrites with unknown targets or permissions
+var ErrInvalidOverwrite = errors.New("overwrites may only allow or deny view, send, attach and manage messages, and not both at once")
+
+// ListOverwrites returns the permission overwrites of a channel
+func (s *RoleService) ListOverwrites(channelID string) ([]models.ChannelOverwrite, error) {
+	rows, err := s.db.Query(`
+		SELECT channel_id, target_type, target_id, allow, deny, updated_at
+		FROM channel_overwrites
+		WHERE channel_id = $1
+		ORDER BY target_type DESC, target_id
+	`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overwrites := []models.ChannelOverwrite{}
+	for rows.Next() {
+		var overwrite models.ChannelOverwrite
+		var allow, deny int64
+		if err := rows.Scan(&overwrite.ChannelId, &overwrite.TargetType, &overwrite.TargetId, &allow, &deny, &overwrite.UpdatedAt); err != nil {
+			return nil, err
+		}
+		overwrite.Allow = models.Permission(allow)
+		overwrite.Deny = models.Permission(deny)
+		overwrites = append(overwrites, overwrite)
+	}
+	return overwrites, rows.Err()
+}
+
+// SetOverwrite creates or replaces the overwrite for a role or member in a channel.
+// The actor must be above the target and may only allow or deny permissions they hold
+// in that channel.
+func (s *RoleService) SetOverwrite(channelID, actorID, targetType, targetID string, req models.OverwriteRequest) (*models.ChannelOverwrite, error) {
+	if req.Allow&^models.OverwritablePermissions != 0 || req.Deny&^models.OverwritablePermissions != 0 || req.Allow&req.Deny != 0 {
+		return nil, ErrInvalidOverwrite
+	}
+
+	if err := s.checkOverwriteTarget(channelID, actorID, targetType, targetID); err != nil {
+		return nil, err
+	}
+	if err := s.checkChannelGrant(channelID, actorID, req.Allow|req.Deny); err != nil {
+		return nil, err
+	}
+
+	overwrite := models.ChannelOverwrite{
+		ChannelId:  channelID,
+		TargetType: targetType,
+		TargetId:   targetID,
+		Allow:      req.Allow,
+		Deny:       req.Deny,
+		UpdatedAt:  time.Now(),
+	}
+	_, err := s.db.Exec(`
+		INSERT INTO channel_overwrites (channel_id, target_type, target_id, allow, deny, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (channel_id, target_type, target_id) DO UPDATE SET allow = EXCLUDED.allow, deny = EXCLUDED.deny, updated_at = EXCLUDED.updated_at
+	`, channelID, targetType, targetID, int64(req.Allow), int64(req.Deny), overwrite.UpdatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("error saving overwrite: %w", err)
+	}
+	return &overwrite, nil
+}
+
+// DeleteOverwrite removes the overwrite for a role or member in a channel
+func (s *RoleService) DeleteOverwrite(channelID, actorID, targetType, targetID string) error {
+	if err := s.checkOverwriteTarget(channelID, actorID, targetType, targetID); err != nil {
+		return err
+	}
+
+	_, err := s.db.Exec(
+		"DELETE FROM channel_overwrites WHERE channel_id = $1 AND target_type = $2 AND target_id = $3",
+		channelID, targetType, targetID,
+	)
+	return err
+}
+
+// checkOverwriteTarget checks that the target exists in the channel's server and is below
+// the actor. Members cannot set overwrites for themselves, since nobody outranks themselves.
+func (s *RoleService) checkOverwriteTarget(channelID, actorID, targetType, targetID string) error {
+	if _, err := uuid.Parse(targetID); err != nil {
+		return ErrInvalidOverwrite
+	}
+
+	var serverID string
+	err := s.db.QueryRow("SELECT server_id FROM channels WHERE id = $1", channelID).Scan(&serverID)
+	if err == sql.ErrNoRows {
+		return ErrChannelNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	switch targetType {
+	case models.OverwriteTargetRole:
+		role, err := s.GetRole(serverID, targetID)
+		if err != nil {
+			return err
+		}
+		return s.checkRoleBelowActor(serverID, actorID, role)
+	case models.OverwriteTargetMember:
+		var isMember bool
+		err := s.db.QueryRow(
+			"SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)",
+			serverID, targetID,
+		).Scan(&isMember)
+		if err != nil {
+			return err
+		}
+		if !isMember {
+			return ErrNotServerMember
+		}
+		outranks, err := s.permissions.Outranks(serverID, actorID, targetID)
+		if err != nil {
+			return err
+		}
+		if !outranks {
+			return ErrRoleHierarchy
+		}
+		return nil
+	default:
+		return ErrInvalidOverwrite
+	}
+}
+
+// checkChannelGrant returns ErrPermissionEscalation unless the actor holds every permission
+// in perms in the channel itself, after its overwrites
+func (s *RoleService) checkChannelGrant(channelID, actorID string, perms models.Permission) error {
+	actorPermissions, err := s.permissions.ChannelPermissions(channelID, actorID)
+	if err != nil {
+		return err
+	}
+	if !actorPermissions.Has(perms) {
+		return ErrPermissionEscalation
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"app/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSetMemberOverwrite(t *testing.T) {
+	const (
+		targetID = "5b0f0a51-5d5c-4bd4-8a0e-6f1c3e2b9d11"
+		selfID   = "9e4c2d7a-1f3b-4c8e-b6a5-0d2f8e7c1a33"
+	)
+	q := regexp.QuoteMeta
+
+	tests := []struct {
+		name     string
+		actorID  string
+		targetID string
+		// positions are the highest role positions of the actor and the target
+		positions [2]int
+		// channel is the actor's view of the channel; nil when the request fails before
+		// the actor's channel permissions are resolved
+		channel *channelRow
+		req     models.OverwriteRequest
+		wantErr error
+	}{
+		{
+			name:      "member below the actor gets the overwrite",
+			actorID:   testUserID,
+			targetID:  targetID,
+			positions: [2]int{3, 1},
+			channel:   &channelRow{},
+			req:       models.OverwriteRequest{Allow: models.PermissionAttachFiles, Deny: models.PermissionSendMessages},
+		},
+		{
+			name:      "members cannot set their own overwrite",
+			actorID:   selfID,
+			targetID:  selfID,
+			positions: [2]int{3, 3},
+			req:       models.OverwriteRequest{Allow: models.PermissionAttachFiles},
+			wantErr:   ErrRoleHierarchy,
+		},
+		{
+			name:      "member above the actor is refused",
+			actorID:   testUserID,
+			targetID:  targetID,
+			positions: [2]int{1, 3},
+			req:       models.OverwriteRequest{Deny: models.PermissionSendMessages},
+			wantErr:   ErrRoleHierarchy,
+		},
+		{
+			name:      "permission denied to the actor in this channel cannot be allowed",
+			actorID:   testUserID,
+			targetID:  targetID,
+			positions: [2]int{3, 1},
+			channel:   &channelRow{everyoneDeny: models.PermissionAttachFiles},
+			req:       models.OverwriteRequest{Allow: models.PermissionAttachFiles},
+			wantErr:   ErrPermissionEscalation,
+		},
+		{
+			name:      "permission the actor lacks cannot be denied either",
+			actorID:   testUserID,
+			targetID:  targetID,
+			positions: [2]int{3, 1},
+			channel:   &channelRow{},
+			req:       models.OverwriteRequest{Deny: models.PermissionManageMessages},
+			wantErr:   ErrPermissionEscalation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			channelServer := func() {
+				mock.ExpectQuery(q("SELECT server_id FROM channels WHERE id = $1")).
+					WithArgs(testChannelID).
+					WillReturnRows(sqlmock.NewRows([]string{"server_id"}).AddRow(testServerID))
+			}
+			channelServer()
+			mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)")).
+				WithArgs(testServerID, tt.targetID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			for i, userID := range []string{tt.actorID, tt.targetID} {
+				mock.ExpectQuery(q("MAX(sr.position)")).
+					WithArgs(testServerID, userID).
+					WillReturnRows(sqlmock.NewRows([]string{"owner_id", "position"}).AddRow(testOwnerID, tt.positions[i]))
+			}
+			if tt.channel != nil {
+				channelServer()
+				expectServerPermissions(mock, tt.actorID, true, models.DefaultPermissions|models.PermissionManageRoles)
+				expectChannelRow(mock, *tt.channel)
+			}
+			if tt.wantErr == nil {
+				mock.ExpectExec(q("INSERT INTO channel_overwrites")).
+					WithArgs(testChannelID, models.OverwriteTargetMember, tt.targetID, int64(tt.req.Allow), int64(tt.req.Deny), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			_, err := NewRoleService(db).SetOverwrite(testChannelID, tt.actorID, models.OverwriteTargetMember, tt.targetID, tt.req)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"app/models"
+)
+
+// maxSlowmode is the longest slowmode interval a channel may have, in seconds
+const maxSlowmode = 21600
+
+// ClaimSlowmode records that the user is posting in the channel and returns the time it
+// recorded. When the channel's slowmode interval has not passed since the user's last message,
+// nothing is recorded and the remaining cooldown is returned instead. Members who may manage
+// messages in the channel are exempt, and get a zero time.
+//
+// The check, the update and the remaining cooldown are one statement, so concurrent requests
+// cannot both get through and a refused request always sees the claim that beat it. When the
+// existing claim is still running, the upsert writes the row back unchanged so RETURNING
+// reports it.
+func (s *ServerService) ClaimSlowmode(channelId, userId string) (time.Time, time.Duration, error) {
+	interval, exempt, err := s.slowmodeFor(channelId, userId)
+	if err != nil || exempt {
+		return time.Time{}, 0, err
+	}
+
+	// Stored with microsecond precision, so the claim can be matched exactly on release
+	now := time.Now().Truncate(time.Microsecond)
+	var claimed bool
+	var remaining float64
+	err = s.db.QueryRow(`
+		INSERT INTO channel_slowmode (channel_id, user_id, last_posted_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (channel_id, user_id) DO UPDATE SET last_posted_at = CASE
+			WHEN channel_slowmode.last_posted_at <= $4 THEN EXCLUDED.last_posted_at
+			ELSE channel_slowmode.last_posted_at
+		END
+		RETURNING last_posted_at = $3, GREATEST(EXTRACT(EPOCH FROM last_posted_at - $4::timestamp), 0)
+	`, channelId, userId, now, now.Add(-interval)).Scan(&claimed, &remaining)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("error claiming slowmode: %w", err)
+	}
+	if !claimed {
+		return time.Time{}, time.Duration(remaining * float64(time.Second)), nil
+	}
+	return now, 0, nil
+}
+
+// ReleaseSlowmode gives back a claim made by ClaimSlowmode when the message was not saved after
+// all. A claim only succeeds once the previous one has expired, so removing it leaves the user
+// free to post just as the previous claim would. Later claims are left alone.
+func (s *ServerService) ReleaseSlowmode(channelId, userId string, claimedAt time.Time) error {
+	if claimedAt.IsZero() {
+		return nil
+	}
+	_, err := s.db.Exec(
+		"DELETE FROM channel_slowmode WHERE channel_id = $1 AND user_id = $2 AND last_posted_at = $3",
+		channelId, userId, claimedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("error releasing slowmode: %w", err)
+	}
+	return nil
+}
+
+// SlowmodeCooldown returns how long the user must wait before posting in the channel again.
+// It is zero when the channel has no slowmode or the user is exempt.
+func (s *ServerService) SlowmodeCooldown(channelId, userId string) (time.Duration, error) {
+	interval, exempt, err := s.slowmodeFor(channelId, userId)
+	if err != nil || exempt {
+		return 0, err
+	}
+
+	// Compared in SQL, like the claim, so both sides of the subtraction are stored the same way
+	var remaining float64
+	err = s.db.QueryRow(`
+		SELECT GREATEST(EXTRACT(EPOCH FROM last_posted_at - $3::timestamp), 0)
+		FROM channel_slowmode
+		WHERE channel_id = $1 AND user_id = $2
+	`, channelId, userId, time.Now().Add(-interval)).Scan(&remaining)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(remaining * float64(time.Second)), nil
+}
+
+// slowmodeFor returns the channel's slowmode interval and whether the user is exempt from it
+func (s *ServerService) slowmodeFor(channelId, userId string) (time.Duration, bool, error) {
+	var seconds int
+	err := s.db.QueryRow("SELECT slowmode_seconds FROM channels WHERE id = $1", channelId).Scan(&seconds)
+	if err == sql.ErrNoRows {
+		return 0, false, ErrChannelNotFound
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	if seconds == 0 {
+		return 0, true, nil
+	}
+
+	exempt, err := s.permissions.HasChannelPermission(channelId, userId, models.PermissionManageMessages)
+	if err != nil {
+		return 0, false, err
+	}
+	return time.Duration(seconds) * time.Second, exempt, nil
+}
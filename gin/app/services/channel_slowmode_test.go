@@ -0,0 +1,150 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"app/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// timeArg matches any time argument and remembers it
+type timeArg struct {
+	value time.Time
+}
+
+func (a *timeArg) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	a.value = t
+	return ok
+}
+
+// upsertResult is the claim statement's answer: whether the claim was recorded and the
+// seconds left of the cooldown when it was not
+type upsertResult struct {
+	claimed   bool
+	remaining float64
+}
+
+func TestClaimSlowmode(t *testing.T) {
+	q := regexp.QuoteMeta
+
+	tests := []struct {
+		name string
+		// slowmode is the channel's interval in seconds, or -1 for a missing channel
+		slowmode int
+		// base is the user's server permissions, resolved when the channel has slowmode
+		base models.Permission
+		// claim is the upsert's answer, or nil when the statement is never run
+		claim       *upsertResult
+		wantClaimed bool
+		wantRetry   time.Duration
+		wantErr     error
+	}{
+		{
+			name:     "channel without slowmode is not claimed",
+			slowmode: 0,
+		},
+		{
+			name:     "members who manage messages are exempt",
+			slowmode: 30,
+			base:     models.DefaultPermissions | models.PermissionManageMessages,
+		},
+		{
+			name:        "first message claims the cooldown",
+			slowmode:    30,
+			base:        models.DefaultPermissions,
+			claim:       &upsertResult{true, 0},
+			wantClaimed: true,
+		},
+		{
+			name:      "message during the cooldown gets the time left from the same statement",
+			slowmode:  30,
+			base:      models.DefaultPermissions,
+			claim:     &upsertResult{false, 12.5},
+			wantRetry: 12500 * time.Millisecond,
+		},
+		{
+			name:     "missing channel is not found",
+			slowmode: -1,
+			wantErr:  ErrChannelNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+
+			slowmodeRows := sqlmock.NewRows([]string{"slowmode_seconds"})
+			if tt.slowmode >= 0 {
+				slowmodeRows.AddRow(tt.slowmode)
+			}
+			mock.ExpectQuery(q("SELECT slowmode_seconds FROM channels WHERE id = $1")).
+				WithArgs(testChannelID).
+				WillReturnRows(slowmodeRows)
+			if tt.slowmode > 0 {
+				mock.ExpectQuery(q("SELECT server_id FROM channels WHERE id = $1")).
+					WillReturnRows(sqlmock.NewRows([]string{"server_id"}).AddRow(testServerID))
+				expectServerPermissions(mock, testUserID, true, tt.base)
+				expectChannelRow(mock, channelRow{})
+			}
+			postedAt, cutoff := &timeArg{}, &timeArg{}
+			if tt.claim != nil {
+				mock.ExpectQuery(q("INSERT INTO channel_slowmode")).
+					WithArgs(testChannelID, testUserID, postedAt, cutoff).
+					WillReturnRows(sqlmock.NewRows([]string{"claimed", "remaining"}).AddRow(tt.claim.claimed, tt.claim.remaining))
+			}
+
+			claimedAt, retryAfter, err := NewServerService(db).ClaimSlowmode(testChannelID, testUserID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if retryAfter != tt.wantRetry {
+				t.Errorf("retry after = %v, want %v", retryAfter, tt.wantRetry)
+			}
+			if claimedAt.IsZero() == tt.wantClaimed {
+				t.Errorf("claimed at = %v, want a claim: %v", claimedAt, tt.wantClaimed)
+			}
+			if tt.wantClaimed {
+				if !claimedAt.Equal(postedAt.value) {
+					t.Errorf("claimed at = %v, but recorded %v", claimedAt, postedAt.value)
+				}
+				if got := postedAt.value.Sub(cutoff.value); got != time.Duration(tt.slowmode)*time.Second {
+					t.Errorf("cutoff is %v before the claim, want the slowmode interval", got)
+				}
+			}
+		})
+	}
+}
+
+func TestReleaseSlowmode(t *testing.T) {
+	claimedAt := time.Now().Truncate(time.Microsecond)
+
+	tests := []struct {
+		name      string
+		claimedAt time.Time
+		wantExec  bool
+	}{
+		{name: "claim is deleted only if it is still the latest", claimedAt: claimedAt, wantExec: true},
+		{name: "exempt post has nothing to release", claimedAt: time.Time{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.wantExec {
+				mock.ExpectExec(regexp.QuoteMeta("DELETE FROM channel_slowmode WHERE channel_id = $1 AND user_id = $2 AND last_posted_at = $3")).
+					WithArgs(testChannelID, testUserID, tt.claimedAt).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			if err := NewServerService(db).ReleaseSlowmode(testChannelID, testUserID, tt.claimedAt); err != nil {
+				t.Fatalf("ReleaseSlowmode: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	defaultDirectoryLimit = 20
+	maxDirectoryLimit     = 50
+)
+
+// Directory sort orders
+const (
+	DirectorySortMembers  = "members"
+	DirectorySortActivity = "activity"
+)
+
+// DiscoveryService lists servers that opted into the public directory and lets users join them
+type DiscoveryService struct {
+	db *sql.DB
+}
+
+// NewDiscoveryService creates a new DiscoveryService
+func NewDiscoveryService(db *sql.DB) *DiscoveryService {
+	return &DiscoveryService{
+		db: db,
+	}
+}
+
+// ListServers returns a page of discoverable servers matching the query, category and tag.
+// Servers the caller is banned from are left out.
+func (s *DiscoveryService) ListServers(callerID, query, category, tag, sort string, limit, offset int) (*models.DirectoryResponse, error) {
+	if limit <= 0 {
+		limit = defaultDirectoryLimit
+	}
+	limit = min(limit, maxDirectoryLimit)
+	offset = max(offset, 0)
+
+	orderBy := "member_count DESC, last_activity_at DESC NULLS LAST"
+	if sort == DirectorySortActivity {
+		orderBy = "last_activity_at DESC NULLS LAST, member_count DESC"
+	}
+
+	// Fetch one extra row to tell whether there is another page
+	rows, err := s.db.Query(`
+		SELECT * FROM (
+		  SELECT s.id, s.name, COALESCE(s.description, ''), COALESCE(s.icon_url, ''), s.discovery_category, s.tags,
+		         (SELECT COUNT(*) FROM server_members WHERE server_id = s.id) AS member_count,
+		         (SELECT MAX(m.timestamp) FROM channel_messages m JOIN channels c ON c.id = m.channel_id WHERE c.server_id = s.id) AS last_activity_at,
+		         EXISTS (SELECT 1 FROM server_members WHERE server_id = s.id AND user_id = $1)
+		  FROM servers s
+		  WHERE s.discoverable
+		    AND NOT EXISTS (SELECT 1 FROM server_bans WHERE server_id = s.id AND user_id = $1)
+		    AND ($2 = '' OR s.name ILIKE '%' || $2 || '%' ESCAPE '\' OR s.description ILIKE '%' || $2 || '%' ESCAPE '\' OR LOWER($3) = ANY(s.tags))
+		    AND ($4 = '' OR s.discovery_category = $4)
+		    AND ($5 = '' OR LOWER($5) = ANY(s.tags))
+		) directory
+		ORDER BY `+orderBy+`, name ASC
+		LIMIT $6 OFFSET $7
+	`, callerID, escapeLike(query), query, category, tag, limit+1, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error listing directory: %w", err)
+	}
+	defer rows.Close()
+
+	response := &models.DirectoryResponse{Servers: []models.DirectoryServer{}, Limit: limit, Offset: offset}
+	for rows.Next() {
+		var server models.DirectoryServer
+		var lastActivity sql.NullTime
+		if err := rows.Scan(
+			&server.ID, &server.Name, &server.Description, &server.IconURL, &server.Category,
+			pq.Array(&server.Tags), &server.MemberCount, &lastActivity, &server.IsMember,
+		); err != nil {
+			return nil, err
+		}
+		if lastActivity.Valid {
+			server.LastActivityAt = &lastActivity.Time
+		}
+		response.Servers = append(response.Servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Servers) > limit {
+		response.Servers = response.Servers[:limit]
+		response.HasMore = true
+	}
+	return response, nil
+}
+
+// JoinServer adds the user to a discoverable server they are not banned from
+func (s *DiscoveryService) JoinServer(serverID, userID string) error {
+	if _, err := uuid.Parse(serverID); err != nil {
+		return ErrServerNotFound
+	}
+
+	var discoverable bool
+	err := s.db.QueryRow("SELECT discoverable FROM servers WHERE id = $1", serverID).Scan(&discoverable)
+	if err == sql.ErrNoRows || (err == nil && !discoverable) {
+		return ErrServerNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	banned, err := isBanned(s.db, serverID, userID)
+	if err != nil {
+		return err
+	}
+	if banned {
+		return ErrBanned
+	}
+
+	now := time.Now()
+	result, err := s.db.Exec(
+		"INSERT INTO server_members (id, server_id, user_id, role, joined_at, updated_at) VALUES ($1, $2, $3, 'member', $4, $4) ON CONFLICT (server_id, user_id) DO NOTHING",
+		uuid.New().String(), serverID, userID, now,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding server member: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAlreadyMember
+	}
+	return nil
+}
@@ -0,0 +1,305 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// inviteCodeAlphabet leaves out characters that are easy to confuse when typed
+const inviteCodeAlphabet = "abcdefghjkmnpqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+const inviteCodeLength = 8
+
+var (
+	// ErrInviteNotFound is returned when an invite code does not exist
+	ErrInviteNotFound = errors.New("invite not found")
+
+	// ErrInviteExpired is returned when an invite has expired or has no uses left
+	ErrInviteExpired = errors.New("invite has expired")
+
+	// ErrInviteChannel is returned when an invite targets a channel the creator cannot use
+	ErrInviteChannel = errors.New("channel is not in this server or not visible to you")
+
+	// ErrAlreadyMember is returned when joining a server the user already belongs to
+	ErrAlreadyMember = errors.New("already a member of this server")
+)
+
+// InviteService manages invite codes for servers
+type InviteService struct {
+	db          *sql.DB
+	permissions *PermissionResolver
+}
+
+// NewInviteService creates a new InviteService
+func NewInviteService(db *sql.DB) *InviteService {
+	return &InviteService{
+		db:          db,
+		permissions: NewPermissionResolver(db),
+	}
+}
+
+// CreateInvite creates an invite for a server, optionally landing in a channel the creator can see
+func (s *InviteService) CreateInvite(serverID, creatorID string, req models.CreateInviteRequest) (*models.Invite, error) {
+	invite := models.Invite{
+		ServerId:  serverID,
+		ChannelId: req.ChannelId,
+		CreatedBy: creatorID,
+		MaxUses:   req.MaxUses,
+		CreatedAt: time.Now(),
+	}
+	if req.ExpiresIn > 0 {
+		expiresAt := invite.CreatedAt.Add(time.Duration(req.ExpiresIn) * time.Second)
+		invite.ExpiresAt = &expiresAt
+	}
+
+	var channelID interface{}
+	if req.ChannelId != "" {
+		var channelServerID string
+		err := s.db.QueryRow("SELECT server_id FROM channels WHERE id = $1", req.ChannelId).Scan(&channelServerID)
+		if err == sql.ErrNoRows || (err == nil && channelServerID != serverID) {
+			return nil, ErrInviteChannel
+		}
+		if err != nil {
+			return nil, err
+		}
+		canView, err := s.permissions.HasChannelPermission(req.ChannelId, creatorID, models.PermissionViewChannels)
+		if err != nil {
+			return nil, err
+		}
+		if !canView {
+			return nil, ErrInviteChannel
+		}
+		channelID = req.ChannelId
+	}
+
+	// Retry on the unlikely event of a code collision
+	for attempt := 0; attempt < 5; attempt++ {
+		code, err := generateInviteCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = s.db.Exec(
+			"INSERT INTO server_invites (code, server_id, channel_id, created_by, max_uses, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+			code, serverID, channelID, creatorID, invite.MaxUses, invite.ExpiresAt, invite.CreatedAt,
+		)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error creating invite: %w", err)
+		}
+		invite.Code = code
+		return &invite, nil
+	}
+	return nil, errors.New("could not generate a unique invite code")
+}
+
+// ListInvites returns every invite of a server, newest first
+func (s *InviteService) ListInvites(serverID string) ([]models.Invite, error) {
+	rows, err := s.db.Query(`
+		SELECT code, server_id, COALESCE(channel_id::text, ''), created_by, max_uses, uses, expires_at, created_at
+		FROM server_invites
+		WHERE server_id = $1
+		ORDER BY created_at DESC
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []models.Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, *invite)
+	}
+	return invites, rows.Err()
+}
+
+// GetInvite looks up an invite by code
+func (s *InviteService) GetInvite(code string) (*models.Invite, error) {
+	row := s.db.QueryRow(`
+		SELECT code, server_id, COALESCE(channel_id::text, ''), created_by, max_uses, uses, expires_at, created_at
+		FROM server_invites
+		WHERE code = $1
+	`, code)
+	invite, err := scanInvite(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrInviteNotFound
+	}
+	return invite, err
+}
+
+// DeleteInvite revokes an invite
+func (s *InviteService) DeleteInvite(code string) error {
+	result, err := s.db.Exec("DELETE FROM server_invites WHERE code = $1", code)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrInviteNotFound
+	}
+	return nil
+}
+
+// PreviewInvite returns the server an invite leads to without joining it
+func (s *InviteService) PreviewInvite(code string) (*models.InvitePreview, error) {
+	invite, err := s.GetInvite(code)
+	if err != nil {
+		return nil, err
+	}
+	if !inviteUsable(invite) {
+		return nil, ErrInviteExpired
+	}
+
+	preview := models.InvitePreview{
+		Code:      invite.Code,
+		ServerId:  invite.ServerId,
+		ChannelId: invite.ChannelId,
+		ExpiresAt: invite.ExpiresAt,
+	}
+	err = s.db.QueryRow(`
+		SELECT s.name, COALESCE(s.description, ''), (SELECT COUNT(*) FROM server_members WHERE server_id = s.id)
+		FROM servers s
+		WHERE s.id = $1
+	`, invite.ServerId).Scan(&preview.ServerName, &preview.ServerDescription, &preview.MemberCount)
+	if err != nil {
+		return nil, err
+	}
+	if invite.ChannelId != "" {
+		if err := s.db.QueryRow("SELECT name FROM channels WHERE id = $1", invite.ChannelId).Scan(&preview.ChannelName); err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+	}
+
+	return &preview, nil
+}
+
+// AcceptInvite uses one of the invite's uses and adds the user to the server.
+// A private target channel is opened to the new member.
+func (s *InviteService) AcceptInvite(code, userID string) (*models.Invite, error) {
+	invite, err := s.GetInvite(code)
+	if err != nil {
+		return nil, err
+	}
+
+	var isMember bool
+	err = s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)",
+		invite.ServerId, userID,
+	).Scan(&isMember)
+	if err != nil {
+		return nil, err
+	}
+	if isMember {
+		return nil, ErrAlreadyMember
+	}
+
+	banned, err := isBanned(s.db, invite.ServerId, userID)
+	if err != nil {
+		return nil, err
+	}
+	if banned {
+		return nil, ErrBanned
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Count the use in the same statement that checks the limits so concurrent accepts can't overshoot
+	err = tx.QueryRow(`
+		UPDATE server_invites SET uses = uses + 1
+		WHERE code = $1
+		  AND (max_uses = 0 OR uses < max_uses)
+		  AND (expires_at IS NULL OR expires_at > NOW())
+		RETURNING uses
+	`, code).Scan(&invite.Uses)
+	if err == sql.ErrNoRows {
+		return nil, ErrInviteExpired
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(
+		"INSERT INTO server_members (id, server_id, user_id, role, joined_at, updated_at) VALUES ($1, $2, $3, 'member', $4, $4) ON CONFLICT (server_id, user_id) DO NOTHING",
+		uuid.New().String(), invite.ServerId, userID, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error adding server member: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrAlreadyMember
+	}
+
+	if invite.ChannelId != "" {
+		_, err = tx.Exec(`
+			INSERT INTO channel_members (id, channel_id, user_id, added_at)
+			SELECT $1, id, $3, $4 FROM channels WHERE id = $2 AND is_private
+			ON CONFLICT (channel_id, user_id) DO NOTHING
+		`, uuid.New().String(), invite.ChannelId, userID, now)
+		if err != nil {
+			return nil, fmt.Errorf("error adding channel member: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return invite, nil
+}
+
+// inviteUsable reports whether an invite has neither expired nor run out of uses
+func inviteUsable(invite *models.Invite) bool {
+	if invite.ExpiresAt != nil && !invite.ExpiresAt.After(time.Now()) {
+		return false
+	}
+	return invite.MaxUses == 0 || invite.Uses < invite.MaxUses
+}
+
+// scanInvite reads an invite row from a query
+func scanInvite(row interface{ Scan(...interface{}) error }) (*models.Invite, error) {
+	var invite models.Invite
+	var expiresAt sql.NullTime
+	err := row.Scan(
+		&invite.Code, &invite.ServerId, &invite.ChannelId, &invite.CreatedBy,
+		&invite.MaxUses, &invite.Uses, &expiresAt, &invite.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		invite.ExpiresAt = &expiresAt.Time
+	}
+	return &invite, nil
+}
+
+// generateInviteCode returns a random code from inviteCodeAlphabet
+func generateInviteCode() (string, error) {
+	code := make([]byte, inviteCodeLength)
+	alphabetSize := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
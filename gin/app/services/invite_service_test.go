@@ -0,0 +1,142 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAcceptInvite(t *testing.T) {
+	const code = "Ab3dEf7h"
+	q := regexp.QuoteMeta
+	inviteColumns := []string{"code", "server_id", "channel_id", "created_by", "max_uses", "uses", "expires_at", "created_at"}
+	exists := func(value bool) *sqlmock.Rows { return sqlmock.NewRows([]string{"exists"}).AddRow(value) }
+
+	tests := []struct {
+		name      string
+		channelID string
+		// expect sets the statements after the invite has been read
+		expect      func(mock sqlmock.Sqlmock)
+		wantUses    int
+		wantErr     error
+		wantErrText string
+	}{
+		{
+			name:      "use is counted and the member added in one transaction",
+			channelID: testChannelID,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members")).WillReturnRows(exists(false))
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_bans")).WillReturnRows(exists(false))
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE server_invites SET uses = uses + 1")).
+					WithArgs(code).
+					WillReturnRows(sqlmock.NewRows([]string{"uses"}).AddRow(3))
+				mock.ExpectExec(q("INSERT INTO server_members")).
+					WithArgs(sqlmock.AnyArg(), testServerID, testUserID, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(q("INSERT INTO channel_members")).
+					WithArgs(sqlmock.AnyArg(), testChannelID, testUserID, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantUses: 3,
+		},
+		{
+			name: "invite used up by a concurrent accept adds nobody",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members")).WillReturnRows(exists(false))
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_bans")).WillReturnRows(exists(false))
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE server_invites SET uses = uses + 1")).
+					WillReturnRows(sqlmock.NewRows([]string{"uses"}))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrInviteExpired,
+		},
+		{
+			name: "concurrent join of the same user gives the use back",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members")).WillReturnRows(exists(false))
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_bans")).WillReturnRows(exists(false))
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE server_invites SET uses = uses + 1")).
+					WillReturnRows(sqlmock.NewRows([]string{"uses"}).AddRow(1))
+				mock.ExpectExec(q("INSERT INTO server_members")).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrAlreadyMember,
+		},
+		{
+			name: "failed member insert gives the use back",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members")).WillReturnRows(exists(false))
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_bans")).WillReturnRows(exists(false))
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE server_invites SET uses = uses + 1")).
+					WillReturnRows(sqlmock.NewRows([]string{"uses"}).AddRow(1))
+				mock.ExpectExec(q("INSERT INTO server_members")).WillReturnError(errors.New("connection reset"))
+				mock.ExpectRollback()
+			},
+			wantErrText: "error adding server member",
+		},
+		{
+			name: "existing member does not use the invite",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members")).WillReturnRows(exists(true))
+			},
+			wantErr: ErrAlreadyMember,
+		},
+		{
+			name: "banned user does not use the invite",
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members")).WillReturnRows(exists(false))
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_bans")).WillReturnRows(exists(true))
+			},
+			wantErr: ErrBanned,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(q("FROM server_invites")).
+				WithArgs(code).
+				WillReturnRows(sqlmock.NewRows(inviteColumns).
+					AddRow(code, testServerID, tt.channelID, testOwnerID, 3, 2, nil, time.Now()))
+			tt.expect(mock)
+
+			invite, err := NewInviteService(db).AcceptInvite(code, testUserID)
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantErrText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Fatalf("error = %v, want it to mention %q", err, tt.wantErrText)
+				}
+				return
+			case err != nil:
+				t.Fatalf("AcceptInvite: %v", err)
+			}
+			if invite.Uses != tt.wantUses {
+				t.Errorf("uses = %d, want %d", invite.Uses, tt.wantUses)
+			}
+		})
+	}
+}
+
+func TestAcceptUnknownInvite(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(regexp.QuoteMeta("FROM server_invites")).
+		WillReturnRows(sqlmock.NewRows([]string{"code", "server_id", "channel_id", "created_by", "max_uses", "uses", "expires_at", "created_at"}))
+
+	if _, err := NewInviteService(db).AcceptInvite("missing", testUserID); !errors.Is(err, ErrInviteNotFound) {
+		t.Fatalf("error = %v, want %v", err, ErrInviteNotFound)
+	}
+}
@@ -0,0 +1,203 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is a private key and the algorithm it signs with
+type signingKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+// KeySet holds the keys used to sign and verify tokens.
+//
+// With asymmetric keys, every key in the set is accepted for verification and
+// published in the JWKS, but only the active key signs new tokens. To rotate,
+// add the new key, switch the active key ID once the JWKS has been picked up,
+// and remove the old key after the longest-lived token signed with it expires.
+type KeySet struct {
+	active *signingKey
+	keys   map[string]*signingKey
+
+	// hmacSecret is used instead of asymmetric keys when none are configured
+	hmacSecret []byte
+}
+
+// LoadKeySetFromEnv loads PEM encoded private keys from JWT_KEYS_DIR, where each file is
+// named <kid>.pem, and signs with JWT_ACTIVE_KEY_ID. Without JWT_KEYS_DIR it falls back to
+// HS256 with JWT_SECRET, in which case no keys are published.
+func LoadKeySetFromEnv() (*KeySet, error) {
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		secret := os.Getenv("JWT_SECRET")
+		if secret == "" {
+			return nil, errors.New("neither JWT_KEYS_DIR nor JWT_SECRET is set")
+		}
+		return &KeySet{hmacSecret: []byte(secret)}, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	keySet := &KeySet{keys: make(map[string]*signingKey)}
+	for _, file := range files {
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		key, err := loadSigningKey(kid, file)
+		if err != nil {
+			return nil, err
+		}
+		keySet.keys[kid] = key
+	}
+	if len(keySet.keys) == 0 {
+		return nil, fmt.Errorf("no signing keys found in %s", dir)
+	}
+
+	activeID := os.Getenv("JWT_ACTIVE_KEY_ID")
+	if activeID == "" {
+		if len(keySet.keys) > 1 {
+			return nil, errors.New("JWT_ACTIVE_KEY_ID is required when several keys are configured")
+		}
+		for kid := range keySet.keys {
+			activeID = kid
+		}
+	}
+
+	active, ok := keySet.keys[activeID]
+	if !ok {
+		return nil, fmt.Errorf("active key %q not found in %s", activeID, dir)
+	}
+	keySet.active = active
+
+	return keySet, nil
+}
+
+// Sign signs the claims with the active key
+func (k *KeySet) Sign(claims jwt.MapClaims) (string, error) {
+	if k.active == nil {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(k.hmacSecret)
+	}
+
+	token := jwt.NewWithClaims(k.active.method, claims)
+	token.Header["kid"] = k.active.id
+	return token.SignedString(k.active.private)
+}
+
+// Parse verifies a token's signature with the key named by its kid header.
+// Extra options add claim checks such as the expected audience.
+func (k *KeySet) Parse(tokenString string, options ...jwt.ParserOption) (*jwt.Token, error) {
+	if k.active == nil {
+		return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+			return k.hmacSecret, nil
+		}, append(options, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))...)
+	}
+
+	return jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := k.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		// The algorithm is bound to the key, not chosen by the token
+		if token.Method.Alg() != key.method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return key.private.Public(), nil
+	}, append(options, jwt.WithValidMethods(k.algorithms()))...)
+}
+
+// JWKS returns the public keys for the /.well-known/jwks.json endpoint
+func (k *KeySet) JWKS() (JWKSet, error) {
+	set := JWKSet{Keys: []JWK{}}
+
+	ids := make([]string, 0, len(k.keys))
+	for kid := range k.keys {
+		ids = append(ids, kid)
+	}
+	sort.Strings(ids)
+
+	for _, kid := range ids {
+		key := k.keys[kid]
+		jwk, err := NewJWK(kid, key.method.Alg(), key.private.Public())
+		if err != nil {
+			return JWKSet{}, err
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set, nil
+}
+
+// algorithms lists the algorithms of the configured keys
+func (k *KeySet) algorithms() []string {
+	seen := make(map[string]bool)
+	var algs []string
+	for _, key := range k.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+	return algs
+}
+
+// loadSigningKey reads an RSA, ECDSA (P-256) or Ed25519 private key from a PEM file
+func loadSigningKey(kid, path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key %s: %w", path, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %s", path)
+	}
+
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key %s must be at least 2048 bits", path)
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodRS256, private: key}, nil
+	case *ecdsa.PrivateKey:
+		if key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA key %s must use P-256", path)
+		}
+		return &signingKey{id: kid, method: jwt.SigningMethodES256, private: key}, nil
+	case ed25519.PrivateKey:
+		return &signingKey{id: kid, method: jwt.SigningMethodEdDSA, private: key}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %T in %s", parsed, path)
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// lockoutThreshold is the number of consecutive failures before an account is locked
+	lockoutThreshold = 5
+
+	// baseLockout is the first lockout; every further failure doubles it up to maxLockout
+	baseLockout = time.Minute
+	maxLockout  = time.Hour
+
+	// defaultAuthEventLimit and maxAuthEventLimit bound a page of authentication events
+	defaultAuthEventLimit = 50
+	maxAuthEventLimit     = 100
+)
+
+// Authentication event types recorded in auth_events
+const (
+	AuthEventLoginFailed     = "login_failed"
+	AuthEventAccountLocked   = "account_locked"
+	AuthEventAccountUnlocked = "account_unlocked"
+)
+
+// ErrAccountNotLocked is returned when unlocking an account that is not locked
+var ErrAccountNotLocked = errors.New("account is not locked")
+
+// AccountLockedError is returned while an account is locked after too many failed sign-ins
+type AccountLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AccountLockedError) Error() string {
+	return "too many failed sign-in attempts, try again later"
+}
+
+// checkLockout returns an AccountLockedError if the account is currently locked
+func (s *UserService) checkLockout(userID string) error {
+	var lockedUntil sql.NullTime
+	if err := s.db.QueryRow("SELECT locked_until FROM users WHERE id = $1", userID).Scan(&lockedUntil); err != nil {
+		return fmt.Errorf("error checking lockout: %w", err)
+	}
+	if lockedUntil.Valid && lockedUntil.Time.After(time.Now()) {
+		return &AccountLockedError{RetryAfter: time.Until(lockedUntil.Time)}
+	}
+	return nil
+}
+
+// recordLoginFailure counts a failed sign-in and locks the account once the threshold is
+// reached. The counter is only reset by a successful sign-in, so every failure after a
+// lockout expires locks the account again for twice as long.
+func (s *UserService) recordLoginFailure(userID, email string, client models.ClientInfo, reason string) {
+	s.RecordAuthEvent(userID, email, AuthEventLoginFailed, client, reason)
+
+	var failures int
+	err := s.db.QueryRow(
+		"UPDATE users SET failed_login_count = failed_login_count + 1 WHERE id = $1 RETURNING failed_login_count",
+		userID,
+	).Scan(&failures)
+	if err != nil {
+		log.Printf("error recording failed login for user %s: %v", userID, err)
+		return
+	}
+	if failures < lockoutThreshold {
+		return
+	}
+
+	lockout := maxLockout
+	if shift := failures - lockoutThreshold; shift < 6 {
+		lockout = min(baseLockout<<shift, maxLockout)
+	}
+	if _, err := s.db.Exec("UPDATE users SET locked_until = $1 WHERE id = $2", time.Now().Add(lockout), userID); err != nil {
+		log.Printf("error locking user %s: %v", userID, err)
+		return
+	}
+
+	s.RecordAuthEvent(userID, email, AuthEventAccountLocked, client,
+		fmt.Sprintf("%d consecutive failures, locked for %s", failures, lockout))
+}
+
+// resetLoginFailures clears the failure counter after a successful sign-in
+func (s *UserService) resetLoginFailures(userID string) {
+	if _, err := s.db.Exec(
+		"UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1 AND failed_login_count > 0",
+		userID,
+	); err != nil {
+		log.Printf("error resetting failed logins for user %s: %v", userID, err)
+	}
+}
+
+// RecordAuthEvent stores an authentication event. userID may be empty when the
+// account is unknown. Failures are logged rather than returned so that recording
+// never blocks authentication.
+func (s *UserService) RecordAuthEvent(userID, email, eventType string, client models.ClientInfo, details string) {
+	var user interface{}
+	if userID != "" {
+		user = userID
+	}
+
+	_, err := s.db.Exec(
+		"INSERT INTO auth_events (id, user_id, email, event_type, ip_address, user_agent, details, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		uuid.New().String(), user, email, eventType, client.IPAddress, client.UserAgent, details, time.Now(),
+	)
+	if err != nil {
+		log.Printf("error recording auth event %s: %v", eventType, err)
+	}
+}
+
+// IsAdmin reports whether the user is a site administrator
+func (s *UserService) IsAdmin(userID string) (bool, error) {
+	var admin bool
+	err := s.db.QueryRow("SELECT is_admin FROM users WHERE id = $1", userID).Scan(&admin)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return admin, err
+}
+
+// ListLockedAccounts returns the accounts that are locked right now, longest lockout first
+func (s *UserService) ListLockedAccounts() ([]models.LockedAccount, error) {
+	rows, err := s.db.Query(`
+		SELECT id, username, email, failed_login_count, locked_until
+		FROM users
+		WHERE locked_until > $1
+		ORDER BY locked_until DESC
+	`, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error listing locked accounts: %w", err)
+	}
+	defer rows.Close()
+
+	accounts := []models.LockedAccount{}
+	for rows.Next() {
+		var account models.LockedAccount
+		if err := rows.Scan(&account.UserId, &account.Username, &account.Email, &account.FailedLoginCount, &account.LockedUntil); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+// ListAuthEvents returns a page of authentication events, newest first
+func (s *UserService) ListAuthEvents(filter models.AuthEventFilter) (*models.AuthEventListResponse, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = defaultAuthEventLimit
+	}
+	limit = min(limit, maxAuthEventLimit)
+	offset := max(filter.Offset, 0)
+
+	response := &models.AuthEventListResponse{Events: []models.AuthEvent{}, Limit: limit, Offset: offset}
+
+	// Fetch one extra row to tell whether there is another page
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(user_id::text, ''), COALESCE(email, ''), event_type,
+		       COALESCE(ip_address, ''), COALESCE(user_agent, ''), COALESCE(details, ''), created_at
+		FROM auth_events
+		WHERE ($1 = '' OR user_id::text = $1)
+		  AND ($2 = '' OR email = $2)
+		  AND ($3 = '' OR event_type = $3)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4 OFFSET $5
+	`, filter.UserId, filter.Email, filter.EventType, limit+1, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth events: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var event models.AuthEvent
+		if err := rows.Scan(
+			&event.ID, &event.UserId, &event.Email, &event.EventType,
+			&event.IPAddress, &event.UserAgent, &event.Details, &event.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		response.Events = append(response.Events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Events) > limit {
+		response.Events = response.Events[:limit]
+		response.HasMore = true
+	}
+	return response, nil
+}
+
+// UnlockAccount lifts a lockout early and clears the failure counter. The unlock is
+// recorded with the administrator who performed it.
+func (s *UserService) UnlockAccount(userID, adminID string, client models.ClientInfo) error {
+	var email string
+	err := s.db.QueryRow(
+		"UPDATE users SET failed_login_count = 0, locked_until = NULL WHERE id = $1 AND locked_until > $2 RETURNING email",
+		userID, time.Now(),
+	).Scan(&email)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrAccountNotLocked
+		}
+		return fmt.Errorf("error unlocking account: %w", err)
+	}
+
+	s.RecordAuthEvent(userID, email, AuthEventAccountUnlocked, client, "unlocked by administrator "+adminID)
+	return nil
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer sends transactional emails such as password reset links
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTPMailer delivers emails through an SMTP server
+type SMTPMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTPMailer creates a new SMTP mailer. Authentication is skipped when username is empty.
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+	return &SMTPMailer{
+		addr: host + ":" + port,
+		auth: auth,
+		from: from,
+	}
+}
+
+// Send sends a plain text email
+func (m *SMTPMailer) Send(to, subject, body string) error {
+	msg, err := buildMessage(m.from, to, subject, body)
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{to}, msg); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
+
+// LogMailer writes emails to a file, or to the log when no path is set.
+// It is meant for local development and testing.
+type LogMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewLogMailer creates a new log mailer
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path}
+}
+
+// Send records the email instead of delivering it
+func (m *LogMailer) Send(to, subject, body string) error {
+	msg, err := buildMessage("noreply@localhost", to, subject, body)
+	if err != nil {
+		return err
+	}
+
+	if m.path == "" {
+		log.Printf("メール送信（ログ出力）:\n%s", msg)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open mail log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "----- %s -----\n%s\n", time.Now().Format(time.RFC3339), msg)
+	return err
+}
+
+// NewMailerFromEnv returns an SMTP mailer when SMTP_HOST is set, otherwise a log mailer
+// writing to MAIL_LOG_PATH
+func NewMailerFromEnv() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return NewLogMailer(os.Getenv("MAIL_LOG_PATH"))
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	return NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("MAIL_FROM"))
+}
+
+// appURL builds a link to the frontend from APP_BASE_URL
+func appURL(path string) string {
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:5173"
+	}
+	return strings.TrimRight(baseURL, "/") + path
+}
+
+// buildMessage formats an RFC 5322 message with a UTF-8 plain text body
+func buildMessage(from, to, subject, body string) ([]byte, error) {
+	// Reject header injection
+	if strings.ContainsAny(from+to+subject, "\r\n") {
+		return nil, errors.New("invalid email header")
+	}
+
+	var b strings.Builder
+	b.WriteString("From: " + from + "\r\n")
+	b.WriteString("To: " + to + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return []byte(b.String()), nil
+}
@@ -77,47 +77,7 @@ func (s *MessageService) IsMessageAuthor(messageId, userId string) (bool, error)
 
 // CanDeleteMessage checks if a user can delete a message
 func (s *MessageService) CanDeleteMessage(messageId, userId string) (bool, error) {
-	// Check if user is the author
-	isAuthor, err := s.IsMessageAuthor(messageId, userId)
-	if err != nil {
-		return false, err
-	}
-	if isAuthor {
-		return true, nil
-	}
-
-	// If not the author, check if user is an admin or owner of the server
-	var channelId string
-	err = s.DB.QueryRow(
-		"SELECT channel_id FROM channel_messages WHERE id = $1",
-		messageId,
-	).Scan(&channelId)
-	if err != nil {
-		return false, err
-	}
-
-	var serverId string
-	err = s.DB.QueryRow(
-		"SELECT server_id FROM channels WHERE id = $1",
-		channelId,
-	).Scan(&serverId)
-	if err != nil {
-		return false, err
-	}
-
-	var role string
-	err = s.DB.QueryRow(
-		"SELECT role FROM server_members WHERE server_id = $1 AND user_id = $2",
-		serverId, userId,
-	).Scan(&role)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
-		}
-		return false, err
-	}
-
-	return role == "owner" || role == "admin", nil
+	return s.channelMessageService.CanDeleteChannelMessage(messageId, userId)
 }
 
 // EditMessage edits a message
@@ -125,6 +85,11 @@ func (s *MessageService) EditMessage(messageId, content string) error {
 	return s.channelMessageService.EditChannelMessage(messageId, content)
 }
 
+// GetChannelMessage gets the channel message that backs a legacy message
+func (s *MessageService) GetChannelMessage(messageId string) (*models.ChannelMessage, error) {
+	return s.channelMessageService.GetMessageByID(messageId)
+}
+
 // DeleteMessage marks a message as deleted
 func (s *MessageService) DeleteMessage(messageId string) error {
 	return s.channelMessageService.DeleteChannelMessage(messageId)
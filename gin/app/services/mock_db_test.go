@@ -0,0 +1,47 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// newMockDB returns a database whose statements must match the expectations set on mock.
+// Unmet expectations fail the test.
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("opening mock database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		db.Close()
+	})
+	return db, mock
+}
+
+// captureArg matches any argument and remembers it, for values generated by the code
+// under test such as IDs and secrets
+type captureArg struct {
+	value string
+}
+
+func (a *captureArg) Match(v driver.Value) bool {
+	a.value, _ = v.(string)
+	return true
+}
+
+// sameAs matches the argument previously captured by another statement
+type sameAs struct {
+	captured *captureArg
+}
+
+func (a sameAs) Match(v driver.Value) bool {
+	return v == a.captured.value
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrBanned is returned when a banned user tries to join a server
+	ErrBanned = errors.New("you are banned from this server")
+
+	// ErrOwnerCannotLeave is returned when the owner tries to leave their own server
+	ErrOwnerCannotLeave = errors.New("the owner must transfer ownership before leaving")
+
+	// ErrCannotModerateSelf is returned when a member tries to kick or ban themselves
+	ErrCannotModerateSelf = errors.New("you cannot kick or ban yourself")
+
+	// ErrBanNotFound is returned when lifting a ban that does not exist
+	ErrBanNotFound = errors.New("ban not found")
+
+	// ErrUserNotFound is returned when the target user does not exist
+	ErrUserNotFound = errors.New("user not found")
+)
+
+// ModerationService removes members from servers and manages bans
+type ModerationService struct {
+	db          *sql.DB
+	permissions *PermissionResolver
+}
+
+// NewModerationService creates a new ModerationService
+func NewModerationService(db *sql.DB) *ModerationService {
+	return &ModerationService{
+		db:          db,
+		permissions: NewPermissionResolver(db),
+	}
+}
+
+// LeaveServer removes the user from a server they do not own.
+// It returns the IDs of the server's channels so live connections can be closed.
+func (s *ModerationService) LeaveServer(serverID, userID string) ([]string, error) {
+	var ownerID string
+	if err := s.db.QueryRow("SELECT owner_id FROM servers WHERE id = $1", serverID).Scan(&ownerID); err != nil {
+		return nil, err
+	}
+	if ownerID == userID {
+		return nil, ErrOwnerCannotLeave
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	removed, err := removeServerMember(tx, serverID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !removed {
+		return nil, ErrNotServerMember
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return serverChannelIDs(s.db, serverID)
+}
+
+// KickMember removes a member the actor outranks. The member may rejoin.
+func (s *ModerationService) KickMember(serverID, actorID, targetID string) ([]string, error) {
+	if err := s.checkCanModerate(serverID, actorID, targetID, true); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := removeServerMember(tx, serverID, targetID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return serverChannelIDs(s.db, serverID)
+}
+
+// BanMember removes the user from the server, if they are a member, and stops them from rejoining.
+// Users who are not members can be banned pre-emptively.
+func (s *ModerationService) BanMember(serverID, actorID, targetID, reason string) ([]string, error) {
+	if err := s.checkCanModerate(serverID, actorID, targetID, false); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO server_bans (server_id, user_id, banned_by, reason, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (server_id, user_id) DO UPDATE SET banned_by = EXCLUDED.banned_by, reason = EXCLUDED.reason, created_at = EXCLUDED.created_at
+	`, serverID, targetID, actorID, reason, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("error banning user: %w", err)
+	}
+	if _, err := removeServerMember(tx, serverID, targetID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return serverChannelIDs(s.db, serverID)
+}
+
+// UnbanMember lifts a ban
+func (s *ModerationService) UnbanMember(serverID, userID string) error {
+	result, err := s.db.Exec("DELETE FROM server_bans WHERE server_id = $1 AND user_id = $2", serverID, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrBanNotFound
+	}
+	return nil
+}
+
+// ListBans returns the bans of a server, newest first
+func (s *ModerationService) ListBans(serverID string) ([]models.Ban, error) {
+	rows, err := s.db.Query(`
+		SELECT b.server_id, b.user_id, u.username, COALESCE(b.banned_by::text, ''), b.reason, b.created_at
+		FROM server_bans b
+		JOIN users u ON u.id = b.user_id
+		WHERE b.server_id = $1
+		ORDER BY b.created_at DESC
+	`, serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bans := []models.Ban{}
+	for rows.Next() {
+		var ban models.Ban
+		if err := rows.Scan(&ban.ServerId, &ban.UserId, &ban.Username, &ban.BannedBy, &ban.Reason, &ban.CreatedAt); err != nil {
+			return nil, err
+		}
+		bans = append(bans, ban)
+	}
+	return bans, rows.Err()
+}
+
+// checkCanModerate verifies the actor may kick or ban the target
+func (s *ModerationService) checkCanModerate(serverID, actorID, targetID string, mustBeMember bool) error {
+	if actorID == targetID {
+		return ErrCannotModerateSelf
+	}
+	if _, err := uuid.Parse(targetID); err != nil {
+		return ErrUserNotFound
+	}
+
+	var isMember bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)",
+		serverID, targetID,
+	).Scan(&isMember)
+	if err != nil {
+		return err
+	}
+	if !isMember {
+		if mustBeMember {
+			return ErrNotServerMember
+		}
+		var exists bool
+		if err := s.db.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE id = $1)", targetID).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return ErrUserNotFound
+		}
+		return nil
+	}
+
+	outranks, err := s.permissions.Outranks(serverID, actorID, targetID)
+	if err != nil {
+		return err
+	}
+	if !outranks {
+		return ErrRoleHierarchy
+	}
+	return nil
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// serverChannelIDs returns the IDs of every channel in a server
+func serverChannelIDs(q queryer, serverID string) ([]string, error) {
+	rows, err := q.Query("SELECT id FROM channels WHERE server_id = $1", serverID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channelIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		channelIDs = append(channelIDs, id)
+	}
+	return channelIDs, rows.Err()
+}
+
+// removeServerMember deletes a membership along with the member's private channel access and overwrites.
+// Role assignments go with the membership through their foreign key.
+func removeServerMember(tx *sql.Tx, serverID, userID string) (bool, error) {
+	_, err := tx.Exec(`
+		DELETE FROM channel_members
+		WHERE user_id = $2 AND channel_id IN (SELECT id FROM channels WHERE server_id = $1)
+	`, serverID, userID)
+	if err != nil {
+		return false, fmt.Errorf("error removing channel access: %w", err)
+	}
+	_, err = tx.Exec(`
+		DELETE FROM channel_overwrites
+		WHERE target_type = 'member' AND target_id = $2 AND channel_id IN (SELECT id FROM channels WHERE server_id = $1)
+	`, serverID, userID)
+	if err != nil {
+		return false, fmt.Errorf("error removing channel overwrites: %w", err)
+	}
+
+	result, err := tx.Exec("DELETE FROM server_members WHERE server_id = $1 AND user_id = $2", serverID, userID)
+	if err != nil {
+		return false, fmt.Errorf("error removing server member: %w", err)
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// isBanned checks whether a user is banned from a server
+func isBanned(db *sql.DB, serverID, userID string) (bool, error) {
+	var banned bool
+	err := db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM server_bans WHERE server_id = $1 AND user_id = $2)",
+		serverID, userID,
+	).Scan(&banned)
+	return banned, err
+}
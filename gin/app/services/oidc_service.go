@@ -0,0 +1,553 @@
+package services
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"app/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// oidcStateTTL is how long an authorization request may take to complete
+	oidcStateTTL = 10 * time.Minute
+
+	// oidcJWKSRefreshInterval limits how often the JWKS is refetched for unknown key IDs
+	oidcJWKSRefreshInterval = time.Minute
+)
+
+var (
+	// ErrInvalidOIDCState is returned when the state parameter is unknown or expired
+	ErrInvalidOIDCState = errors.New("invalid or expired login state")
+
+	// ErrOIDCAccountConflict is returned when the IdP email belongs to a local account that cannot be linked
+	ErrOIDCAccountConflict = errors.New("an account with this email already exists; log in with your password first")
+
+	// ErrOIDCIdentityMismatch is returned when a re-authentication signs in an identity not linked to the user
+	ErrOIDCIdentityMismatch = errors.New("the identity provider signed in a different account")
+
+	usernameInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_.-]+`)
+)
+
+// OIDCConfig holds the client settings for an OpenID Connect provider
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// OIDCConfigFromEnv reads the provider settings. ok is false when OIDC_ISSUER is not set.
+func OIDCConfigFromEnv() (OIDCConfig, bool) {
+	issuer := os.Getenv("OIDC_ISSUER")
+	if issuer == "" {
+		return OIDCConfig{}, false
+	}
+
+	scopes := strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "email", "profile"}
+	}
+
+	return OIDCConfig{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		Scopes:       scopes,
+	}, true
+}
+
+// oidcDiscovery is the subset of the provider metadata we use
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcClaims are the ID token claims used to find or create the local user
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	Nonce             string `json:"nonce"`
+	AuthTime          int64  `json:"auth_time"`
+	jwt.RegisteredClaims
+}
+
+// OIDCCallbackResult is the outcome of an authorization response. A login yields a session
+// or a two-factor challenge; a re-authentication yields a reauthentication token.
+type OIDCCallbackResult struct {
+	User      *models.UserResponse
+	Challenge *models.MFAChallengeResponse
+	Reauth    *models.ReauthResponse
+}
+
+// OIDCService implements the OpenID Connect authorization code flow with PKCE
+type OIDCService struct {
+	db          *sql.DB
+	userService *UserService
+	config      OIDCConfig
+	httpClient  *http.Client
+
+	mu            sync.Mutex
+	discovery     *oidcDiscovery
+	jwks          JWKSet
+	jwksFetchedAt time.Time
+}
+
+// NewOIDCService creates a new OIDC service
+func NewOIDCService(db *sql.DB, userService *UserService, config OIDCConfig) *OIDCService {
+	return &OIDCService{
+		db:          db,
+		userService: userService,
+		config:      config,
+		httpClient:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// OIDCAuthorization is a started authorization request. Its state must be bound to the
+// browser that started it and checked again on the callback, so that nobody can complete
+// a login they did not start.
+type OIDCAuthorization struct {
+	URL       string
+	State     string
+	ExpiresAt time.Time
+}
+
+// AuthorizationURL starts a login and returns the provider URL to redirect the browser to
+func (s *OIDCService) AuthorizationURL() (*OIDCAuthorization, error) {
+	return s.authorizationURL("")
+}
+
+// ReauthenticationURL starts a fresh sign-in for a user who is already logged in, so that
+// accounts without a usable password can confirm sensitive actions
+func (s *OIDCService) ReauthenticationURL(userID string) (*OIDCAuthorization, error) {
+	return s.authorizationURL(userID)
+}
+
+// authorizationURL stores the state of a new authorization request and builds its URL.
+// userID is set for re-authentication, which makes the provider prompt for credentials again.
+func (s *OIDCService) authorizationURL(userID string) (*OIDCAuthorization, error) {
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := generateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	// Drop abandoned login attempts
+	if _, err := s.db.Exec("DELETE FROM oidc_login_states WHERE expires_at < $1", now); err != nil {
+		return nil, fmt.Errorf("error cleaning up login states: %w", err)
+	}
+
+	var reauthUserID *string
+	if userID != "" {
+		reauthUserID = &userID
+	}
+	expiresAt := now.Add(oidcStateTTL)
+	_, err = s.db.Exec(
+		"INSERT INTO oidc_login_states (state, nonce, code_verifier, user_id, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		state, nonce, verifier, reauthUserID, expiresAt, now,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error saving login state: %w", err)
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", s.config.ClientID)
+	params.Set("redirect_uri", s.config.RedirectURL)
+	params.Set("scope", strings.Join(s.config.Scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	if userID != "" {
+		params.Set("prompt", "login")
+		params.Set("max_age", "0")
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return &OIDCAuthorization{
+		URL:       discovery.AuthorizationEndpoint + separator + params.Encode(),
+		State:     state,
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// HandleCallback exchanges the authorization code and verifies the ID token. For a login it
+// logs the linked user in, creating the user on first login; for a re-authentication it
+// checks that the same identity signed in again and issues a reauthentication token.
+func (s *OIDCService) HandleCallback(code, state string, client models.ClientInfo) (*OIDCCallbackResult, error) {
+	// The state is single-use
+	var nonce, verifier string
+	var reauthUserID sql.NullString
+	err := s.db.QueryRow(
+		"DELETE FROM oidc_login_states WHERE state = $1 AND expires_at > $2 RETURNING nonce, code_verifier, user_id",
+		state, time.Now(),
+	).Scan(&nonce, &verifier, &reauthUserID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidOIDCState
+		}
+		return nil, fmt.Errorf("error loading login state: %w", err)
+	}
+
+	rawIDToken, err := s.exchangeCode(code, verifier)
+	if err != nil {
+		return nil, err
+	}
+
+	claims, err := s.verifyIDToken(rawIDToken, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	if reauthUserID.Valid {
+		reauth, err := s.reauthenticate(reauthUserID.String, claims)
+		if err != nil {
+			return nil, err
+		}
+		return &OIDCCallbackResult{Reauth: reauth}, nil
+	}
+
+	userID, err := s.findOrCreateUser(claims)
+	if err != nil {
+		return nil, err
+	}
+
+	user, challenge, err := s.userService.CompleteExternalLogin(userID, client)
+	if err != nil {
+		return nil, err
+	}
+	return &OIDCCallbackResult{User: user, Challenge: challenge}, nil
+}
+
+// reauthenticate checks that a fresh sign-in belongs to an identity linked to the user
+// and issues the token confirming it
+func (s *OIDCService) reauthenticate(userID string, claims *oidcClaims) (*models.ReauthResponse, error) {
+	// max_age=0 obliges the provider to report when the user actually signed in
+	if claims.AuthTime == 0 || time.Since(time.Unix(claims.AuthTime, 0)) > oidcStateTTL {
+		return nil, errors.New("invalid ID token: the identity provider did not ask for credentials again")
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE user_identities SET last_login_at = $1 WHERE issuer = $2 AND subject = $3 AND user_id = $4",
+		time.Now(), s.config.Issuer, claims.Subject, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error finding identity: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return nil, ErrOIDCIdentityMismatch
+	}
+
+	return s.userService.issueReauthToken(userID)
+}
+
+// exchangeCode redeems the authorization code at the token endpoint and returns the ID token
+func (s *OIDCService) exchangeCode(code, verifier string) (string, error) {
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return "", err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", s.config.RedirectURL)
+	form.Set("client_id", s.config.ClientID)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if s.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", fmt.Errorf("invalid token response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("token request rejected: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", errors.New("token response did not include an ID token")
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken checks the ID token signature against the provider JWKS and validates its claims
+func (s *OIDCService) verifyIDToken(rawIDToken, nonce string) (*oidcClaims, error) {
+	var claims oidcClaims
+	_, err := jwt.ParseWithClaims(rawIDToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := s.getSigningKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		return key.PublicKey()
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512", "EdDSA"}),
+		jwt.WithIssuer(s.config.Issuer),
+		jwt.WithAudience(s.config.ClientID),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+
+	if claims.Nonce != nonce {
+		return nil, errors.New("invalid ID token: nonce mismatch")
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("invalid ID token: missing subject")
+	}
+	return &claims, nil
+}
+
+// findOrCreateUser resolves the local user for an external identity
+func (s *OIDCService) findOrCreateUser(claims *oidcClaims) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+
+	// Returning user
+	var userID string
+	err = tx.QueryRow(
+		"UPDATE user_identities SET email = $1, last_login_at = $2 WHERE issuer = $3 AND subject = $4 RETURNING user_id",
+		claims.Email, now, s.config.Issuer, claims.Subject,
+	).Scan(&userID)
+	if err == nil {
+		return userID, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("error finding identity: %w", err)
+	}
+
+	if claims.Email == "" {
+		return "", errors.New("the identity provider did not return an email address")
+	}
+
+	// Link to an existing account only when both sides have verified the address
+	var localVerified bool
+	err = tx.QueryRow(
+		"SELECT id, verified_at IS NOT NULL FROM users WHERE email = $1",
+		claims.Email,
+	).Scan(&userID, &localVerified)
+	switch {
+	case err == nil:
+		if !claims.EmailVerified || !localVerified {
+			return "", ErrOIDCAccountConflict
+		}
+	case err == sql.ErrNoRows:
+		userID, err = s.createUser(tx, claims)
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("error finding user: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO user_identities (id, user_id, issuer, subject, email, created_at, last_login_at) VALUES ($1, $2, $3, $4, $5, $6, $6)",
+		uuid.New().String(), userID, s.config.Issuer, claims.Subject, claims.Email, now,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error linking identity: %w", err)
+	}
+
+	return userID, tx.Commit()
+}
+
+// createUser inserts a user for a first-time SSO login. The password is random and unusable;
+// sensitive account changes are confirmed by re-authenticating at the provider instead.
+func (s *OIDCService) createUser(tx *sql.Tx, claims *oidcClaims) (string, error) {
+	username, err := availableUsername(tx, claims)
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+
+	now := time.Now()
+	var verifiedAt *time.Time
+	if claims.EmailVerified {
+		verifiedAt = &now
+	}
+
+	userID := uuid.New().String()
+	_, err = tx.Exec(
+		"INSERT INTO users (id, username, email, password, verified_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		userID, username, claims.Email, string(hashedPassword), verifiedAt, now, now,
+	)
+	if err != nil {
+		return "", fmt.Errorf("error creating user: %w", err)
+	}
+	return userID, nil
+}
+
+// availableUsername derives a unique username from the ID token claims
+func availableUsername(tx *sql.Tx, claims *oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameInvalidChars.ReplaceAllString(base, "")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 1; i <= 100; i++ {
+		var taken bool
+		if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)", candidate).Scan(&taken); err != nil {
+			return "", fmt.Errorf("error checking username: %w", err)
+		}
+		if !taken {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s%d", base, i+1)
+	}
+	return "", errors.New("could not find an available username")
+}
+
+// getDiscovery fetches and caches the provider metadata
+func (s *OIDCService) getDiscovery() (*oidcDiscovery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.discovery != nil {
+		return s.discovery, nil
+	}
+
+	var discovery oidcDiscovery
+	if err := s.getJSON(s.config.Issuer+"/.well-known/openid-configuration", &discovery); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+	if strings.TrimRight(discovery.Issuer, "/") != s.config.Issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, expected %q", discovery.Issuer, s.config.Issuer)
+	}
+
+	s.discovery = &discovery
+	return s.discovery, nil
+}
+
+// getSigningKey returns the provider key with the given ID, refetching the JWKS on a miss
+func (s *OIDCService) getSigningKey(kid string) (JWK, error) {
+	discovery, err := s.getDiscovery()
+	if err != nil {
+		return JWK{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if key, ok := s.findKey(kid); ok {
+		return key, nil
+	}
+
+	// Keys may have been rotated
+	if time.Since(s.jwksFetchedAt) < oidcJWKSRefreshInterval {
+		return JWK{}, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var jwks JWKSet
+	if err := s.getJSON(discovery.JWKSURI, &jwks); err != nil {
+		return JWK{}, fmt.Errorf("fetching JWKS failed: %w", err)
+	}
+	s.jwks = jwks
+	s.jwksFetchedAt = time.Now()
+
+	if key, ok := s.findKey(kid); ok {
+		return key, nil
+	}
+	return JWK{}, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// findKey looks up a key in the cached JWKS. A missing kid matches a single-key set.
+func (s *OIDCService) findKey(kid string) (JWK, bool) {
+	if kid == "" && len(s.jwks.Keys) == 1 {
+		return s.jwks.Keys[0], true
+	}
+	return s.jwks.Find(kid)
+}
+
+// getJSON performs a GET request and decodes the JSON response
+func (s *OIDCService) getJSON(url string, v interface{}) error {
+	resp, err := s.httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d from %s", resp.StatusCode, url)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,372 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"app/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID    = "chat-app-client"
+	testRedirectURL = "http://localhost:5173/auth/callback"
+)
+
+// mockIssuer is an OpenID provider serving discovery, JWKS and a token endpoint that
+// enforces PKCE. Authorizations are granted directly by the test through authorize.
+type mockIssuer struct {
+	server *httptest.Server
+	keys   *KeySet
+
+	mu    sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+// mockAuthorization is an issued authorization code waiting to be redeemed
+type mockAuthorization struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating issuer key: %v", err)
+	}
+	key := &signingKey{id: "issuer-key", method: jwt.SigningMethodRS256, private: private}
+
+	issuer := &mockIssuer{
+		keys:  &KeySet{active: key, keys: map[string]*signingKey{key.id: key}},
+		codes: make(map[string]mockAuthorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(oidcDiscovery{
+			Issuer:                issuer.server.URL,
+			AuthorizationEndpoint: issuer.server.URL + "/authorize",
+			TokenEndpoint:         issuer.server.URL + "/token",
+			JWKSURI:               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		jwks, err := issuer.keys.JWKS()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(jwks)
+	})
+	mux.HandleFunc("POST /token", issuer.token)
+
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+// authorize plays the user signing in at the provider: it checks the authorization request
+// built by the service and returns the code and state the browser would bring back
+func (m *mockIssuer) authorize(t *testing.T, authURL string, claims jwt.MapClaims) (code, state string) {
+	t.Helper()
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("parsing authorization URL: %v", err)
+	}
+	if got := u.Scheme + "://" + u.Host + u.Path; got != m.server.URL+"/authorize" {
+		t.Fatalf("authorization endpoint = %s", got)
+	}
+	query := u.Query()
+	if query.Get("response_type") != "code" || query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" {
+		t.Fatalf("authorization request is not an S256 PKCE code request: %s", authURL)
+	}
+	if query.Get("client_id") != testClientID || query.Get("redirect_uri") != testRedirectURL {
+		t.Fatalf("authorization request has the wrong client: %s", authURL)
+	}
+
+	issued := jwt.MapClaims{
+		"iss":   m.server.URL,
+		"aud":   testClientID,
+		"nonce": query.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	if query.Get("prompt") == "login" && query.Get("max_age") == "0" {
+		issued["auth_time"] = time.Now().Unix()
+	}
+	for k, v := range claims {
+		issued[k] = v
+	}
+
+	code, err = generateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.mu.Lock()
+	m.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), claims: issued}
+	m.mu.Unlock()
+
+	return code, query.Get("state")
+}
+
+// token redeems an authorization code once, checking the PKCE verifier against the challenge
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	m.mu.Lock()
+	authorization, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || r.PostForm.Get("client_id") != testClientID || r.PostForm.Get("redirect_uri") != testRedirectURL ||
+		base64.RawURLEncoding.EncodeToString(verifier[:]) != authorization.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken, err := m.keys.Sign(authorization.claims)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"access_token": "opaque", "token_type": "Bearer", "id_token": idToken})
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	issuer := newMockIssuer(t)
+	q := regexp.QuoteMeta
+
+	userColumns := []string{"id", "username", "email", "display_name", "bio", "avatar_url", "discoverable", "verified_at", "totp_enabled", "created_at", "updated_at"}
+	expectSession := func(mock sqlmock.Sqlmock, userID interface{}, username, email string) {
+		now := time.Now()
+		mock.ExpectQuery(q("FROM users WHERE id = $1")).WithArgs(userID).
+			WillReturnRows(sqlmock.NewRows(userColumns).AddRow("user-id", username, email, "", "", "", true, now, false, now, now))
+		mock.ExpectExec(q("INSERT INTO user_sessions")).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	noRows := func(columns ...string) *sqlmock.Rows { return sqlmock.NewRows(columns) }
+
+	tests := []struct {
+		name         string
+		reauthUserID string
+		claims       jwt.MapClaims
+		// expect sets the statements after the login state is consumed; createdID captures
+		// the ID of a user created on first login
+		expect       func(mock sqlmock.Sqlmock, createdID *captureArg)
+		tamperPKCE   bool
+		wantUsername string
+		wantReauth   bool
+		wantErr      error
+		wantErrText  string
+	}{
+		{
+			name:   "first login creates the user and links the identity",
+			claims: jwt.MapClaims{"sub": "sub-new", "email": "new@example.com", "email_verified": true, "preferred_username": "new user!"},
+			expect: func(mock sqlmock.Sqlmock, createdID *captureArg) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE user_identities SET email = $1, last_login_at = $2 WHERE issuer = $3 AND subject = $4 RETURNING user_id")).
+					WithArgs("new@example.com", sqlmock.AnyArg(), issuer.server.URL, "sub-new").
+					WillReturnRows(noRows("user_id"))
+				mock.ExpectQuery(q("SELECT id, verified_at IS NOT NULL FROM users WHERE email = $1")).
+					WithArgs("new@example.com").
+					WillReturnRows(noRows("id", "verified"))
+				mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM users WHERE username = $1)")).
+					WithArgs("newuser").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(q("INSERT INTO users")).
+					WithArgs(createdID, "newuser", "new@example.com", sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(q("INSERT INTO user_identities")).
+					WithArgs(sqlmock.AnyArg(), sameAs{createdID}, issuer.server.URL, "sub-new", "new@example.com", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				expectSession(mock, sameAs{createdID}, "newuser", "new@example.com")
+			},
+			wantUsername: "newuser",
+		},
+		{
+			name:   "verified email links the existing user",
+			claims: jwt.MapClaims{"sub": "sub-existing", "email": "alice@example.com", "email_verified": true},
+			expect: func(mock sqlmock.Sqlmock, _ *captureArg) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE user_identities")).WillReturnRows(noRows("user_id"))
+				mock.ExpectQuery(q("SELECT id, verified_at IS NOT NULL FROM users WHERE email = $1")).
+					WithArgs("alice@example.com").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "verified"}).AddRow("alice-id", true))
+				mock.ExpectExec(q("INSERT INTO user_identities")).
+					WithArgs(sqlmock.AnyArg(), "alice-id", issuer.server.URL, "sub-existing", "alice@example.com", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				expectSession(mock, "alice-id", "alice", "alice@example.com")
+			},
+			wantUsername: "alice",
+		},
+		{
+			name:   "unverified local account is not linked",
+			claims: jwt.MapClaims{"sub": "sub-existing", "email": "bob@example.com", "email_verified": true},
+			expect: func(mock sqlmock.Sqlmock, _ *captureArg) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE user_identities")).WillReturnRows(noRows("user_id"))
+				mock.ExpectQuery(q("SELECT id, verified_at IS NOT NULL FROM users WHERE email = $1")).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "verified"}).AddRow("bob-id", false))
+				mock.ExpectRollback()
+			},
+			wantErr: ErrOIDCAccountConflict,
+		},
+		{
+			name:   "returning identity logs the linked user in",
+			claims: jwt.MapClaims{"sub": "sub-linked", "email": "carol@example.com"},
+			expect: func(mock sqlmock.Sqlmock, _ *captureArg) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(q("UPDATE user_identities")).
+					WithArgs("carol@example.com", sqlmock.AnyArg(), issuer.server.URL, "sub-linked").
+					WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow("carol-id"))
+				mock.ExpectCommit()
+				expectSession(mock, "carol-id", "carol", "carol@example.com")
+			},
+			wantUsername: "carol",
+		},
+		{
+			name:         "re-authentication of the linked identity issues a reauth token",
+			reauthUserID: "carol-id",
+			claims:       jwt.MapClaims{"sub": "sub-linked", "email": "carol@example.com"},
+			expect: func(mock sqlmock.Sqlmock, _ *captureArg) {
+				mock.ExpectExec(q("UPDATE user_identities SET last_login_at = $1 WHERE issuer = $2 AND subject = $3 AND user_id = $4")).
+					WithArgs(sqlmock.AnyArg(), issuer.server.URL, "sub-linked", "carol-id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			wantReauth: true,
+		},
+		{
+			name:         "re-authentication as another identity is refused",
+			reauthUserID: "carol-id",
+			claims:       jwt.MapClaims{"sub": "sub-other", "email": "mallory@example.com"},
+			expect: func(mock sqlmock.Sqlmock, _ *captureArg) {
+				mock.ExpectExec(q("UPDATE user_identities SET last_login_at")).
+					WithArgs(sqlmock.AnyArg(), issuer.server.URL, "sub-other", "carol-id").
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			wantErr: ErrOIDCIdentityMismatch,
+		},
+		{
+			name:        "token endpoint rejects the wrong PKCE verifier",
+			claims:      jwt.MapClaims{"sub": "sub-linked"},
+			tamperPKCE:  true,
+			expect:      func(sqlmock.Sqlmock, *captureArg) {},
+			wantErrText: "invalid_grant",
+		},
+		{
+			name:        "ID token for another login attempt is rejected",
+			claims:      jwt.MapClaims{"sub": "sub-linked", "nonce": "replayed"},
+			expect:      func(sqlmock.Sqlmock, *captureArg) {},
+			wantErrText: "nonce mismatch",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			userService := NewUserService(db, &KeySet{hmacSecret: []byte("test-secret")})
+			service := NewOIDCService(db, userService, OIDCConfig{
+				Issuer:      issuer.server.URL,
+				ClientID:    testClientID,
+				RedirectURL: testRedirectURL,
+				Scopes:      []string{"openid", "email", "profile"},
+			})
+
+			// Starting the login stores the state, nonce and verifier
+			var nonce, verifier captureArg
+			var reauthUserID interface{}
+			if tt.reauthUserID != "" {
+				reauthUserID = tt.reauthUserID
+			}
+			mock.ExpectExec(q("DELETE FROM oidc_login_states WHERE expires_at < $1")).WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectExec(q("INSERT INTO oidc_login_states")).
+				WithArgs(sqlmock.AnyArg(), &nonce, &verifier, reauthUserID, sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			var authorization *OIDCAuthorization
+			var err error
+			if tt.reauthUserID != "" {
+				authorization, err = service.ReauthenticationURL(tt.reauthUserID)
+			} else {
+				authorization, err = service.AuthorizationURL()
+			}
+			if err != nil {
+				t.Fatalf("starting login: %v", err)
+			}
+			code, state := issuer.authorize(t, authorization.URL, tt.claims)
+			if state != authorization.State {
+				t.Fatalf("state = %q, want %q", state, authorization.State)
+			}
+
+			storedVerifier := verifier.value
+			if tt.tamperPKCE {
+				storedVerifier = strings.ToUpper(storedVerifier)
+			}
+			mock.ExpectQuery(q("DELETE FROM oidc_login_states WHERE state = $1 AND expires_at > $2 RETURNING nonce, code_verifier, user_id")).
+				WithArgs(state, sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"nonce", "code_verifier", "user_id"}).AddRow(nonce.value, storedVerifier, reauthUserID))
+
+			var createdID captureArg
+			tt.expect(mock, &createdID)
+
+			result, err := service.HandleCallback(code, state, models.ClientInfo{UserAgent: "test", IPAddress: "127.0.0.1"})
+			switch {
+			case tt.wantErr != nil:
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			case tt.wantErrText != "":
+				if err == nil || !strings.Contains(err.Error(), tt.wantErrText) {
+					t.Fatalf("error = %v, want it to mention %q", err, tt.wantErrText)
+				}
+				return
+			case err != nil:
+				t.Fatalf("HandleCallback: %v", err)
+			}
+
+			if tt.wantReauth {
+				if result.Reauth == nil || result.User != nil {
+					t.Fatalf("result = %+v, want only a reauth token", result)
+				}
+				claims, err := userService.parseToken(result.Reauth.ReauthToken, tokenPurposeReauth)
+				if err != nil || claims["userID"] != tt.reauthUserID {
+					t.Fatalf("reauth token claims = %v (%v), want user %s", claims, err, tt.reauthUserID)
+				}
+				if _, err := userService.parseToken(result.Reauth.ReauthToken, tokenPurposeAccess); err == nil {
+					t.Fatal("reauth token was accepted as an access token")
+				}
+				return
+			}
+
+			if result.User == nil || result.User.Username != tt.wantUsername || result.User.Token == "" || result.User.RefreshToken == "" {
+				t.Fatalf("result = %+v, want a session for %s", result.User, tt.wantUsername)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+
+	"app/models"
+)
+
+// PermissionResolver computes what a user may do in a server or channel.
+// Every authorization check on servers, channels and messages goes through it.
+type PermissionResolver struct {
+	db *sql.DB
+}
+
+// NewPermissionResolver creates a new PermissionResolver
+func NewPermissionResolver(db *sql.DB) *PermissionResolver {
+	return &PermissionResolver{
+		db: db,
+	}
+}
+
+// ServerPermissions returns the user's permissions in a server. The owner holds every
+// permission, members hold the union of @everyone and their roles, and non-members none.
+func (r *PermissionResolver) ServerPermissions(serverID, userID string) (models.Permission, error) {
+	var ownerID string
+	var isMember bool
+	var permissions int64
+	err := r.db.QueryRow(`
+		SELECT s.owner_id,
+		       EXISTS (SELECT 1 FROM server_members WHERE server_id = s.id AND user_id = $2),
+		       COALESCE((
+		         SELECT BIT_OR(sr.permissions)
+		         FROM server_roles sr
+		         WHERE sr.server_id = s.id AND (
+		           sr.is_default OR EXISTS (
+		             SELECT 1 FROM server_member_roles mr
+		             WHERE mr.role_id = sr.id AND mr.user_id = $2
+		           )
+		         )
+		       ), 0)
+		FROM servers s
+		WHERE s.id = $1
+	`, serverID, userID).Scan(&ownerID, &isMember, &permissions)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error resolving permissions: %w", err)
+	}
+
+	if ownerID == userID {
+		return models.AllPermissions, nil
+	}
+	if !isMember {
+		return 0, nil
+	}
+
+	resolved := models.Permission(permissions)
+	if resolved.Has(models.PermissionAdministrator) {
+		return models.AllPermissions, nil
+	}
+	return resolved, nil
+}
+
+// ChannelPermissions returns the user's permissions in a channel: their server permissions
+// adjusted by the channel's overwrites. Administrators bypass overwrites.
+func (r *PermissionResolver) ChannelPermissions(channelID, userID string) (models.Permission, error) {
+	var serverID string
+	err := r.db.QueryRow("SELECT server_id FROM channels WHERE id = $1", channelID).Scan(&serverID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("error resolving permissions: %w", err)
+	}
+
+	permissions, err := r.channelPermissions(serverID, userID, channelID)
+	if err != nil {
+		return 0, err
+	}
+	return permissions[channelID], nil
+}
+
+// ServerChannelPermissions returns the user's permissions in every channel of a server
+func (r *PermissionResolver) ServerChannelPermissions(serverID, userID string) (map[string]models.Permission, error) {
+	return r.channelPermissions(serverID, userID, "")
+}
+
+// channelPermissions resolves the user's permissions in one channel of a server, or in all
+// of them when channelID is empty.
+//
+// Overwrites are applied in order: @everyone, the union of the member's roles, then the
+// member. At each level denies are removed before allows are added. A private channel
+// behaves as if @everyone were denied view, and its channel members as if they were
+// allowed it. Without view the user has no permissions in the channel. Finally the
+// channel's mode may take away posting.
+func (r *PermissionResolver) channelPermissions(serverID, userID, channelID string) (map[string]models.Permission, error) {
+	base, err := r.ServerPermissions(serverID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := r.db.Query(`
+		SELECT c.id, c.is_private, c.mode,
+		       EXISTS (SELECT 1 FROM channel_members cm WHERE cm.channel_id = c.id AND cm.user_id = $2),
+		       COALESCE(ev.allow, 0), COALESCE(ev.deny, 0),
+		       COALESCE(ro.allow, 0), COALESCE(ro.deny, 0),
+		       COALESCE(me.allow, 0), COALESCE(me.deny, 0)
+		FROM channels c
+		LEFT JOIN channel_overwrites ev ON ev.channel_id = c.id AND ev.target_type = 'role'
+		  AND ev.target_id = (SELECT id FROM server_roles WHERE server_id = c.server_id AND is_default)
+		LEFT JOIN LATERAL (
+		  SELECT BIT_OR(o.allow) AS allow, BIT_OR(o.deny) AS deny
+		  FROM channel_overwrites o
+		  JOIN server_member_roles mr ON mr.role_id = o.target_id AND mr.server_id = c.server_id AND mr.user_id = $2
+		  WHERE o.channel_id = c.id AND o.target_type = 'role'
+		) ro ON TRUE
+		LEFT JOIN channel_overwrites me ON me.channel_id = c.id AND me.target_type = 'member' AND me.target_id = $2
+		WHERE c.server_id = $1 AND ($3 = '' OR c.id::text = $3)
+	`, serverID, userID, channelID)
+	if err != nil {
+		return nil, fmt.Errorf("error resolving channel permissions: %w", err)
+	}
+	defer rows.Close()
+
+	permissions := make(map[string]models.Permission)
+	for rows.Next() {
+		var id string
+		var mode string
+		var isPrivate, isChannelMember bool
+		var everyoneAllow, everyoneDeny, roleAllow, roleDeny, memberAllow, memberDeny int64
+		if err := rows.Scan(
+			&id, &isPrivate, &mode, &isChannelMember,
+			&everyoneAllow, &everyoneDeny, &roleAllow, &roleDeny, &memberAllow, &memberDeny,
+		); err != nil {
+			return nil, err
+		}
+
+		if base.Has(models.PermissionAdministrator) {
+			permissions[id] = applyChannelMode(mode, base)
+			continue
+		}
+		if base == 0 {
+			continue
+		}
+
+		if isPrivate {
+			everyoneDeny |= int64(models.PermissionViewChannels)
+		}
+		if isChannelMember {
+			memberAllow |= int64(models.PermissionViewChannels)
+		}
+
+		resolved := base
+		resolved = resolved&^models.Permission(everyoneDeny) | models.Permission(everyoneAllow)
+		resolved = resolved&^models.Permission(roleDeny) | models.Permission(roleAllow)
+		resolved = resolved&^models.Permission(memberDeny) | models.Permission(memberAllow)
+
+		if resolved.Has(models.PermissionViewChannels) {
+			permissions[id] = applyChannelMode(mode, resolved)
+		}
+	}
+	return permissions, rows.Err()
+}
+
+// postingPermissions are removed in channels whose mode does not let the user post
+const postingPermissions = models.PermissionSendMessages | models.PermissionAttachFiles | models.PermissionMentionEveryone
+
+// applyChannelMode removes the posting permissions the channel's mode forbids. Nobody posts in
+// archived channels, administrators included; announcement channels are limited to members
+// who may manage messages there.
+func applyChannelMode(mode string, permissions models.Permission) models.Permission {
+	switch mode {
+	case models.ChannelModeArchived:
+		return permissions &^ postingPermissions
+	case models.ChannelModeAnnouncement:
+		if !permissions.Has(models.PermissionManageMessages) {
+			return permissions &^ postingPermissions
+		}
+	}
+	return permissions
+}
+
+// HasServerPermission reports whether the user holds perm in the server
+func (r *PermissionResolver) HasServerPermission(serverID, userID string, perm models.Permission) (bool, error) {
+	permissions, err := r.ServerPermissions(serverID, userID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Has(perm), nil
+}
+
+// HasChannelPermission reports whether the user holds perm in the channel
+func (r *PermissionResolver) HasChannelPermission(channelID, userID string, perm models.Permission) (bool, error) {
+	permissions, err := r.ChannelPermissions(channelID, userID)
+	if err != nil {
+		return false, err
+	}
+	return permissions.Has(perm), nil
+}
+
+// HighestRolePosition returns the position of the user's highest role. The owner
+// outranks every role; members without roles are at the @everyone position 0.
+func (r *PermissionResolver) HighestRolePosition(serverID, userID string) (int, error) {
+	var ownerID string
+	var position int
+	err := r.db.QueryRow(`
+		SELECT s.owner_id, COALESCE((
+		  SELECT MAX(sr.position)
+		  FROM server_member_roles mr
+		  JOIN server_roles sr ON sr.id = mr.role_id
+		  WHERE mr.server_id = s.id AND mr.user_id = $2
+		), 0)
+		FROM servers s
+		WHERE s.id = $1
+	`, serverID, userID).Scan(&ownerID, &position)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrServerNotFound
+		}
+		return 0, fmt.Errorf("error resolving role position: %w", err)
+	}
+	if ownerID == userID {
+		return math.MaxInt32, nil
+	}
+	return position, nil
+}
+
+// Outranks reports whether the actor's highest role is above the target's.
+// Nobody outranks the owner.
+func (r *PermissionResolver) Outranks(serverID, actorID, targetID string) (bool, error) {
+	actor, err := r.HighestRolePosition(serverID, actorID)
+	if err != nil {
+		return false, err
+	}
+	target, err := r.HighestRolePosition(serverID, targetID)
+	if err != nil {
+		return false, err
+	}
+	return actor > target, nil
+}
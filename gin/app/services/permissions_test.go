@@ -0,0 +1,282 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"app/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	testServerID  = "server-id"
+	testChannelID = "channel-id"
+	testOwnerID   = "owner-id"
+	testUserID    = "user-id"
+)
+
+// expectServerPermissions expects the resolver's server query and answers it with the
+// server's owner, whether the user is a member and the union of their role permissions
+func expectServerPermissions(mock sqlmock.Sqlmock, userID string, isMember bool, permissions models.Permission) {
+	mock.ExpectQuery(regexp.QuoteMeta("FROM servers s")).
+		WithArgs(testServerID, userID).
+		WillReturnRows(sqlmock.NewRows([]string{"owner_id", "is_member", "permissions"}).
+			AddRow(testOwnerID, isMember, int64(permissions)))
+}
+
+func TestServerPermissions(t *testing.T) {
+	tests := []struct {
+		name   string
+		userID string
+		expect func(mock sqlmock.Sqlmock)
+		want   models.Permission
+	}{
+		{
+			name:   "owner holds every permission",
+			userID: testOwnerID,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectServerPermissions(mock, testOwnerID, true, 0)
+			},
+			want: models.AllPermissions,
+		},
+		{
+			name:   "member holds the union of their roles",
+			userID: testUserID,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectServerPermissions(mock, testUserID, true, models.DefaultPermissions|models.PermissionKickMembers)
+			},
+			want: models.DefaultPermissions | models.PermissionKickMembers,
+		},
+		{
+			name:   "administrator holds every permission",
+			userID: testUserID,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectServerPermissions(mock, testUserID, true, models.PermissionAdministrator)
+			},
+			want: models.AllPermissions,
+		},
+		{
+			name:   "non-member holds nothing despite @everyone",
+			userID: testUserID,
+			expect: func(mock sqlmock.Sqlmock) {
+				expectServerPermissions(mock, testUserID, false, models.DefaultPermissions)
+			},
+			want: 0,
+		},
+		{
+			name:   "missing server grants nothing",
+			userID: testUserID,
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta("FROM servers s")).
+					WillReturnRows(sqlmock.NewRows([]string{"owner_id", "is_member", "permissions"}))
+			},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			tt.expect(mock)
+
+			got, err := NewPermissionResolver(db).ServerPermissions(testServerID, tt.userID)
+			if err != nil {
+				t.Fatalf("ServerPermissions: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("permissions = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutranks(t *testing.T) {
+	positionRows := func(position int) *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"owner_id", "position"}).AddRow(testOwnerID, position)
+	}
+
+	tests := []struct {
+		name     string
+		actorID  string
+		targetID string
+		// positions are the highest role positions of the actor and the target
+		positions []int
+		want      bool
+		wantErr   error
+	}{
+		{name: "higher role outranks lower role", actorID: testUserID, targetID: "target-id", positions: []int{3, 1}, want: true},
+		{name: "equal roles do not outrank", actorID: testUserID, targetID: "target-id", positions: []int{2, 2}},
+		{name: "members never outrank themselves", actorID: testUserID, targetID: testUserID, positions: []int{2, 2}},
+		{name: "owner outranks every role", actorID: testOwnerID, targetID: testUserID, positions: []int{0, 100}, want: true},
+		{name: "nobody outranks the owner", actorID: testUserID, targetID: testOwnerID, positions: []int{100, 0}},
+		{name: "missing server is not found", actorID: testUserID, targetID: "target-id", wantErr: ErrServerNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			if tt.positions == nil {
+				mock.ExpectQuery(regexp.QuoteMeta("MAX(sr.position)")).
+					WillReturnRows(sqlmock.NewRows([]string{"owner_id", "position"}))
+			}
+			for i, userID := range []string{tt.actorID, tt.targetID}[:len(tt.positions)] {
+				mock.ExpectQuery(regexp.QuoteMeta("MAX(sr.position)")).
+					WithArgs(testServerID, userID).
+					WillReturnRows(positionRows(tt.positions[i]))
+			}
+
+			got, err := NewPermissionResolver(db).Outranks(testServerID, tt.actorID, tt.targetID)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("outranks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// channelRow is the channel query's row for testChannelID: the channel's privacy and mode,
+// whether the user is a channel member, and the @everyone, role and member overwrites
+type channelRow struct {
+	isPrivate, isChannelMember  bool
+	mode                        string
+	everyoneAllow, everyoneDeny models.Permission
+	roleAllow, roleDeny         models.Permission
+	memberAllow, memberDeny     models.Permission
+}
+
+func TestChannelPermissions(t *testing.T) {
+	const view, send, attach = models.PermissionViewChannels, models.PermissionSendMessages, models.PermissionAttachFiles
+
+	tests := []struct {
+		name    string
+		base    models.Permission // the user's server permissions
+		channel channelRow
+		want    models.Permission
+	}{
+		{
+			name: "no overwrites keep the server permissions",
+			base: models.DefaultPermissions,
+			want: models.DefaultPermissions,
+		},
+		{
+			name:    "@everyone deny removes a permission",
+			base:    models.DefaultPermissions,
+			channel: channelRow{everyoneDeny: send},
+			want:    models.DefaultPermissions &^ send,
+		},
+		{
+			name:    "role allow wins over @everyone deny",
+			base:    models.DefaultPermissions,
+			channel: channelRow{everyoneDeny: send, roleAllow: send},
+			want:    models.DefaultPermissions,
+		},
+		{
+			name:    "member deny wins over role allow",
+			base:    models.DefaultPermissions,
+			channel: channelRow{roleAllow: attach, memberDeny: attach},
+			want:    models.DefaultPermissions &^ attach,
+		},
+		{
+			name:    "role deny of view hides the channel",
+			base:    models.DefaultPermissions,
+			channel: channelRow{roleDeny: view},
+			want:    0,
+		},
+		{
+			name:    "private channel is hidden from non-members",
+			base:    models.DefaultPermissions,
+			channel: channelRow{isPrivate: true},
+			want:    0,
+		},
+		{
+			name:    "private channel is visible to its members",
+			base:    models.DefaultPermissions,
+			channel: channelRow{isPrivate: true, isChannelMember: true},
+			want:    models.DefaultPermissions,
+		},
+		{
+			name:    "role allow of view opens a private channel",
+			base:    models.DefaultPermissions,
+			channel: channelRow{isPrivate: true, roleAllow: view},
+			want:    models.DefaultPermissions,
+		},
+		{
+			name:    "administrator bypasses overwrites and privacy",
+			base:    models.PermissionAdministrator,
+			channel: channelRow{isPrivate: true, everyoneDeny: view | send, memberDeny: view},
+			want:    models.AllPermissions,
+		},
+		{
+			name:    "archived channel takes posting away from everyone",
+			base:    models.PermissionAdministrator,
+			channel: channelRow{mode: models.ChannelModeArchived},
+			want:    models.AllPermissions &^ postingPermissions,
+		},
+		{
+			name:    "announcement channel takes posting away from members",
+			base:    models.DefaultPermissions | models.PermissionMentionEveryone,
+			channel: channelRow{mode: models.ChannelModeAnnouncement},
+			want:    models.DefaultPermissions &^ (send | attach),
+		},
+		{
+			name:    "announcement channel lets message managers post",
+			base:    models.DefaultPermissions | models.PermissionManageMessages,
+			channel: channelRow{mode: models.ChannelModeAnnouncement},
+			want:    models.DefaultPermissions | models.PermissionManageMessages,
+		},
+		{
+			name:    "manage messages granted in the channel lets members post announcements",
+			base:    models.DefaultPermissions,
+			channel: channelRow{mode: models.ChannelModeAnnouncement, roleAllow: models.PermissionManageMessages},
+			want:    models.DefaultPermissions | models.PermissionManageMessages,
+		},
+		{
+			name:    "non-member gets nothing even with allows",
+			base:    0,
+			channel: channelRow{everyoneAllow: view | send},
+			want:    0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(regexp.QuoteMeta("SELECT server_id FROM channels WHERE id = $1")).
+				WithArgs(testChannelID).
+				WillReturnRows(sqlmock.NewRows([]string{"server_id"}).AddRow(testServerID))
+			expectServerPermissions(mock, testUserID, tt.base != 0, tt.base)
+			expectChannelRow(mock, tt.channel)
+
+			got, err := NewPermissionResolver(db).ChannelPermissions(testChannelID, testUserID)
+			if err != nil {
+				t.Fatalf("ChannelPermissions: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("permissions = %b, want %b", got, tt.want)
+			}
+		})
+	}
+}
+
+// expectChannelRow expects the resolver's channel query for testChannelID and answers it
+// with row
+func expectChannelRow(mock sqlmock.Sqlmock, row channelRow) {
+	mode := row.mode
+	if mode == "" {
+		mode = models.ChannelModeNormal
+	}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM channels c")).
+		WithArgs(testServerID, testUserID, testChannelID).
+		WillReturnRows(sqlmock.NewRows([]string{
+			"id", "is_private", "mode", "is_channel_member",
+			"everyone_allow", "everyone_deny", "role_allow", "role_deny", "member_allow", "member_deny",
+		}).AddRow(
+			testChannelID, row.isPrivate, mode, row.isChannelMember,
+			int64(row.everyoneAllow), int64(row.everyoneDeny), int64(row.roleAllow), int64(row.roleDeny),
+			int64(row.memberAllow), int64(row.memberDeny),
+		))
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"app/models"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	avatarDir     = "./uploads/avatars"
+	avatarURLPath = "/uploads/avatars/"
+	maxAvatarSize = 5 << 20
+)
+
+var (
+	// ErrUsernameTaken is returned when another user already has the requested username
+	ErrUsernameTaken = errors.New("username is already taken")
+
+	// ErrInvalidUsername is returned when a username is empty or too long after trimming
+	ErrInvalidUsername = errors.New("username must be between 3 and 30 characters")
+
+	// ErrInvalidPassword is returned when the current password does not match
+	ErrInvalidPassword = errors.New("invalid password")
+
+	// ErrInvalidAvatar is returned when an avatar upload is not an image or is too large
+	ErrInvalidAvatar = errors.New("avatar must be an image of at most 5MB")
+)
+
+// UpdateProfile changes the display name, username, bio and discoverability of a user.
+// Fields left nil in the request are not changed.
+func (s *UserService) UpdateProfile(userID string, req models.UpdateProfileRequest) (*models.User, error) {
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if len(username) < 3 || len(username) > 30 {
+			return nil, ErrInvalidUsername
+		}
+
+		var count int
+		if err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE username = $1 AND id <> $2", username, userID).Scan(&count); err != nil {
+			return nil, fmt.Errorf("error checking username: %w", err)
+		}
+		if count > 0 {
+			return nil, ErrUsernameTaken
+		}
+		req.Username = &username
+	}
+	if req.DisplayName != nil {
+		displayName := strings.TrimSpace(*req.DisplayName)
+		req.DisplayName = &displayName
+	}
+
+	_, err := s.db.Exec(`
+		UPDATE users
+		SET display_name = COALESCE($1, display_name),
+		    username = COALESCE($2, username),
+		    bio = COALESCE($3, bio),
+		    discoverable = COALESCE($4, discoverable),
+		    updated_at = $5
+		WHERE id = $6
+	`, req.DisplayName, req.Username, req.Bio, req.Discoverable, time.Now(), userID)
+	if err != nil {
+		// Lost a race with another user taking the same name
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			return nil, ErrUsernameTaken
+		}
+		return nil, fmt.Errorf("error updating profile: %w", err)
+	}
+
+	return s.GetUserByID(userID)
+}
+
+// UpdateAvatar stores a new avatar image with the other uploads and removes the previous one
+func (s *UserService) UpdateAvatar(userID string, file *multipart.FileHeader) (*models.User, error) {
+	if getFileType(strings.ToLower(file.Filename)) != "image" || file.Size > maxAvatarSize {
+		return nil, ErrInvalidAvatar
+	}
+
+	var previous string
+	if err := s.db.QueryRow("SELECT COALESCE(avatar_url, '') FROM users WHERE id = $1", userID).Scan(&previous); err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+
+	_, filePath, err := saveUpload(file, avatarDir)
+	if err != nil {
+		return nil, err
+	}
+
+	avatarURL := avatarURLPath + filepath.Base(filePath)
+	if _, err := s.db.Exec("UPDATE users SET avatar_url = $1, updated_at = $2 WHERE id = $3", avatarURL, time.Now(), userID); err != nil {
+		os.Remove(filePath)
+		return nil, fmt.Errorf("error updating avatar: %w", err)
+	}
+
+	if strings.HasPrefix(previous, avatarURLPath) {
+		os.Remove(filepath.Join(avatarDir, filepath.Base(previous)))
+	}
+
+	return s.GetUserByID(userID)
+}
+
+// ChangePassword sets a new password after checking the current one. Users signed in through
+// an identity provider, who never chose a password, present a reauthentication token instead.
+// Every session except the one making the request is signed out; their IDs are returned.
+func (s *UserService) ChangePassword(userID, sessionID, currentPassword, reauthToken, newPassword string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var hashedPassword string
+	if err := tx.QueryRow("SELECT password FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&hashedPassword); err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if err := s.confirmIdentity(userID, hashedPassword, currentPassword, reauthToken); err != nil {
+		return nil, err
+	}
+
+	newHash, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, fmt.Errorf("error hashing password: %w", err)
+	}
+
+	now := time.Now()
+	if _, err = tx.Exec("UPDATE users SET password = $1, updated_at = $2 WHERE id = $3", string(newHash), now, userID); err != nil {
+		return nil, fmt.Errorf("error updating password: %w", err)
+	}
+
+	rows, err := tx.Query(
+		"UPDATE user_sessions SET revoked_at = $1, updated_at = $1 WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL RETURNING id",
+		now, userID, sessionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	var revoked []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		revoked = append(revoked, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revoked, tx.Commit()
+}
+
+// GetSharedChannelIDs returns the channels of every server the user belongs to.
+// Everyone connected to one of them shares a server with the user.
+func (s *UserService) GetSharedChannelIDs(userID string) ([]string, error) {
+	rows, err := s.db.Query(`
+		SELECT c.id
+		FROM channels c
+		JOIN server_members sm ON sm.server_id = c.server_id
+		WHERE sm.user_id = $1
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var channelIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		channelIDs = append(channelIDs, id)
+	}
+
+	return channelIDs, rows.Err()
+}
@@ -0,0 +1,69 @@
+package services
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter is an in-memory sliding window limiter, keyed for example by client IP
+type RateLimiter struct {
+	mu        sync.Mutex
+	limit     int
+	window    time.Duration
+	hits      map[string][]time.Time
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter that allows limit requests per key within window
+func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return &RateLimiter{
+		limit:     limit,
+		window:    window,
+		hits:      make(map[string][]time.Time),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow records a request for key. When the limit is reached the request is not
+// recorded and the time until the next request is allowed is returned.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.sweep(now)
+
+	hits := pruneHits(l.hits[key], now.Add(-l.window))
+	if len(hits) >= l.limit {
+		l.hits[key] = hits
+		return false, hits[0].Add(l.window).Sub(now)
+	}
+
+	l.hits[key] = append(hits, now)
+	return true, 0
+}
+
+// sweep drops idle keys once per window so the map does not grow without bound
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.window {
+		return
+	}
+	cutoff := now.Add(-l.window)
+	for key, hits := range l.hits {
+		if hits = pruneHits(hits, cutoff); len(hits) == 0 {
+			delete(l.hits, key)
+		} else {
+			l.hits[key] = hits
+		}
+	}
+	l.lastSweep = now
+}
+
+// pruneHits removes the timestamps at or before cutoff
+func pruneHits(hits []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(hits) && !hits[i].After(cutoff) {
+		i++
+	}
+	return hits[i:]
+}
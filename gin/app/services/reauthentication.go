@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"app/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// reauthTokenTTL is how long a fresh sign-in at the identity provider confirms sensitive actions
+const reauthTokenTTL = 5 * time.Minute
+
+// ErrInvalidReauthToken is returned when a reauthentication token is invalid, expired or
+// was issued to another user
+var ErrInvalidReauthToken = errors.New("invalid or expired reauthentication token")
+
+// issueReauthToken returns a short-lived token proving that the user has just signed in again.
+// Accounts created through single sign-on have no usable password and confirm sensitive
+// actions such as account deletion with this token instead.
+func (s *UserService) issueReauthToken(userID string) (*models.ReauthResponse, error) {
+	expiresAt := time.Now().Add(reauthTokenTTL)
+	token, err := s.signToken(tokenPurposeReauth, jwt.MapClaims{
+		"userID": userID,
+		"exp":    expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating reauthentication token: %w", err)
+	}
+
+	return &models.ReauthResponse{
+		ReauthToken: token,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// confirmIdentity checks that the signed-in user has just proved who they are, either with
+// their password or with a reauthentication token issued to them
+func (s *UserService) confirmIdentity(userID, hashedPassword, password, reauthToken string) error {
+	if reauthToken != "" {
+		claims, err := s.parseToken(reauthToken, tokenPurposeReauth)
+		if err != nil || claims["userID"] != userID {
+			return ErrInvalidReauthToken
+		}
+		return nil
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	return nil
+}
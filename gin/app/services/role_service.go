@@ -0,0 +1,403 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRoleNotFound is returned when a role does not exist in the server
+	ErrRoleNotFound = errors.New("role not found")
+
+	// ErrRoleHierarchy is returned when acting on a role or member at or above the actor's highest role
+	ErrRoleHierarchy = errors.New("you can only manage roles and members below your highest role")
+
+	// ErrPermissionEscalation is returned when granting permissions the actor does not hold
+	ErrPermissionEscalation = errors.New("you cannot grant permissions you do not have")
+
+	// ErrDefaultRole is returned when deleting, moving or assigning the @everyone role
+	ErrDefaultRole = errors.New("the @everyone role cannot be deleted, moved or assigned")
+
+	// ErrInvalidRoleOrder is returned when a reorder request does not list every role exactly once
+	ErrInvalidRoleOrder = errors.New("roleIds must list every role except @everyone exactly once")
+
+	// ErrNotServerMember is returned when the target user is not a member of the server
+	ErrNotServerMember = errors.New("user is not a member of this server")
+)
+
+// RoleService manages the custom roles of servers and the roles held by members
+type RoleService struct {
+	db          *sql.DB
+	permissions *PermissionResolver
+}
+
+// NewRoleService creates a new RoleService
+func NewRoleService(db *sql.DB) *RoleService {
+	return &RoleService{
+		db:          db,
+		permissions: NewPermissionResolver(db),
+	}
+}
+
+// ListRoles returns the roles of a server from highest to lowest
+func (s *RoleService) ListRoles(serverID string) ([]models.Role, error) {
+	return s.queryRoles(`
+		SELECT id, server_id, name, color, position, permissions, is_default, created_at, updated_at
+		FROM server_roles
+		WHERE server_id = $1
+		ORDER BY position DESC
+	`, serverID)
+}
+
+// GetMemberRoles returns the roles a member holds, not including @everyone
+func (s *RoleService) GetMemberRoles(serverID, userID string) ([]models.Role, error) {
+	return s.queryRoles(`
+		SELECT sr.id, sr.server_id, sr.name, sr.color, sr.position, sr.permissions, sr.is_default, sr.created_at, sr.updated_at
+		FROM server_roles sr
+		JOIN server_member_roles mr ON mr.role_id = sr.id
+		WHERE mr.server_id = $1 AND mr.user_id = $2
+		ORDER BY sr.position DESC
+	`, serverID, userID)
+}
+
+// CreateRole adds a role directly above @everyone
+func (s *RoleService) CreateRole(serverID, actorID string, req models.RoleRequest) (*models.Role, error) {
+	role := models.Role{
+		ID:        uuid.New().String(),
+		ServerId:  serverID,
+		Name:      req.Name,
+		Color:     req.Color,
+		Position:  1,
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+	}
+	if req.Permissions != nil {
+		role.Permissions = *req.Permissions
+	}
+	if err := s.checkGrant(serverID, actorID, role.Permissions); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE server_roles SET position = position + 1 WHERE server_id = $1 AND position >= 1", serverID); err != nil {
+		return nil, fmt.Errorf("error shifting roles: %w", err)
+	}
+	_, err = tx.Exec(
+		"INSERT INTO server_roles (id, server_id, name, color, position, permissions, is_default, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, FALSE, $7, $8)",
+		role.ID, role.ServerId, role.Name, role.Color, role.Position, int64(role.Permissions), role.CreatedAt, role.UpdatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error creating role: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+// UpdateRole changes the name, color and permissions of a role below the actor's highest role.
+// The @everyone role keeps its name.
+func (s *RoleService) UpdateRole(serverID, roleID, actorID string, req models.RoleRequest) (*models.Role, error) {
+	role, err := s.GetRole(serverID, roleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRoleBelowActor(serverID, actorID, role); err != nil {
+		return nil, err
+	}
+
+	if !role.IsDefault {
+		role.Name = req.Name
+	}
+	role.Color = req.Color
+	if req.Permissions != nil {
+		if err := s.checkGrant(serverID, actorID, *req.Permissions&^role.Permissions); err != nil {
+			return nil, err
+		}
+		role.Permissions = *req.Permissions
+	}
+	role.UpdatedAt = time.Now()
+
+	_, err = s.db.Exec(
+		"UPDATE server_roles SET name = $1, color = $2, permissions = $3, updated_at = $4 WHERE id = $5",
+		role.Name, role.Color, int64(role.Permissions), role.UpdatedAt, role.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error updating role: %w", err)
+	}
+	return role, nil
+}
+
+// DeleteRole deletes a role below the actor's highest role and closes the gap in positions
+func (s *RoleService) DeleteRole(serverID, roleID, actorID string) error {
+	role, err := s.GetRole(serverID, roleID)
+	if err != nil {
+		return err
+	}
+	if role.IsDefault {
+		return ErrDefaultRole
+	}
+	if err := s.checkRoleBelowActor(serverID, actorID, role); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM server_roles WHERE id = $1", role.ID); err != nil {
+		return fmt.Errorf("error deleting role: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM channel_overwrites WHERE target_type = 'role' AND target_id = $1", role.ID); err != nil {
+		return fmt.Errorf("error deleting role overwrites: %w", err)
+	}
+	if _, err := tx.Exec("UPDATE server_roles SET position = position - 1 WHERE server_id = $1 AND position > $2", serverID, role.Position); err != nil {
+		return fmt.Errorf("error shifting roles: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// ReorderRoles sets the order of every role except @everyone, given from lowest to highest.
+// Only roles below the actor's highest role may change position.
+func (s *RoleService) ReorderRoles(serverID, actorID string, roleIDs []string) ([]models.Role, error) {
+	roles, err := s.ListRoles(serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	current := make(map[string]models.Role)
+	for _, role := range roles {
+		if !role.IsDefault {
+			current[role.ID] = role
+		}
+	}
+	if len(roleIDs) != len(current) {
+		return nil, ErrInvalidRoleOrder
+	}
+
+	actorPosition, err := s.permissions.HighestRolePosition(serverID, actorID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for i, id := range roleIDs {
+		role, ok := current[id]
+		if !ok || seen[id] {
+			return nil, ErrInvalidRoleOrder
+		}
+		seen[id] = true
+
+		newPosition := i + 1
+		if newPosition != role.Position && (role.Position >= actorPosition || newPosition >= actorPosition) {
+			return nil, ErrRoleHierarchy
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for i, id := range roleIDs {
+		if _, err := tx.Exec("UPDATE server_roles SET position = $1, updated_at = $2 WHERE id = $3", i+1, now, id); err != nil {
+			return nil, fmt.Errorf("error reordering roles: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.ListRoles(serverID)
+}
+
+// SetMemberRoles replaces the roles a member holds. Every role added or removed must
+// be below the actor's highest role, every role added may only carry permissions the
+// actor holds, and the actor must outrank the member unless they are changing their
+// own roles.
+func (s *RoleService) SetMemberRoles(serverID, actorID, targetID string, roleIDs []string) ([]models.Role, error) {
+	var isMember bool
+	err := s.db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)",
+		serverID, targetID,
+	).Scan(&isMember)
+	if err != nil {
+		return nil, err
+	}
+	if !isMember {
+		return nil, ErrNotServerMember
+	}
+
+	if actorID != targetID {
+		outranks, err := s.permissions.Outranks(serverID, actorID, targetID)
+		if err != nil {
+			return nil, err
+		}
+		if !outranks {
+			return nil, ErrRoleHierarchy
+		}
+	}
+
+	held, err := s.GetMemberRoles(serverID, targetID)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool)
+	for _, id := range roleIDs {
+		wanted[id] = true
+	}
+	had := make(map[string]bool)
+	for _, role := range held {
+		had[role.ID] = true
+	}
+
+	// Collect the roles whose assignment changes and check each of them
+	var added, removed []*models.Role
+	for id := range wanted {
+		if had[id] {
+			continue
+		}
+		role, err := s.GetRole(serverID, id)
+		if err != nil {
+			return nil, err
+		}
+		if role.IsDefault {
+			return nil, ErrDefaultRole
+		}
+		added = append(added, role)
+	}
+	for i := range held {
+		if !wanted[held[i].ID] {
+			removed = append(removed, &held[i])
+		}
+	}
+	for _, role := range append(added, removed...) {
+		if err := s.checkRoleBelowActor(serverID, actorID, role); err != nil {
+			return nil, err
+		}
+	}
+	// Roles are created at the bottom, so a role can sit below the actor and still carry
+	// permissions they lack. Assigning it, to anyone, would hand those out.
+	for _, role := range added {
+		if err := s.checkGrant(serverID, actorID, role.Permissions); err != nil {
+			return nil, err
+		}
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	for _, role := range added {
+		if _, err := tx.Exec(
+			"INSERT INTO server_member_roles (server_id, user_id, role_id, assigned_at) VALUES ($1, $2, $3, $4)",
+			serverID, targetID, role.ID, now,
+		); err != nil {
+			return nil, fmt.Errorf("error assigning role: %w", err)
+		}
+	}
+	for _, role := range removed {
+		if _, err := tx.Exec(
+			"DELETE FROM server_member_roles WHERE server_id = $1 AND user_id = $2 AND role_id = $3",
+			serverID, targetID, role.ID,
+		); err != nil {
+			return nil, fmt.Errorf("error removing role: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return s.GetMemberRoles(serverID, targetID)
+}
+
+// GetRole loads a role and checks that it belongs to the server
+func (s *RoleService) GetRole(serverID, roleID string) (*models.Role, error) {
+	if _, err := uuid.Parse(roleID); err != nil {
+		return nil, ErrRoleNotFound
+	}
+	roles, err := s.queryRoles(`
+		SELECT id, server_id, name, color, position, permissions, is_default, created_at, updated_at
+		FROM server_roles
+		WHERE id = $1 AND server_id = $2
+	`, roleID, serverID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		return nil, ErrRoleNotFound
+	}
+	return &roles[0], nil
+}
+
+// checkRoleBelowActor returns ErrRoleHierarchy unless the role is below the actor's highest role.
+// The @everyone role is below everyone.
+func (s *RoleService) checkRoleBelowActor(serverID, actorID string, role *models.Role) error {
+	if role.IsDefault {
+		return nil
+	}
+	position, err := s.permissions.HighestRolePosition(serverID, actorID)
+	if err != nil {
+		return err
+	}
+	if role.Position >= position {
+		return ErrRoleHierarchy
+	}
+	return nil
+}
+
+// checkGrant returns ErrPermissionEscalation if perms includes anything the actor does not hold
+func (s *RoleService) checkGrant(serverID, actorID string, perms models.Permission) error {
+	actorPermissions, err := s.permissions.ServerPermissions(serverID, actorID)
+	if err != nil {
+		return err
+	}
+	if !actorPermissions.Has(perms) {
+		return ErrPermissionEscalation
+	}
+	return nil
+}
+
+// queryRoles runs a query selecting full role rows
+func (s *RoleService) queryRoles(query string, args ...interface{}) ([]models.Role, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var role models.Role
+		var permissions int64
+		if err := rows.Scan(
+			&role.ID, &role.ServerId, &role.Name, &role.Color, &role.Position,
+			&permissions, &role.IsDefault, &role.CreatedAt, &role.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		role.Permissions = models.Permission(permissions)
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
@@ -0,0 +1,100 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"app/models"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSetMemberRolesGrant(t *testing.T) {
+	const (
+		modRoleID = "0c7f3a52-6b1e-4d9a-9f27-3e8d5b1c4a60"
+		newRoleID = "7a2e9c14-3d5f-4b8a-a1c6-9e0f2d7b5c83"
+		memberID  = "member-id"
+	)
+	q := regexp.QuoteMeta
+	roleColumns := []string{"id", "server_id", "name", "color", "position", "permissions", "is_default", "created_at", "updated_at"}
+	roleRow := func(rows *sqlmock.Rows, id, name string, position int, permissions models.Permission) *sqlmock.Rows {
+		now := time.Now()
+		return rows.AddRow(id, testServerID, name, "", position, int64(permissions), false, now, now)
+	}
+	// The actor holds a Mod role at position 3 that may manage roles
+	modPermissions := models.DefaultPermissions | models.PermissionManageRoles
+
+	tests := []struct {
+		name     string
+		targetID string
+		// newRole is the role being assigned, created after Mod and so below it
+		newRole models.Permission
+		wantErr error
+	}{
+		{
+			name:     "mod cannot give themselves a lower role carrying administrator",
+			targetID: testUserID,
+			newRole:  models.PermissionAdministrator,
+			wantErr:  ErrPermissionEscalation,
+		},
+		{
+			name:     "mod cannot give a member permissions they lack",
+			targetID: memberID,
+			newRole:  models.DefaultPermissions | models.PermissionBanMembers,
+			wantErr:  ErrPermissionEscalation,
+		},
+		{
+			name:     "mod may assign a role within their own permissions",
+			targetID: memberID,
+			newRole:  models.DefaultPermissions | models.PermissionManageRoles,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			position := func(userID string, position int) {
+				mock.ExpectQuery(q("MAX(sr.position)")).
+					WithArgs(testServerID, userID).
+					WillReturnRows(sqlmock.NewRows([]string{"owner_id", "position"}).AddRow(testOwnerID, position))
+			}
+
+			mock.ExpectQuery(q("SELECT EXISTS(SELECT 1 FROM server_members WHERE server_id = $1 AND user_id = $2)")).
+				WithArgs(testServerID, tt.targetID).
+				WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+			held := sqlmock.NewRows(roleColumns)
+			if tt.targetID == testUserID {
+				roleRow(held, modRoleID, "Mod", 3, modPermissions)
+			} else {
+				position(testUserID, 3)
+				position(tt.targetID, 0)
+			}
+			mock.ExpectQuery(q("JOIN server_member_roles mr")).WithArgs(testServerID, tt.targetID).WillReturnRows(held)
+			mock.ExpectQuery(q("WHERE id = $1 AND server_id = $2")).
+				WithArgs(newRoleID, testServerID).
+				WillReturnRows(roleRow(sqlmock.NewRows(roleColumns), newRoleID, "New", 1, tt.newRole))
+			position(testUserID, 3)
+			expectServerPermissions(mock, testUserID, true, modPermissions)
+			if tt.wantErr == nil {
+				mock.ExpectBegin()
+				mock.ExpectExec(q("INSERT INTO server_member_roles")).
+					WithArgs(testServerID, tt.targetID, newRoleID, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				mock.ExpectQuery(q("JOIN server_member_roles mr")).
+					WillReturnRows(roleRow(sqlmock.NewRows(roleColumns), newRoleID, "New", 1, tt.newRole))
+			}
+
+			roleIDs := []string{newRoleID}
+			if tt.targetID == testUserID {
+				roleIDs = append(roleIDs, modRoleID)
+			}
+			_, err := NewRoleService(db).SetMemberRoles(testServerID, testUserID, tt.targetID, roleIDs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
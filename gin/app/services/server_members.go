@@ -0,0 +1,105 @@
+package services
+
+import (
+	"fmt"
+
+	"app/models"
+
+	"github.com/lib/pq"
+)
+
+const (
+	defaultMemberLimit = 50
+	maxMemberLimit     = 100
+)
+
+// ListServerMembers returns a page of a server's members ordered by username, with the roles
+// each one holds. When roleId is set only members holding that role are returned.
+// Online status is left for the caller to fill in from the WebSocket hub.
+func (s *ServerService) ListServerMembers(serverId, roleId string, limit, offset int) (*models.MemberListResponse, error) {
+	if limit <= 0 {
+		limit = defaultMemberLimit
+	}
+	limit = min(limit, maxMemberLimit)
+	offset = max(offset, 0)
+
+	response := &models.MemberListResponse{Members: []models.MemberResponse{}, Limit: limit, Offset: offset}
+
+	// Fetch one extra row to tell whether there is another page
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(u.display_name, ''), COALESCE(u.avatar_url, ''), s.owner_id = u.id, sm.joined_at
+		FROM server_members sm
+		JOIN users u ON u.id = sm.user_id
+		JOIN servers s ON s.id = sm.server_id
+		WHERE sm.server_id = $1
+		  AND ($2 = '' OR EXISTS (
+		    SELECT 1 FROM server_member_roles mr
+		    WHERE mr.server_id = sm.server_id AND mr.user_id = sm.user_id AND mr.role_id::text = $2
+		  ))
+		ORDER BY u.username ASC
+		LIMIT $3 OFFSET $4
+	`, serverId, roleId, limit+1, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error listing members: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		member := models.MemberResponse{Roles: []models.Role{}}
+		if err := rows.Scan(&member.UserId, &member.Username, &member.DisplayName, &member.AvatarURL, &member.IsOwner, &member.JoinedAt); err != nil {
+			return nil, err
+		}
+		response.Members = append(response.Members, member)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Members) > limit {
+		response.Members = response.Members[:limit]
+		response.HasMore = true
+	}
+	if len(response.Members) == 0 {
+		return response, nil
+	}
+
+	// Load the roles of the whole page in one query
+	index := make(map[string]int, len(response.Members))
+	userIds := make([]string, len(response.Members))
+	for i, member := range response.Members {
+		index[member.UserId] = i
+		userIds[i] = member.UserId
+	}
+
+	roleRows, err := s.db.Query(`
+		SELECT mr.user_id, sr.id, sr.server_id, sr.name, sr.color, sr.position, sr.permissions, sr.is_default, sr.created_at, sr.updated_at
+		FROM server_member_roles mr
+		JOIN server_roles sr ON sr.id = mr.role_id
+		WHERE mr.server_id = $1 AND mr.user_id::text = ANY($2)
+		ORDER BY sr.position DESC
+	`, serverId, pq.Array(userIds))
+	if err != nil {
+		return nil, fmt.Errorf("error listing member roles: %w", err)
+	}
+	defer roleRows.Close()
+
+	for roleRows.Next() {
+		var userId string
+		var role models.Role
+		var permissions int64
+		if err := roleRows.Scan(
+			&userId, &role.ID, &role.ServerId, &role.Name, &role.Color, &role.Position,
+			&permissions, &role.IsDefault, &role.CreatedAt, &role.UpdatedAt,
+		); err != nil {
+			return nil, err
+		}
+		role.Permissions = models.Permission(permissions)
+		i := index[userId]
+		response.Members[i].Roles = append(response.Members[i].Roles, role)
+	}
+	if err := roleRows.Err(); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
@@ -2,52 +2,77 @@ package services
 
 import (
 	"database/sql"
-	"fmt"
+	"math"
 	"time"
 
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+
 	"app/models"
 )
 
 // ServerService handles server-related business logic
 type ServerService struct {
-	db *sql.DB
+	db          *sql.DB
+	permissions *PermissionResolver
 }
 
 // NewServerService creates a new server service
 func NewServerService(db *sql.DB) *ServerService {
 	return &ServerService{
-		db: db,
+		db:          db,
+		permissions: NewPermissionResolver(db),
 	}
 }
 
-// CreateServer creates a new server
+// CreateServer creates a new server with its @everyone role
 func (s *ServerService) CreateServer(server models.Server) error {
-	_, err := s.db.Exec(
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		"INSERT INTO servers (id, name, description, owner_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)",
 		server.ID, server.Name, server.Description, server.OwnerId, server.CreatedAt, server.UpdatedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO server_roles (id, server_id, name, position, permissions, is_default, created_at, updated_at) VALUES ($1, $2, $3, 0, $4, TRUE, $5, $5)",
+		uuid.New().String(), server.ID, models.DefaultRoleName, int64(models.DefaultPermissions), server.CreatedAt,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
 // CreateChannel creates a new channel in a server
-func (s *ServerService) CreateChannel(channel models.Channel) error {
-	if channel.CategoryId == "" {
-		// If no category ID is provided, set it to NULL in the database
-		_, err := s.db.Exec(
-			"INSERT INTO channels (id, server_id, name, description, is_private, created_at, updated_at) VALUES ($1::uuid, $2::uuid, $3, $4, $5, $6, $7)",
-			channel.ID, channel.ServerId, channel.Name, channel.Description,
-			channel.IsPrivate, channel.CreatedAt, channel.UpdatedAt,
-		)
-		return err
-	} else {
-		// If category ID is provided, include it in the query
-		_, err := s.db.Exec(
-			"INSERT INTO channels (id, server_id, category_id, name, description, is_private, created_at, updated_at) VALUES ($1::uuid, $2::uuid, $3::uuid, $4, $5, $6, $7, $8)",
-			channel.ID, channel.ServerId, channel.CategoryId, channel.Name,
-			channel.Description, channel.IsPrivate, channel.CreatedAt, channel.UpdatedAt,
-		)
-		return err
+func (s *ServerService) CreateChannel(channel *models.Channel) error {
+	var categoryId interface{}
+	if channel.CategoryId != "" {
+		categoryId = channel.CategoryId
 	}
+	if channel.Mode == "" {
+		channel.Mode = models.ChannelModeNormal
+	}
+
+	// New channels go to the end of their category; a NULL category means uncategorized
+	return s.db.QueryRow(`
+		INSERT INTO channels (id, server_id, category_id, name, description, is_private, mode, slowmode_seconds, position, created_at, updated_at)
+		VALUES ($1::uuid, $2::uuid, $3::uuid, $4, $5, $6, $7, $8,
+		        (SELECT COALESCE(MAX(position) + 1, 0) FROM channels WHERE server_id = $2::uuid AND category_id IS NOT DISTINCT FROM $3::uuid),
+		        $9, $10)
+		RETURNING position
+	`,
+		channel.ID, channel.ServerId, categoryId, channel.Name,
+		channel.Description, channel.IsPrivate, channel.Mode, channel.Slowmode, channel.CreatedAt, channel.UpdatedAt,
+	).Scan(&channel.Position)
 }
 
 // AddServerMember adds a user to a server
@@ -61,17 +86,24 @@ func (s *ServerService) AddServerMember(member models.ServerMember) error {
 
 // AddChannelMember adds a user to a private channel
 func (s *ServerService) AddChannelMember(member models.ChannelMember) error {
-	_, err := s.db.Exec(
-		"INSERT INTO channel_members (id, channel_id, user_id, added_at) VALUES ($1, $2, $3, $4)",
+	result, err := s.db.Exec(
+		"INSERT INTO channel_members (id, channel_id, user_id, added_at) VALUES ($1, $2, $3, $4) ON CONFLICT (channel_id, user_id) DO NOTHING",
 		member.ID, member.ChannelId, member.UserId, member.AddedAt,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrAlreadyChannelMember
+	}
+	return nil
 }
 
 // GetUserServers returns all servers a user is a member of
 func (s *ServerService) GetUserServers(userId string) ([]models.ServerResponse, error) {
 	rows, err := s.db.Query(`
-		SELECT s.id, s.name, s.description, s.owner_id, s.created_at, 
+		SELECT s.id, s.name, s.description, s.owner_id, COALESCE(s.icon_url, ''), s.allow_direct_join,
+		       s.discoverable, s.discovery_category, s.tags, s.created_at,
 		       (SELECT COUNT(*) FROM server_members WHERE server_id = s.id) as member_count
 		FROM servers s
 		JOIN server_members sm ON s.id = sm.server_id
@@ -88,7 +120,8 @@ func (s *ServerService) GetUserServers(userId string) ([]models.ServerResponse,
 		var server models.ServerResponse
 		if err := rows.Scan(
 			&server.ID, &server.Name, &server.Description, &server.OwnerId,
-			&server.CreatedAt, &server.MemberCount,
+			&server.IconURL, &server.AllowDirectJoin, &server.Discoverable, &server.DiscoveryCategory,
+			pq.Array(&server.Tags), &server.CreatedAt, &server.MemberCount,
 		); err != nil {
 			return nil, err
 		}
@@ -100,15 +133,18 @@ func (s *ServerService) GetUserServers(userId string) ([]models.ServerResponse,
 
 // GetServerChannels returns all channels in a server that a user has access to
 func (s *ServerService) GetServerChannels(serverId, userId string) ([]models.ChannelResponse, error) {
+	// Only channels the user can view, after overwrites, are returned
+	permissions, err := s.permissions.ServerChannelPermissions(serverId, userId)
+	if err != nil {
+		return nil, err
+	}
+
 	rows, err := s.db.Query(`
-		SELECT c.id, c.server_id, c.category_id, c.name, c.description, c.is_private, c.created_at
+		SELECT c.id, c.server_id, c.category_id, c.name, c.description, c.is_private, c.mode, c.slowmode_seconds, c.position, c.created_at
 		FROM channels c
-		WHERE c.server_id = $1::uuid AND (
-			c.is_private = false OR 
-			EXISTS (SELECT 1 FROM channel_members cm WHERE cm.channel_id = c.id AND cm.user_id = $2::uuid)
-		)
-		ORDER BY c.name ASC
-	`, serverId, userId)
+		WHERE c.server_id = $1::uuid
+		ORDER BY c.position ASC, c.name ASC
+	`, serverId)
 	if err != nil {
 		return nil, err
 	}
@@ -121,11 +157,15 @@ func (s *ServerService) GetServerChannels(serverId, userId string) ([]models.Cha
 
 		if err := rows.Scan(
 			&channel.ID, &channel.ServerId, &categoryId, &channel.Name, &channel.Description,
-			&channel.IsPrivate, &channel.CreatedAt,
+			&channel.IsPrivate, &channel.Mode, &channel.Slowmode, &channel.Position, &channel.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
 
+		if !permissions[channel.ID].Has(models.PermissionViewChannels) {
+			continue
+		}
+
 		// Convert NullString to string
 		if categoryId.Valid {
 			channel.CategoryId = categoryId.String
@@ -149,20 +189,58 @@ func (s *ServerService) IsServerMember(serverId, userId string) (bool, error) {
 	return exists, err
 }
 
-// HasChannelManagementPermission checks if a user has permission to manage channels
-func (s *ServerService) HasChannelManagementPermission(serverId, userId string) (bool, error) {
-	var role string
+// IsBanned checks if a user is banned from a server
+func (s *ServerService) IsBanned(serverId, userId string) (bool, error) {
+	return isBanned(s.db, serverId, userId)
+}
+
+// AllowsDirectJoin checks if a server can be joined by ID without an invite
+func (s *ServerService) AllowsDirectJoin(serverId string) (bool, error) {
+	var allow bool
 	err := s.db.QueryRow(
-		"SELECT role FROM server_members WHERE server_id = $1 AND user_id = $2",
-		serverId, userId,
-	).Scan(&role)
+		"SELECT allow_direct_join FROM servers WHERE id = $1",
+		serverId,
+	).Scan(&allow)
+	return allow, err
+}
+
+// HasServerPermission checks if a user holds a permission in a server
+func (s *ServerService) HasServerPermission(serverId, userId string, perm models.Permission) (bool, error) {
+	return s.permissions.HasServerPermission(serverId, userId, perm)
+}
+
+// HasChannelPermission checks if a user holds a permission in a channel
+func (s *ServerService) HasChannelPermission(channelId, userId string, perm models.Permission) (bool, error) {
+	return s.permissions.HasChannelPermission(channelId, userId, perm)
+}
+
+// GetChannelState returns the channel's mode and whether the user may post in it
+func (s *ServerService) GetChannelState(channelId, userId string) (models.ChannelState, error) {
+	channel, err := s.GetChannelByID(channelId)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
-		}
-		return false, err
+		return models.ChannelState{}, err
 	}
-	return role == "owner", nil
+	permissions, err := s.permissions.ChannelPermissions(channelId, userId)
+	if err != nil {
+		return models.ChannelState{}, err
+	}
+	cooldown, err := s.SlowmodeCooldown(channelId, userId)
+	if err != nil {
+		return models.ChannelState{}, err
+	}
+	return models.ChannelState{
+		ChannelId:      channelId,
+		Mode:           channel.Mode,
+		CanSend:        permissions.Has(models.PermissionSendMessages),
+		CanAttachFiles: permissions.Has(models.PermissionSendMessages | models.PermissionAttachFiles),
+		Slowmode:       channel.Slowmode,
+		Cooldown:       int(math.Ceil(cooldown.Seconds())),
+	}, nil
+}
+
+// GetServerPermissions returns a user's permissions in a server
+func (s *ServerService) GetServerPermissions(serverId, userId string) (models.Permission, error) {
+	return s.permissions.ServerPermissions(serverId, userId)
 }
 
 // IsChannelPrivate checks if a channel is private
@@ -187,44 +265,7 @@ func (s *ServerService) GetServerIdByChannelId(channelId string) (string, error)
 
 // HasChannelAccess checks if a user has access to a channel
 func (s *ServerService) HasChannelAccess(channelId, userId string) (bool, error) {
-	// Get the server ID for the channel
-	var serverId string
-	err := s.db.QueryRow(
-		"SELECT server_id FROM channels WHERE id = $1",
-		channelId,
-	).Scan(&serverId)
-	if err != nil {
-		return false, err
-	}
-
-	// Check if user is a member of the server
-	isMember, err := s.IsServerMember(serverId, userId)
-	if err != nil || !isMember {
-		return false, err
-	}
-
-	// Check if the channel is private
-	var isPrivate bool
-	err = s.db.QueryRow(
-		"SELECT is_private FROM channels WHERE id = $1",
-		channelId,
-	).Scan(&isPrivate)
-	if err != nil {
-		return false, err
-	}
-
-	// If the channel is not private, the user has access
-	if !isPrivate {
-		return true, nil
-	}
-
-	// If the channel is private, check if the user is a member of the channel
-	var exists bool
-	err = s.db.QueryRow(
-		"SELECT EXISTS(SELECT 1 FROM channel_members WHERE channel_id = $1 AND user_id = $2)",
-		channelId, userId,
-	).Scan(&exists)
-	return exists, err
+	return s.permissions.HasChannelPermission(channelId, userId, models.PermissionViewChannels)
 }
 
 // CreateCategory creates a new category in a server
@@ -265,15 +306,21 @@ func (s *ServerService) GetServerCategories(serverId string) ([]models.CategoryR
 
 // GetCategoryChannels returns all channels in a category
 func (s *ServerService) GetCategoryChannels(categoryId, userId string) ([]models.ChannelResponse, error) {
+	serverId, err := s.GetServerIdByCategoryId(categoryId)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := s.permissions.ServerChannelPermissions(serverId, userId)
+	if err != nil {
+		return nil, err
+	}
+
 	rows, err := s.db.Query(`
-		SELECT c.id, c.server_id, c.category_id, c.name, c.description, c.is_private, c.created_at
+		SELECT c.id, c.server_id, c.category_id, c.name, c.description, c.is_private, c.mode, c.slowmode_seconds, c.position, c.created_at
 		FROM channels c
-		WHERE c.category_id = $1::uuid AND (
-			c.is_private = false OR 
-			EXISTS (SELECT 1 FROM channel_members cm WHERE cm.channel_id = c.id AND cm.user_id = $2::uuid)
-		)
-		ORDER BY c.name ASC
-	`, categoryId, userId)
+		WHERE c.category_id = $1::uuid
+		ORDER BY c.position ASC, c.name ASC
+	`, categoryId)
 	if err != nil {
 		return nil, err
 	}
@@ -286,11 +333,15 @@ func (s *ServerService) GetCategoryChannels(categoryId, userId string) ([]models
 
 		if err := rows.Scan(
 			&channel.ID, &channel.ServerId, &categoryId, &channel.Name, &channel.Description,
-			&channel.IsPrivate, &channel.CreatedAt,
+			&channel.IsPrivate, &channel.Mode, &channel.Slowmode, &channel.Position, &channel.CreatedAt,
 		); err != nil {
 			return nil, err
 		}
 
+		if !permissions[channel.ID].Has(models.PermissionViewChannels) {
+			continue
+		}
+
 		// Convert NullString to string
 		if categoryId.Valid {
 			channel.CategoryId = categoryId.String
@@ -307,7 +358,11 @@ func (s *ServerService) GetCategoryChannels(categoryId, userId string) ([]models
 // UpdateChannelCategory updates a channel's category
 func (s *ServerService) UpdateChannelCategory(channelId, categoryId string) error {
 	_, err := s.db.Exec(
-		"UPDATE channels SET category_id = $1::uuid, updated_at = $2 WHERE id = $3::uuid",
+		`UPDATE channels SET
+			category_id = $1::uuid,
+			position = (SELECT COALESCE(MAX(position) + 1, 0) FROM channels WHERE category_id = $1::uuid),
+			updated_at = $2
+		WHERE id = $3::uuid`,
 		categoryId, time.Now(), channelId,
 	)
 	return err
@@ -323,26 +378,6 @@ func (s *ServerService) GetServerIdByCategoryId(categoryId string) (string, erro
 	return serverId, err
 }
 
-// IsUserServerOwnerByChannelId checks if the user is the owner of the server that contains the channel
-func (s *ServerService) IsUserServerOwnerByChannelId(channelId, userId string) (bool, error) {
-	var ownerId string
-	err := s.db.QueryRow(`
-		SELECT s.owner_id 
-		FROM servers s
-		JOIN channels c ON s.id = c.server_id
-		WHERE c.id = $1
-	`, channelId).Scan(&ownerId)
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return false, nil
-		}
-		return false, err
-	}
-
-	return ownerId == userId, nil
-}
-
 // DeleteChannel deletes a channel
 func (s *ServerService) DeleteChannel(channelId string) error {
 	// Start a transaction
@@ -378,69 +413,21 @@ func (s *ServerService) DeleteChannel(channelId string) error {
 func (s *ServerService) GetChannelByID(channelID string) (models.Channel, error) {
 	var channel models.Channel
 	err := s.db.QueryRow(
-		"SELECT id, server_id, category_id, name, description, is_private, created_at, updated_at FROM channels WHERE id = $1",
+		"SELECT id, server_id, COALESCE(category_id::text, ''), name, COALESCE(description, ''), is_private, mode, slowmode_seconds, position, created_at, updated_at FROM channels WHERE id = $1",
 		channelID,
 	).Scan(
-		&channel.ID, &channel.ServerId, &channel.CategoryId, &channel.Name,
-		&channel.Description, &channel.IsPrivate, &channel.CreatedAt, &channel.UpdatedAt,
+		&channel.ID, &channel.ServerId, &channel.CategoryId, &channel.Name, &channel.Description,
+		&channel.IsPrivate, &channel.Mode, &channel.Slowmode, &channel.Position, &channel.CreatedAt, &channel.UpdatedAt,
 	)
 	return channel, err
 }
 
 // UserHasAccessToChannel checks if a user has access to a channel
 func (s *ServerService) UserHasAccessToChannel(userID, channelID string) (bool, error) {
-	// First check if the channel is private
-	isPrivate, err := s.IsChannelPrivate(channelID)
-	if err != nil {
-		return false, err
-	}
-
-	// If the channel is not private, check if the user is a member of the server
-	if !isPrivate {
-		serverID, err := s.GetServerIdByChannelId(channelID)
-		if err != nil {
-			return false, err
-		}
-		return s.IsServerMember(serverID, userID)
-	}
-
-	// If the channel is private, check if the user is a member of the channel
-	var count int
-	err = s.db.QueryRow(
-		"SELECT COUNT(*) FROM channel_members WHERE channel_id = $1 AND user_id = $2",
-		channelID, userID,
-	).Scan(&count)
-	if err != nil {
-		return false, err
-	}
-	return count > 0, nil
+	return s.HasChannelAccess(channelID, userID)
 }
 
 // UserHasChannelAccess はユーザーが指定されたチャンネルにアクセスできるかどうかを確認する
 func (s *ServerService) UserHasChannelAccess(userID string, channelID string) (bool, error) {
-	// チャンネルの情報を取得
-	query := `
-		SELECT c.id, c.server_id
-		FROM channels c
-		WHERE c.id = $1
-	`
-	var serverID string
-	err := s.db.QueryRow(query, channelID).Scan(&channelID, &serverID)
-	if err != nil {
-		return false, fmt.Errorf("チャンネル情報の取得に失敗しました: %w", err)
-	}
-
-	// ユーザーがサーバーのメンバーかどうかを確認
-	query = `
-		SELECT COUNT(*)
-		FROM server_members
-		WHERE server_id = $1 AND user_id = $2
-	`
-	var count int
-	err = s.db.QueryRow(query, serverID, userID).Scan(&count)
-	if err != nil {
-		return false, fmt.Errorf("サーバーメンバーシップの確認に失敗しました: %w", err)
-	}
-
-	return count > 0, nil
+	return s.HasChannelAccess(channelID, userID)
 }
@@ -0,0 +1,246 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	serverIconDir     = "./uploads/server-icons"
+	serverIconURLPath = "/uploads/server-icons/"
+	maxServerIconSize = 5 << 20
+
+	// adminRolePermissions are held by the Admin role given to a server's previous owner
+	adminRolePermissions = models.AllPermissions &^ models.PermissionAdministrator
+)
+
+var (
+	// ErrServerNotFound is returned when a server does not exist
+	ErrServerNotFound = errors.New("server not found")
+
+	// ErrNotServerOwner is returned when an owner-only action is attempted by someone else
+	ErrNotServerOwner = errors.New("only the server owner can do this")
+
+	// ErrInvalidServerIcon is returned when an icon is not an image or is too large
+	ErrInvalidServerIcon = errors.New("icon must be an image of at most 5MB")
+)
+
+// GetServer returns a server's settings and member count
+func (s *ServerService) GetServer(serverId string) (*models.ServerResponse, error) {
+	var server models.ServerResponse
+	err := s.db.QueryRow(`
+		SELECT s.id, s.name, COALESCE(s.description, ''), s.owner_id, COALESCE(s.icon_url, ''), s.allow_direct_join,
+		       s.discoverable, s.discovery_category, s.tags, s.created_at,
+		       (SELECT COUNT(*) FROM server_members WHERE server_id = s.id)
+		FROM servers s
+		WHERE s.id = $1
+	`, serverId).Scan(
+		&server.ID, &server.Name, &server.Description, &server.OwnerId, &server.IconURL, &server.AllowDirectJoin,
+		&server.Discoverable, &server.DiscoveryCategory, pq.Array(&server.Tags), &server.CreatedAt, &server.MemberCount,
+	)
+	if err == sql.ErrNoRows {
+		return nil, ErrServerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &server, nil
+}
+
+// UpdateServer changes the fields of a server present in the request. Tags are stored in lower case.
+func (s *ServerService) UpdateServer(serverId string, req models.UpdateServerRequest) (*models.ServerResponse, error) {
+	var tags interface{}
+	if req.Tags != nil {
+		normalized := make([]string, len(*req.Tags))
+		for i, tag := range *req.Tags {
+			normalized[i] = strings.ToLower(tag)
+		}
+		tags = pq.Array(normalized)
+	}
+
+	result, err := s.db.Exec(`
+		UPDATE servers SET
+			name = COALESCE($1, name),
+			description = COALESCE($2, description),
+			allow_direct_join = COALESCE($3, allow_direct_join),
+			discoverable = COALESCE($4, discoverable),
+			discovery_category = COALESCE($5, discovery_category),
+			tags = COALESCE($6::text[], tags),
+			updated_at = $7
+		WHERE id = $8
+	`, req.Name, req.Description, req.AllowDirectJoin, req.Discoverable, req.DiscoveryCategory, tags, time.Now(), serverId)
+	if err != nil {
+		return nil, fmt.Errorf("error updating server: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, ErrServerNotFound
+	}
+	return s.GetServer(serverId)
+}
+
+// UpdateServerIcon stores a new icon image for a server and removes the previous one
+func (s *ServerService) UpdateServerIcon(serverId string, file *multipart.FileHeader) (*models.ServerResponse, error) {
+	if getFileType(strings.ToLower(file.Filename)) != "image" || file.Size > maxServerIconSize {
+		return nil, ErrInvalidServerIcon
+	}
+
+	var previous string
+	err := s.db.QueryRow("SELECT COALESCE(icon_url, '') FROM servers WHERE id = $1", serverId).Scan(&previous)
+	if err == sql.ErrNoRows {
+		return nil, ErrServerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	_, filePath, err := saveUpload(file, serverIconDir)
+	if err != nil {
+		return nil, err
+	}
+
+	iconURL := serverIconURLPath + filepath.Base(filePath)
+	if _, err := s.db.Exec("UPDATE servers SET icon_url = $1, updated_at = $2 WHERE id = $3", iconURL, time.Now(), serverId); err != nil {
+		os.Remove(filePath)
+		return nil, fmt.Errorf("error updating icon: %w", err)
+	}
+
+	if strings.HasPrefix(previous, serverIconURLPath) {
+		os.Remove(filepath.Join(serverIconDir, filepath.Base(previous)))
+	}
+
+	return s.GetServer(serverId)
+}
+
+// TransferOwnership hands a server to another member. The previous owner stays on as a
+// member with their roles and an Admin role.
+func (s *ServerService) TransferOwnership(serverId, ownerId, newOwnerId string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the server row so concurrent transfers can't both succeed
+	var currentOwner string
+	err = tx.QueryRow("SELECT owner_id FROM servers WHERE id = $1 FOR UPDATE", serverId).Scan(&currentOwner)
+	if err == sql.ErrNoRows {
+		return ErrServerNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if currentOwner != ownerId {
+		return ErrNotServerOwner
+	}
+
+	now := time.Now()
+	result, err := tx.Exec(
+		"UPDATE server_members SET role = 'owner', updated_at = $1 WHERE server_id = $2 AND user_id = $3",
+		now, serverId, newOwnerId,
+	)
+	if err != nil {
+		return fmt.Errorf("error transferring server: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrNotServerMember
+	}
+	if _, err := tx.Exec(
+		"UPDATE server_members SET role = 'member', updated_at = $1 WHERE server_id = $2 AND user_id = $3",
+		now, serverId, ownerId,
+	); err != nil {
+		return fmt.Errorf("error transferring server: %w", err)
+	}
+	if err := grantAdminRole(tx, serverId, ownerId, now); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("UPDATE servers SET owner_id = $1, updated_at = $2 WHERE id = $3", newOwnerId, now, serverId); err != nil {
+		return fmt.Errorf("error transferring server: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// grantAdminRole gives the previous owner of a server an Admin role on top of the roles they
+// already hold, so handing a server over does not leave them a bare member. An existing role
+// with every permission but administrator is reused; otherwise one is created at the top.
+func grantAdminRole(tx *sql.Tx, serverId, userId string, now time.Time) error {
+	var roleId string
+	err := tx.QueryRow(`
+		SELECT id FROM server_roles
+		WHERE server_id = $1 AND NOT is_default AND permissions & $2 = $2
+		ORDER BY position DESC
+		LIMIT 1
+	`, serverId, int64(adminRolePermissions)).Scan(&roleId)
+	if err == sql.ErrNoRows {
+		roleId = uuid.New().String()
+		_, err = tx.Exec(`
+			INSERT INTO server_roles (id, server_id, name, color, position, permissions, is_default, created_at, updated_at)
+			SELECT $1, $2, 'Admin', '', COALESCE(MAX(position), 0) + 1, $3, FALSE, $4, $4
+			FROM server_roles WHERE server_id = $2
+		`, roleId, serverId, int64(adminRolePermissions), now)
+	}
+	if err != nil {
+		return fmt.Errorf("error granting admin role: %w", err)
+	}
+
+	if _, err := tx.Exec(
+		"INSERT INTO server_member_roles (server_id, user_id, role_id, assigned_at) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		serverId, userId, roleId, now,
+	); err != nil {
+		return fmt.Errorf("error granting admin role: %w", err)
+	}
+	return nil
+}
+
+// DeleteServer deletes a server with its categories, channels, messages and attachments,
+// then removes the server's files from disk. It returns the IDs of the deleted channels.
+func (s *ServerService) DeleteServer(serverId string) ([]string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	files, err := serverFilePaths(tx, serverId)
+	if err == sql.ErrNoRows {
+		return nil, ErrServerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	channelIds, err := serverChannelIDs(tx, serverId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Categories, channels, members, roles, invites and bans cascade from the server;
+	// messages and attachments cascade from the channels
+	if _, err := tx.Exec("DELETE FROM servers WHERE id = $1", serverId); err != nil {
+		return nil, fmt.Errorf("error deleting server: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+			log.Printf("error removing file %s: %v", file, err)
+		}
+	}
+
+	return channelIds, nil
+}
@@ -0,0 +1,433 @@
+package services
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"app/models"
+
+	"github.com/google/uuid"
+)
+
+// ErrTemplateNotFound is returned when a template does not exist or belongs to someone else
+var ErrTemplateNotFound = errors.New("template not found")
+
+// intPtr returns a pointer to i, for category indexes in presets
+func intPtr(i int) *int {
+	return &i
+}
+
+// serverPresets are the built-in templates, keyed by preset ID
+var serverPresets = map[string]models.ServerTemplate{
+	"study-group": {
+		Name:        "Study group",
+		Description: "Channels for questions, resources and study sessions",
+		Structure: models.TemplateStructure{
+			EveryonePermissions: models.DefaultPermissions,
+			Roles: []models.TemplateRole{
+				{Name: "Tutor", Color: "#2e86de", Permissions: models.DefaultPermissions | models.PermissionManageMessages | models.PermissionMentionEveryone},
+			},
+			Categories: []models.TemplateCategory{{Name: "Information"}, {Name: "Study"}},
+			Channels: []models.TemplateChannel{
+				{Name: "announcements", Description: "Schedules and important notices", Mode: models.ChannelModeAnnouncement, Category: intPtr(0)},
+				{Name: "resources", Description: "Notes, links and materials", Category: intPtr(0)},
+				{Name: "general", Description: "General discussion", Category: intPtr(1)},
+				{Name: "questions", Description: "Ask and answer questions", Category: intPtr(1)},
+				{Name: "study-sessions", Description: "Plan sessions together", Category: intPtr(1)},
+			},
+		},
+	},
+	"project-team": {
+		Name:        "Project team",
+		Description: "Channels for planning, development and team leads",
+		Structure: models.TemplateStructure{
+			EveryonePermissions: models.DefaultPermissions,
+			Roles: []models.TemplateRole{
+				{Name: "Member", Color: "#10ac84", Permissions: models.DefaultPermissions},
+				{Name: "Lead", Color: "#ee5253", Permissions: models.DefaultPermissions | models.PermissionManageMessages |
+					models.PermissionManageChannels | models.PermissionMentionEveryone | models.PermissionKickMembers},
+			},
+			Categories: []models.TemplateCategory{{Name: "General"}, {Name: "Work"}, {Name: "Leads"}},
+			Channels: []models.TemplateChannel{
+				{Name: "general", Description: "General discussion", Category: intPtr(0)},
+				{Name: "announcements", Description: "Team announcements", Mode: models.ChannelModeAnnouncement, Category: intPtr(0)},
+				{Name: "planning", Description: "Roadmap and tasks", Category: intPtr(1)},
+				{Name: "development", Description: "Implementation details", Category: intPtr(1)},
+				{Name: "reviews", Description: "Review requests and feedback", Category: intPtr(1)},
+				{Name: "leads", Description: "Discussion between team leads", IsPrivate: true, Category: intPtr(2),
+					Overwrites: []models.TemplateOverwrite{{Role: intPtr(1), Allow: models.PermissionViewChannels}}},
+			},
+		},
+	},
+}
+
+// ListTemplates returns the built-in presets followed by the user's saved templates
+func (s *ServerService) ListTemplates(userId string) ([]models.ServerTemplate, error) {
+	keys := make([]string, 0, len(serverPresets))
+	for key := range serverPresets {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	templates := []models.ServerTemplate{}
+	for _, key := range keys {
+		preset := serverPresets[key]
+		preset.ID = key
+		preset.IsPreset = true
+		templates = append(templates, preset)
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, name, description, created_by, COALESCE(source_server_id::text, ''), structure, created_at
+		FROM server_templates
+		WHERE created_by = $1
+		ORDER BY created_at DESC
+	`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		template, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *template)
+	}
+	return templates, rows.Err()
+}
+
+// GetTemplate returns a preset by key or one of the user's saved templates by ID
+func (s *ServerService) GetTemplate(templateId, userId string) (*models.ServerTemplate, error) {
+	if preset, ok := serverPresets[templateId]; ok {
+		preset.ID = templateId
+		preset.IsPreset = true
+		return &preset, nil
+	}
+	if _, err := uuid.Parse(templateId); err != nil {
+		return nil, ErrTemplateNotFound
+	}
+
+	row := s.db.QueryRow(`
+		SELECT id, name, description, created_by, COALESCE(source_server_id::text, ''), structure, created_at
+		FROM server_templates
+		WHERE id = $1 AND created_by = $2
+	`, templateId, userId)
+	template, err := scanTemplate(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrTemplateNotFound
+	}
+	return template, err
+}
+
+// SaveTemplate stores the structure of a server as a template owned by the user. Only the
+// channels the user can view are included.
+func (s *ServerService) SaveTemplate(serverId, userId string, req models.SaveTemplateRequest) (*models.ServerTemplate, error) {
+	structure, err := s.serverStructure(serverId, userId)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(structure)
+	if err != nil {
+		return nil, err
+	}
+
+	template := models.ServerTemplate{
+		ID:             uuid.New().String(),
+		Name:           req.Name,
+		Description:    req.Description,
+		CreatedBy:      userId,
+		SourceServerId: serverId,
+		Structure:      *structure,
+		CreatedAt:      time.Now(),
+	}
+	_, err = s.db.Exec(
+		"INSERT INTO server_templates (id, name, description, created_by, source_server_id, structure, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)",
+		template.ID, template.Name, template.Description, template.CreatedBy, template.SourceServerId, data, template.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error saving template: %w", err)
+	}
+	return &template, nil
+}
+
+// DeleteTemplate deletes one of the user's saved templates
+func (s *ServerService) DeleteTemplate(templateId, userId string) error {
+	if _, err := uuid.Parse(templateId); err != nil {
+		return ErrTemplateNotFound
+	}
+	result, err := s.db.Exec("DELETE FROM server_templates WHERE id = $1 AND created_by = $2", templateId, userId)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrTemplateNotFound
+	}
+	return nil
+}
+
+// CreateServerFromTemplate creates a server with the template's roles, categories, channels and
+// role overwrites and adds the owner as its first member, all in one transaction. The owner is
+// added to every private channel.
+func (s *ServerService) CreateServerFromTemplate(server models.Server, structure models.TemplateStructure) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	now := server.CreatedAt
+	_, err = tx.Exec(
+		"INSERT INTO servers (id, name, description, owner_id, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6)",
+		server.ID, server.Name, server.Description, server.OwnerId, server.CreatedAt, server.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	everyoneRoleId := uuid.New().String()
+	_, err = tx.Exec(
+		"INSERT INTO server_roles (id, server_id, name, position, permissions, is_default, created_at, updated_at) VALUES ($1, $2, $3, 0, $4, TRUE, $5, $5)",
+		everyoneRoleId, server.ID, models.DefaultRoleName, int64(structure.EveryonePermissions&^models.PermissionAdministrator), now,
+	)
+	if err != nil {
+		return err
+	}
+	roleIds := make([]string, len(structure.Roles))
+	for i, role := range structure.Roles {
+		roleIds[i] = uuid.New().String()
+		_, err = tx.Exec(
+			"INSERT INTO server_roles (id, server_id, name, color, position, permissions, is_default, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, FALSE, $7, $7)",
+			roleIds[i], server.ID, role.Name, role.Color, i+1, int64(role.Permissions&models.AllPermissions), now,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating role: %w", err)
+		}
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO server_members (id, server_id, user_id, role, joined_at, updated_at) VALUES ($1, $2, $3, 'owner', $4, $4)",
+		uuid.New().String(), server.ID, server.OwnerId, now,
+	)
+	if err != nil {
+		return fmt.Errorf("error adding owner: %w", err)
+	}
+
+	categoryIds := make([]string, len(structure.Categories))
+	for i, category := range structure.Categories {
+		categoryIds[i] = uuid.New().String()
+		_, err = tx.Exec(
+			"INSERT INTO categories (id, server_id, name, position, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $5)",
+			categoryIds[i], server.ID, category.Name, i, now,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating category: %w", err)
+		}
+	}
+
+	channels := structure.Channels
+	if len(channels) == 0 {
+		channels = []models.TemplateChannel{{Name: "general", Description: "General discussion"}}
+	}
+
+	// Channels keep the template's order within each category
+	positions := make(map[string]int)
+	for _, channel := range channels {
+		var categoryId interface{}
+		key := ""
+		if channel.Category != nil && *channel.Category >= 0 && *channel.Category < len(categoryIds) {
+			categoryId = categoryIds[*channel.Category]
+			key = categoryIds[*channel.Category]
+		}
+		mode := channel.Mode
+		if mode != models.ChannelModeAnnouncement && mode != models.ChannelModeArchived {
+			mode = models.ChannelModeNormal
+		}
+		channelId := uuid.New().String()
+		_, err = tx.Exec(
+			"INSERT INTO channels (id, server_id, category_id, name, description, is_private, mode, slowmode_seconds, position, created_at, updated_at) VALUES ($1, $2, $3::uuid, $4, $5, $6, $7, $8, $9, $10, $10)",
+			channelId, server.ID, categoryId, channel.Name, channel.Description, channel.IsPrivate, mode, min(max(channel.Slowmode, 0), maxSlowmode), positions[key], now,
+		)
+		if err != nil {
+			return fmt.Errorf("error creating channel: %w", err)
+		}
+		positions[key]++
+
+		for _, overwrite := range channel.Overwrites {
+			targetId := everyoneRoleId
+			if overwrite.Role != nil {
+				if *overwrite.Role < 0 || *overwrite.Role >= len(roleIds) {
+					continue
+				}
+				targetId = roleIds[*overwrite.Role]
+			}
+			allow := overwrite.Allow & models.OverwritablePermissions
+			deny := overwrite.Deny & models.OverwritablePermissions &^ allow
+			_, err = tx.Exec(
+				"INSERT INTO channel_overwrites (channel_id, target_type, target_id, allow, deny, updated_at) VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (channel_id, target_type, target_id) DO NOTHING",
+				channelId, models.OverwriteTargetRole, targetId, int64(allow), int64(deny), now,
+			)
+			if err != nil {
+				return fmt.Errorf("error creating channel overwrite: %w", err)
+			}
+		}
+
+		if channel.IsPrivate {
+			_, err = tx.Exec(
+				"INSERT INTO channel_members (id, channel_id, user_id, added_at) VALUES ($1, $2, $3, $4)",
+				uuid.New().String(), channelId, server.OwnerId, now,
+			)
+			if err != nil {
+				return fmt.Errorf("error adding channel member: %w", err)
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// serverStructure reads the roles, categories, channels and role overwrites of a server.
+// Channels the user cannot view are left out.
+func (s *ServerService) serverStructure(serverId, userId string) (*models.TemplateStructure, error) {
+	structure := models.TemplateStructure{
+		Roles:      []models.TemplateRole{},
+		Categories: []models.TemplateCategory{},
+		Channels:   []models.TemplateChannel{},
+	}
+
+	var everyoneRoleId string
+	var everyone int64
+	err := s.db.QueryRow("SELECT id, permissions FROM server_roles WHERE server_id = $1 AND is_default", serverId).Scan(&everyoneRoleId, &everyone)
+	if err == sql.ErrNoRows {
+		return nil, ErrServerNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	structure.EveryonePermissions = models.Permission(everyone)
+
+	rows, err := s.db.Query(
+		"SELECT id, name, color, permissions FROM server_roles WHERE server_id = $1 AND NOT is_default ORDER BY position ASC",
+		serverId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	roleIndex := make(map[string]int)
+	for rows.Next() {
+		var roleId string
+		var role models.TemplateRole
+		var permissions int64
+		if err := rows.Scan(&roleId, &role.Name, &role.Color, &permissions); err != nil {
+			return nil, err
+		}
+		role.Permissions = models.Permission(permissions)
+		roleIndex[roleId] = len(structure.Roles)
+		structure.Roles = append(structure.Roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	categories, err := s.GetServerCategories(serverId)
+	if err != nil {
+		return nil, err
+	}
+	categoryIndex := make(map[string]int, len(categories))
+	for i, category := range categories {
+		categoryIndex[category.ID] = i
+		structure.Categories = append(structure.Categories, models.TemplateCategory{Name: category.Name})
+	}
+
+	overwrites, err := s.templateOverwrites(serverId, everyoneRoleId, roleIndex)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := s.permissions.ServerChannelPermissions(serverId, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	channelRows, err := s.db.Query(`
+		SELECT id, COALESCE(category_id::text, ''), name, COALESCE(description, ''), is_private, mode, slowmode_seconds
+		FROM channels
+		WHERE server_id = $1
+		ORDER BY position ASC, name ASC
+	`, serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer channelRows.Close()
+	for channelRows.Next() {
+		var channelId, categoryId string
+		var channel models.TemplateChannel
+		if err := channelRows.Scan(&channelId, &categoryId, &channel.Name, &channel.Description, &channel.IsPrivate, &channel.Mode, &channel.Slowmode); err != nil {
+			return nil, err
+		}
+		if !permissions[channelId].Has(models.PermissionViewChannels) {
+			continue
+		}
+		if i, ok := categoryIndex[categoryId]; ok {
+			channel.Category = intPtr(i)
+		}
+		channel.Overwrites = overwrites[channelId]
+		structure.Channels = append(structure.Channels, channel)
+	}
+	return &structure, channelRows.Err()
+}
+
+// templateOverwrites reads the role overwrites of a server's channels, keyed by channel ID.
+// Member overwrites are not carried into templates.
+func (s *ServerService) templateOverwrites(serverId, everyoneRoleId string, roleIndex map[string]int) (map[string][]models.TemplateOverwrite, error) {
+	rows, err := s.db.Query(`
+		SELECT o.channel_id, o.target_id, o.allow, o.deny
+		FROM channel_overwrites o
+		JOIN channels c ON c.id = o.channel_id
+		WHERE c.server_id = $1 AND o.target_type = 'role'
+	`, serverId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overwrites := make(map[string][]models.TemplateOverwrite)
+	for rows.Next() {
+		var channelId, targetId string
+		var allow, deny int64
+		if err := rows.Scan(&channelId, &targetId, &allow, &deny); err != nil {
+			return nil, err
+		}
+		overwrite := models.TemplateOverwrite{Allow: models.Permission(allow), Deny: models.Permission(deny)}
+		if targetId != everyoneRoleId {
+			i, ok := roleIndex[targetId]
+			if !ok {
+				continue
+			}
+			overwrite.Role = intPtr(i)
+		}
+		overwrites[channelId] = append(overwrites[channelId], overwrite)
+	}
+	return overwrites, rows.Err()
+}
+
+// scanTemplate reads a saved template row
+func scanTemplate(row interface{ Scan(...interface{}) error }) (*models.ServerTemplate, error) {
+	var template models.ServerTemplate
+	var structure []byte
+	err := row.Scan(
+		&template.ID, &template.Name, &template.Description, &template.CreatedBy,
+		&template.SourceServerId, &structure, &template.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(structure, &template.Structure); err != nil {
+		return nil, fmt.Errorf("error reading template structure: %w", err)
+	}
+	return &template, nil
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"app/models"
+)
+
+// ErrSessionNotFound is returned when revoking a session that does not exist or is already revoked
+var ErrSessionNotFound = errors.New("session not found")
+
+// ListSessions returns the user's active sessions, most recently used first
+func (s *UserService) ListSessions(userID, currentSessionID string) ([]models.Session, error) {
+	rows, err := s.db.Query(`
+		SELECT id, COALESCE(user_agent, ''), COALESCE(ip_address, ''), created_at, COALESCE(last_seen_at, updated_at)
+		FROM user_sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY COALESCE(last_seen_at, updated_at) DESC
+	`, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []models.Session{}
+	for rows.Next() {
+		var session models.Session
+		if err := rows.Scan(&session.ID, &session.UserAgent, &session.IPAddress, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			return nil, err
+		}
+		session.Current = session.ID == currentSessionID
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// RevokeOtherSessions revokes every session of the user except the current one
+// and returns the IDs of the revoked sessions
+func (s *UserService) RevokeOtherSessions(userID, currentSessionID string) ([]string, error) {
+	rows, err := s.db.Query(
+		"UPDATE user_sessions SET revoked_at = $1, updated_at = $1 WHERE user_id = $2 AND id <> $3 AND revoked_at IS NULL RETURNING id",
+		time.Now(), userID, currentSessionID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error revoking sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, id)
+	}
+
+	return sessionIDs, rows.Err()
+}
@@ -0,0 +1,332 @@
+package services
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"app/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+const (
+	// mfaChallengeTTL is how long the partial login token stays valid
+	mfaChallengeTTL = 5 * time.Minute
+
+	// recoveryCodeCount is the number of recovery codes issued at once
+	recoveryCodeCount = 10
+)
+
+var (
+	// ErrInvalidMFAToken is returned when the partial login token is invalid or expired
+	ErrInvalidMFAToken = errors.New("invalid or expired MFA token")
+
+	// ErrInvalidTOTPCode is returned when a TOTP or recovery code does not match
+	ErrInvalidTOTPCode = errors.New("invalid authentication code")
+
+	// ErrTOTPAlreadyEnabled is returned when setting up TOTP for a user who already has it
+	ErrTOTPAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+
+	// ErrTOTPNotEnabled is returned for operations that require TOTP to be enabled
+	ErrTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+)
+
+// SetupTOTP generates a pending TOTP secret. It only takes effect once EnableTOTP confirms a code.
+func (s *UserService) SetupTOTP(userID string) (*models.TOTPSetupResponse, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error generating TOTP secret: %w", err)
+	}
+
+	_, err = s.db.Exec(
+		"UPDATE users SET totp_secret = $1, totp_last_counter = NULL, updated_at = $2 WHERE id = $3",
+		secret, time.Now(), userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error saving TOTP secret: %w", err)
+	}
+
+	return &models.TOTPSetupResponse{
+		Secret:          secret,
+		ProvisioningURI: totpProvisioningURI(totpIssuer(), user.Email, secret),
+	}, nil
+}
+
+// EnableTOTP confirms the pending secret with a code and returns a fresh set of recovery codes
+func (s *UserService) EnableTOTP(userID, code string) ([]string, error) {
+	var secret sql.NullString
+	var enabled bool
+	err := s.db.QueryRow(
+		"SELECT totp_secret, totp_enabled_at IS NOT NULL FROM users WHERE id = $1",
+		userID,
+	).Scan(&secret, &enabled)
+	if err != nil {
+		return nil, fmt.Errorf("error finding user: %w", err)
+	}
+	if enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	if !secret.Valid {
+		return nil, errors.New("two-factor authentication has not been set up")
+	}
+
+	counter, ok := validateTOTP(secret.String, code, time.Now(), -1)
+	if !ok {
+		return nil, ErrInvalidTOTPCode
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	_, err = tx.Exec(
+		"UPDATE users SET totp_enabled_at = $1, totp_last_counter = $2, updated_at = $1 WHERE id = $3",
+		now, counter, userID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error enabling TOTP: %w", err)
+	}
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableTOTP turns off two-factor authentication after checking the password (or a
+// reauthentication token) and a second factor
+func (s *UserService) DisableTOTP(userID, password, reauthToken, code, recoveryCode string) error {
+	var hashedPassword string
+	err := s.db.QueryRow("SELECT password FROM users WHERE id = $1", userID).Scan(&hashedPassword)
+	if err != nil {
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if err := s.confirmIdentity(userID, hashedPassword, password, reauthToken); err != nil {
+		return err
+	}
+
+	if err := s.verifySecondFactor(userID, code, recoveryCode); err != nil {
+		return err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"UPDATE users SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_counter = NULL, updated_at = $1 WHERE id = $2",
+		time.Now(), userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error disabling TOTP: %w", err)
+	}
+	if _, err = tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after checking a TOTP code
+func (s *UserService) RegenerateRecoveryCodes(userID, code string) ([]string, error) {
+	if err := s.verifySecondFactor(userID, code, ""); err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	codes, err := replaceRecoveryCodes(tx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteTOTPLogin finishes a two-step login and starts a session.
+// Wrong codes count towards the same lockout as wrong passwords.
+func (s *UserService) CompleteTOTPLogin(mfaToken, code, recoveryCode string, client models.ClientInfo) (*models.UserResponse, error) {
+	claims, err := s.parseToken(mfaToken, tokenPurposeMFA)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+	userID, _ := claims["userID"].(string)
+
+	if err := s.checkLockout(userID); err != nil {
+		return nil, err
+	}
+
+	if err := s.verifySecondFactor(userID, code, recoveryCode); err != nil {
+		if errors.Is(err, ErrInvalidTOTPCode) {
+			s.recordLoginFailure(userID, "", client, "invalid second factor")
+		}
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	s.resetLoginFailures(userID)
+	return s.startSession(user, client)
+}
+
+// createMFAChallenge issues the short-lived partial token for the second login step
+func (s *UserService) createMFAChallenge(userID string) (*models.MFAChallengeResponse, error) {
+	expiresAt := time.Now().Add(mfaChallengeTTL)
+	token, err := s.signToken(tokenPurposeMFA, jwt.MapClaims{
+		"userID": userID,
+		"exp":    expiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error generating MFA token: %w", err)
+	}
+
+	return &models.MFAChallengeResponse{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresAt:   expiresAt,
+	}, nil
+}
+
+// verifySecondFactor checks a TOTP code, or consumes a recovery code if no TOTP code is given
+func (s *UserService) verifySecondFactor(userID, code, recoveryCode string) error {
+	var secret sql.NullString
+	var lastCounter sql.NullInt64
+	var enabled bool
+	err := s.db.QueryRow(
+		"SELECT totp_secret, totp_last_counter, totp_enabled_at IS NOT NULL FROM users WHERE id = $1",
+		userID,
+	).Scan(&secret, &lastCounter, &enabled)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrInvalidMFAToken
+		}
+		return fmt.Errorf("error finding user: %w", err)
+	}
+	if !enabled || !secret.Valid {
+		return ErrTOTPNotEnabled
+	}
+
+	if code != "" {
+		last := int64(-1)
+		if lastCounter.Valid {
+			last = lastCounter.Int64
+		}
+		counter, ok := validateTOTP(secret.String, code, time.Now(), last)
+		if !ok {
+			return ErrInvalidTOTPCode
+		}
+
+		// Record the step so the same code cannot be replayed
+		result, err := s.db.Exec(
+			"UPDATE users SET totp_last_counter = $1 WHERE id = $2 AND (totp_last_counter IS NULL OR totp_last_counter < $1)",
+			counter, userID,
+		)
+		if err != nil {
+			return fmt.Errorf("error updating TOTP counter: %w", err)
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	if recoveryCode != "" {
+		result, err := s.db.Exec(
+			"UPDATE user_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash = $3 AND used_at IS NULL",
+			time.Now(), userID, hashToken(normalizeRecoveryCode(recoveryCode)),
+		)
+		if err != nil {
+			return fmt.Errorf("error consuming recovery code: %w", err)
+		}
+		if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+			return ErrInvalidTOTPCode
+		}
+		return nil
+	}
+
+	return ErrInvalidTOTPCode
+}
+
+// replaceRecoveryCodes deletes the user's recovery codes and stores a new set
+func replaceRecoveryCodes(tx *sql.Tx, userID string) ([]string, error) {
+	if _, err := tx.Exec("DELETE FROM user_recovery_codes WHERE user_id = $1", userID); err != nil {
+		return nil, fmt.Errorf("error deleting recovery codes: %w", err)
+	}
+
+	now := time.Now()
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, fmt.Errorf("error generating recovery code: %w", err)
+		}
+
+		_, err = tx.Exec(
+			"INSERT INTO user_recovery_codes (id, user_id, code_hash, created_at) VALUES ($1, $2, $3, $4)",
+			uuid.New().String(), userID, hashToken(normalizeRecoveryCode(code)), now,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error saving recovery code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code formatted as xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(totpEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode strips separators and case so users can type codes loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// totpIssuer is the issuer label shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "Chat App"
+}
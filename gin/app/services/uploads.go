@@ -0,0 +1,46 @@
+package services
+
+import (
+	"fmt"
+	"io"
+	"mime/multipart"
+	"os"
+	"path/filepath"
+
+	"github.com/google/uuid"
+)
+
+// saveUpload stores an uploaded file under dir with a random name and returns the generated ID and file path
+func saveUpload(file *multipart.FileHeader, dir string) (string, string, error) {
+	// Create the directory if it doesn't exist
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create uploads directory: %v", err)
+	}
+
+	// Generate a unique ID and keep the original extension
+	id := uuid.New().String()
+	filePath := filepath.Join(dir, id+filepath.Ext(file.Filename))
+
+	// Open the source file
+	src, err := file.Open()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open uploaded file: %v", err)
+	}
+	defer src.Close()
+
+	// Create the destination file
+	dst, err := os.Create(filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create destination file: %v", err)
+	}
+	defer dst.Close()
+
+	// Copy the file content
+	if _, err = io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(filePath)
+		return "", "", fmt.Errorf("failed to copy file content: %v", err)
+	}
+
+	return id, filePath, nil
+}
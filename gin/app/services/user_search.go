@@ -0,0 +1,85 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"app/models"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+
+	// searchSimilarity is the minimum trigram similarity for a fuzzy match
+	searchSimilarity = 0.3
+)
+
+// SearchUsers finds users by username or display name. Prefix matches rank first,
+// followed by fuzzy matches. Only discoverable users and users who share a server
+// with the caller are returned.
+func (s *UserService) SearchUsers(callerID, query string, limit, offset int) (*models.UserSearchResponse, error) {
+	query = strings.TrimSpace(query)
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	limit = min(limit, maxSearchLimit)
+	offset = max(offset, 0)
+
+	response := &models.UserSearchResponse{Users: []models.PublicProfile{}, Limit: limit, Offset: offset}
+	if query == "" {
+		return response, nil
+	}
+
+	// Fetch one extra row to tell whether there is another page
+	rows, err := s.db.Query(`
+		SELECT u.id, u.username, COALESCE(u.display_name, ''), COALESCE(u.bio, ''), COALESCE(u.avatar_url, ''), u.created_at
+		FROM users u
+		WHERE u.id <> $1 AND u.id <> $2
+		  AND (
+		    u.discoverable
+		    OR EXISTS (
+		      SELECT 1 FROM server_members mine
+		      JOIN server_members theirs ON theirs.server_id = mine.server_id
+		      WHERE mine.user_id = $1 AND theirs.user_id = u.id
+		    )
+		  )
+		  AND (
+		    u.username ILIKE $3::text || '%' ESCAPE '\'
+		    OR u.display_name ILIKE $3::text || '%' ESCAPE '\'
+		    OR similarity(u.username, $4) >= $5
+		    OR similarity(COALESCE(u.display_name, ''), $4) >= $5
+		  )
+		ORDER BY
+		  (u.username ILIKE $3::text || '%' ESCAPE '\' OR u.display_name ILIKE $3::text || '%' ESCAPE '\') DESC,
+		  GREATEST(similarity(u.username, $4), similarity(COALESCE(u.display_name, ''), $4)) DESC,
+		  u.username ASC
+		LIMIT $6 OFFSET $7
+	`, callerID, models.DeletedUserID, escapeLike(query), query, searchSimilarity, limit+1, offset)
+	if err != nil {
+		return nil, fmt.Errorf("error searching users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var profile models.PublicProfile
+		if err := rows.Scan(&profile.ID, &profile.Username, &profile.DisplayName, &profile.Bio, &profile.AvatarURL, &profile.CreatedAt); err != nil {
+			return nil, err
+		}
+		response.Users = append(response.Users, profile)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(response.Users) > limit {
+		response.Users = response.Users[:limit]
+		response.HasMore = true
+	}
+	return response, nil
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -2,10 +2,17 @@ package services
 
 import (
 	"app/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
-	"os"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -13,16 +20,92 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	// accessTokenTTL is the lifetime of an access token
+	accessTokenTTL = 15 * time.Minute
+
+	// refreshTokenTTL is the lifetime of a session; each refresh extends it
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	// passwordResetTTL is the lifetime of a password reset token
+	passwordResetTTL = time.Hour
+
+	// emailVerificationTTL is the lifetime of an email verification link
+	emailVerificationTTL = 24 * time.Hour
+
+	// tokenIssuer is the "iss" claim of every token we sign
+	tokenIssuer = "chat-app"
+)
+
+// Token purposes. A purpose is signed as both the "typ" claim and the audience, and
+// parseToken only accepts a token for the purpose it is asked for, so a verification link
+// or MFA challenge cannot pass as an access token, here or at a service using our JWKS.
+const (
+	tokenPurposeAccess      = "access"
+	tokenPurposeEmailVerify = "email_verify"
+	tokenPurposeMFA         = "mfa"
+	tokenPurposeReauth      = "reauth"
+)
+
+var (
+	// ErrInvalidRefreshToken is returned when a refresh token is unknown, expired or revoked
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+	// ErrSessionRevoked is returned when an access token belongs to a revoked or expired session
+	ErrSessionRevoked = errors.New("session has been revoked")
+
+	// ErrInvalidResetToken is returned when a password reset token is unknown, used or expired
+	ErrInvalidResetToken = errors.New("invalid or expired password reset token")
+
+	// ErrInvalidVerificationToken is returned when an email verification link is invalid or expired
+	ErrInvalidVerificationToken = errors.New("invalid or expired verification token")
+
+	// ErrEmailAlreadyVerified is returned when resending verification for a verified address
+	ErrEmailAlreadyVerified = errors.New("email is already verified")
+
+	// ErrInvalidCredentials is returned by Login for unknown emails, wrong passwords and locked
+	// accounts alike, so that the response does not reveal which accounts exist
+	ErrInvalidCredentials = errors.New("invalid email or password")
+)
+
+// RefreshTokenReusedError is returned when a refresh token that has already been rotated is
+// presented again. The session has been revoked and its connections should be closed.
+type RefreshTokenReusedError struct {
+	SessionID string
+}
+
+func (e *RefreshTokenReusedError) Error() string {
+	return ErrInvalidRefreshToken.Error()
+}
+
+func (e *RefreshTokenReusedError) Unwrap() error {
+	return ErrInvalidRefreshToken
+}
+
+// TokenClaims holds the identity carried by a validated access token
+type TokenClaims struct {
+	UserID    string
+	SessionID string   // Empty for personal access tokens
+	Scopes    []string // nil for login sessions, which are not scoped
+}
+
 type UserService struct {
-	db *sql.DB
+	db     *sql.DB
+	keys   *KeySet
+	mailer Mailer
 }
 
-func NewUserService(db *sql.DB) *UserService {
-	return &UserService{db: db}
+func NewUserService(db *sql.DB, keys *KeySet) *UserService {
+	return &UserService{db: db, keys: keys}
+}
+
+// SetMailer sets the mailer used for account emails
+func (s *UserService) SetMailer(mailer Mailer) {
+	s.mailer = mailer
 }
 
 // Register creates a new user in the database
-func (s *UserService) Register(req models.RegisterRequest) (*models.UserResponse, error) {
+func (s *UserService) Register(req models.RegisterRequest, client models.ClientInfo) (*models.UserResponse, error) {
 	// Check if user with email already exists
 	var count int
 	err := s.db.QueryRow("SELECT COUNT(*) FROM users WHERE email = $1", req.Email).Scan(&count)
@@ -61,59 +144,103 @@ func (s *UserService) Register(req models.RegisterRequest) (*models.UserResponse
 		return nil, fmt.Errorf("error creating user: %w", err)
 	}
 
-	// Generate JWT token
-	token, err := s.generateToken(userID)
-	if err != nil {
-		return nil, fmt.Errorf("error generating token: %w", err)
+	// Ask the user to confirm their address; the account is usable meanwhile
+	if err := s.sendVerificationEmail(userID, req.Email); err != nil {
+		log.Printf("確認メールの送信に失敗しました: %v", err)
 	}
 
-	// Return user response
-	return &models.UserResponse{
+	// Start a session for the new user
+	return s.startSession(&models.User{
 		ID:        userID,
 		Username:  req.Username,
 		Email:     req.Email,
 		CreatedAt: now,
-		Token:     token,
-	}, nil
+	}, client)
 }
 
-// Login authenticates a user and returns a JWT token
-func (s *UserService) Login(req models.LoginRequest) (*models.UserResponse, error) {
+// Login authenticates a user with email and password. When two-factor authentication
+// is enabled no session is created; a challenge for the second step is returned instead.
+// Repeated failures lock the account for progressively longer periods. Every failure,
+// including a locked account, takes a password check and returns ErrInvalidCredentials.
+func (s *UserService) Login(req models.LoginRequest, client models.ClientInfo) (*models.UserResponse, *models.MFAChallengeResponse, error) {
 	var user models.User
 	var hashedPassword string
 
 	// Find user by email
 	err := s.db.QueryRow(
-		"SELECT id, username, email, password, created_at, updated_at FROM users WHERE email = $1",
+		"SELECT id, username, email, password, verified_at, totp_enabled_at IS NOT NULL, created_at, updated_at FROM users WHERE email = $1",
 		req.Email,
-	).Scan(&user.ID, &user.Username, &user.Email, &hashedPassword, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(&user.ID, &user.Username, &user.Email, &hashedPassword, &user.VerifiedAt, &user.TOTPEnabled, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, errors.New("invalid email or password")
+			bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+			s.RecordAuthEvent("", req.Email, AuthEventLoginFailed, client, "unknown email")
+			return nil, nil, ErrInvalidCredentials
 		}
-		return nil, fmt.Errorf("error finding user: %w", err)
+		return nil, nil, fmt.Errorf("error finding user: %w", err)
+	}
+
+	// A locked account fails even with the right password
+	passwordErr := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password))
+	if err := s.checkLockout(user.ID); err != nil {
+		var locked *AccountLockedError
+		if !errors.As(err, &locked) {
+			return nil, nil, err
+		}
+		s.RecordAuthEvent(user.ID, user.Email, AuthEventLoginFailed, client, "account locked")
+		return nil, nil, ErrInvalidCredentials
 	}
 
-	// Verify password
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(req.Password))
+	if passwordErr != nil {
+		s.recordLoginFailure(user.ID, user.Email, client, "invalid password")
+		return nil, nil, ErrInvalidCredentials
+	}
+
+	// The failure counter is reset once the second factor has been checked as well
+	if user.TOTPEnabled {
+		challenge, err := s.createMFAChallenge(user.ID)
+		return nil, challenge, err
+	}
+
+	s.resetLoginFailures(user.ID)
+	response, err := s.startSession(&user, client)
+	return response, nil, err
+}
+
+// CompleteExternalLogin logs in a user already authenticated by an external identity
+// provider. Two-factor authentication still applies.
+func (s *UserService) CompleteExternalLogin(userID string, client models.ClientInfo) (*models.UserResponse, *models.MFAChallengeResponse, error) {
+	user, err := s.GetUserByID(userID)
 	if err != nil {
-		return nil, errors.New("invalid email or password")
+		return nil, nil, err
 	}
 
-	// Generate JWT token
-	token, err := s.generateToken(user.ID)
+	if user.TOTPEnabled {
+		challenge, err := s.createMFAChallenge(user.ID)
+		return nil, challenge, err
+	}
+
+	response, err := s.startSession(user, client)
+	return response, nil, err
+}
+
+// startSession creates a session for an authenticated user and builds the login response
+func (s *UserService) startSession(user *models.User, client models.ClientInfo) (*models.UserResponse, error) {
+	tokens, err := s.createSession(user.ID, client)
 	if err != nil {
-		return nil, fmt.Errorf("error generating token: %w", err)
+		return nil, fmt.Errorf("error creating session: %w", err)
 	}
 
-	// Return user response
 	return &models.UserResponse{
-		ID:        user.ID,
-		Username:  user.Username,
-		Email:     user.Email,
-		CreatedAt: user.CreatedAt,
-		Token:     token,
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.VerifiedAt != nil,
+		CreatedAt:     user.CreatedAt,
+		Token:         tokens.Token,
+		RefreshToken:  tokens.RefreshToken,
+		ExpiresAt:     tokens.ExpiresAt,
 	}, nil
 }
 
@@ -122,9 +249,14 @@ func (s *UserService) GetUserByID(userID string) (*models.User, error) {
 	var user models.User
 
 	err := s.db.QueryRow(
-		"SELECT id, username, email, created_at, updated_at FROM users WHERE id = $1",
+		`SELECT id, username, email, COALESCE(display_name, ''), COALESCE(bio, ''), COALESCE(avatar_url, ''),
+			discoverable, verified_at, totp_enabled_at IS NOT NULL, created_at, updated_at
+		FROM users WHERE id = $1`,
 		userID,
-	).Scan(&user.ID, &user.Username, &user.Email, &user.CreatedAt, &user.UpdatedAt)
+	).Scan(
+		&user.ID, &user.Username, &user.Email, &user.DisplayName, &user.Bio, &user.AvatarURL,
+		&user.Discoverable, &user.VerifiedAt, &user.TOTPEnabled, &user.CreatedAt, &user.UpdatedAt,
+	)
 
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -136,60 +268,418 @@ func (s *UserService) GetUserByID(userID string) (*models.User, error) {
 	return &user, nil
 }
 
-// ValidateToken validates a JWT token and returns the user ID
-func (s *UserService) ValidateToken(tokenString string) (string, error) {
-	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+// VerifyEmail marks the user's email as verified using a signed verification token
+func (s *UserService) VerifyEmail(tokenString string) error {
+	claims, err := s.parseToken(tokenString, tokenPurposeEmailVerify)
+	if err != nil {
+		return ErrInvalidVerificationToken
+	}
+	userID, _ := claims["userID"].(string)
+	email, _ := claims["email"].(string)
+
+	// The token is bound to the address it was sent to
+	result, err := s.db.Exec(
+		"UPDATE users SET verified_at = COALESCE(verified_at, $1), updated_at = $1 WHERE id = $2 AND email = $3",
+		time.Now(), userID, email,
+	)
+	if err != nil {
+		return fmt.Errorf("error verifying email: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return ErrInvalidVerificationToken
+	}
+	return nil
+}
+
+// ResendVerificationEmail sends a new verification link to the user
+func (s *UserService) ResendVerificationEmail(userID string) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.VerifiedAt != nil {
+		return ErrEmailAlreadyVerified
+	}
+	return s.sendVerificationEmail(user.ID, user.Email)
+}
+
+// IsEmailVerified checks if the user has verified their email address
+func (s *UserService) IsEmailVerified(userID string) (bool, error) {
+	var verified bool
+	err := s.db.QueryRow("SELECT verified_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&verified)
+	return verified, err
+}
+
+// sendVerificationEmail emails a signed verification link
+func (s *UserService) sendVerificationEmail(userID, email string) error {
+	if s.mailer == nil {
+		return errors.New("mailer is not configured")
+	}
+
+	token, err := s.signToken(tokenPurposeEmailVerify, jwt.MapClaims{
+		"userID": userID,
+		"email":  email,
+		"exp":    time.Now().Add(emailVerificationTTL).Unix(),
+	})
+	if err != nil {
+		return fmt.Errorf("error generating verification token: %w", err)
+	}
+
+	body := fmt.Sprintf(
+		"ご登録ありがとうございます。\n\n以下のリンクから24時間以内にメールアドレスを確認してください。\n%s\n\nこのメールに心当たりがない場合は無視してください。\n",
+		appURL("/verify-email?token="+token),
+	)
+	return s.mailer.Send(email, "メールアドレスの確認", body)
+}
+
+// RequestPasswordReset emails a single-use reset link to the user.
+// Unknown addresses are ignored so that callers cannot probe for accounts. For the same
+// reason a failed send is only logged, like the verification email.
+func (s *UserService) RequestPasswordReset(email string) error {
+	if s.mailer == nil {
+		return errors.New("mailer is not configured")
+	}
+
+	var userID string
+	err := s.db.QueryRow("SELECT id FROM users WHERE email = $1", email).Scan(&userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return fmt.Errorf("error finding user: %w", err)
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return fmt.Errorf("error generating reset token: %w", err)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Only the most recent link stays valid
+	now := time.Now()
+	_, err = tx.Exec(
+		"UPDATE password_reset_tokens SET used_at = $1 WHERE user_id = $2 AND used_at IS NULL",
+		now, userID,
+	)
+	if err != nil {
+		return fmt.Errorf("error invalidating reset tokens: %w", err)
+	}
+
+	_, err = tx.Exec(
+		"INSERT INTO password_reset_tokens (id, user_id, token_hash, expires_at, created_at) VALUES ($1, $2, $3, $4, $5)",
+		uuid.New().String(), userID, hashToken(secret), now.Add(passwordResetTTL), now,
+	)
+	if err != nil {
+		return fmt.Errorf("error creating reset token: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(
+		"パスワードの再設定が申請されました。\n\n以下のリンクから1時間以内に新しいパスワードを設定してください。\n%s\n\nこのメールに心当たりがない場合は無視してください。\n",
+		appURL("/reset-password?token="+secret),
+	)
+	if err := s.mailer.Send(email, "パスワードの再設定", body); err != nil {
+		log.Printf("パスワード再設定メールの送信に失敗しました: %v", err)
+	}
+	return nil
+}
+
+// ResetPassword sets a new password using a reset token and signs the user out everywhere.
+// It returns the ID of the user whose password was reset.
+func (s *UserService) ResetPassword(token, newPassword string) (string, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID string
+	err = tx.QueryRow(
+		"SELECT id, user_id FROM password_reset_tokens WHERE token_hash = $1 AND used_at IS NULL AND expires_at > $2 FOR UPDATE",
+		hashToken(token), time.Now(),
+	).Scan(&tokenID, &userID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrInvalidResetToken
 		}
+		return "", fmt.Errorf("error finding reset token: %w", err)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("error hashing password: %w", err)
+	}
+
+	now := time.Now()
+	if _, err = tx.Exec("UPDATE users SET password = $1, updated_at = $2 WHERE id = $3", string(hashedPassword), now, userID); err != nil {
+		return "", fmt.Errorf("error updating password: %w", err)
+	}
+	if _, err = tx.Exec("UPDATE password_reset_tokens SET used_at = $1 WHERE id = $2", now, tokenID); err != nil {
+		return "", fmt.Errorf("error consuming reset token: %w", err)
+	}
+
+	// Anyone holding the old password may have active sessions
+	if _, err = tx.Exec("UPDATE user_sessions SET revoked_at = $1, updated_at = $1 WHERE user_id = $2 AND revoked_at IS NULL", now, userID); err != nil {
+		return "", fmt.Errorf("error revoking sessions: %w", err)
+	}
+
+	return userID, tx.Commit()
+}
 
-		// Return the secret key
-		jwtSecret := os.Getenv("JWT_SECRET")
-		if jwtSecret == "" {
-			return nil, errors.New("JWT_SECRET is not set")
+// RefreshSession rotates the refresh token of a session and issues a new access token.
+// Presenting a refresh token that has already been rotated revokes the whole session,
+// since it means the token was copied.
+func (s *UserService) RefreshSession(refreshToken string, client models.ClientInfo) (*models.TokenPair, error) {
+	sessionID, secret, ok := strings.Cut(refreshToken, ".")
+	if !ok || sessionID == "" || secret == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	var userID, tokenHash string
+	var expiresAt time.Time
+	var revokedAt sql.NullTime
+	err := s.db.QueryRow(
+		"SELECT user_id, refresh_token_hash, expires_at, revoked_at FROM user_sessions WHERE id = $1",
+		sessionID,
+	).Scan(&userID, &tokenHash, &expiresAt, &revokedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrInvalidRefreshToken
 		}
-		return []byte(jwtSecret), nil
-	})
+		return nil, fmt.Errorf("error finding session: %w", err)
+	}
+
+	if revokedAt.Valid || time.Now().After(expiresAt) {
+		return nil, ErrInvalidRefreshToken
+	}
 
+	// A stale token means the refresh token was reused; kill the session
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(tokenHash)) != 1 {
+		if err := s.revokeSession(sessionID); err != nil {
+			return nil, err
+		}
+		return nil, &RefreshTokenReusedError{SessionID: sessionID}
+	}
+
+	newSecret, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error generating refresh token: %w", err)
+	}
+
+	// Only rotate if nobody else rotated the token in the meantime
+	now := time.Now()
+	result, err := s.db.Exec(
+		`UPDATE user_sessions
+		SET refresh_token_hash = $1, expires_at = $2, user_agent = $3, ip_address = $4, last_seen_at = $5, updated_at = $5
+		WHERE id = $6 AND refresh_token_hash = $7 AND revoked_at IS NULL`,
+		hashToken(newSecret), now.Add(refreshTokenTTL), client.UserAgent, client.IPAddress, now, sessionID, tokenHash,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error rotating refresh token: %w", err)
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	token, expiry, err := s.generateToken(userID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error generating token: %w", err)
+	}
+
+	return &models.TokenPair{
+		Token:        token,
+		RefreshToken: sessionID + "." + newSecret,
+		ExpiresAt:    expiry,
+	}, nil
+}
+
+// RevokeSession revokes one of the user's sessions
+func (s *UserService) RevokeSession(sessionID, userID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+
+	result, err := s.db.Exec(
+		"UPDATE user_sessions SET revoked_at = $1, updated_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL",
+		time.Now(), sessionID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, err := result.RowsAffected(); err != nil || rows == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// ValidateToken validates a JWT token and returns the user ID
+func (s *UserService) ValidateToken(tokenString string) (string, error) {
+	claims, err := s.ParseAccessToken(tokenString)
 	if err != nil {
 		return "", err
 	}
+	return claims.UserID, nil
+}
+
+// ParseAccessToken validates an access token and checks that its session is still active
+func (s *UserService) ParseAccessToken(tokenString string) (*TokenClaims, error) {
+	claims, err := s.parseToken(tokenString, tokenPurposeAccess)
+	if err != nil {
+		return nil, err
+	}
 
-	// Validate the token
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID, ok := claims["userID"].(string)
-		if !ok {
-			return "", errors.New("invalid token claims")
+	userID, ok := claims["userID"].(string)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok {
+		return nil, errors.New("invalid token claims")
+	}
+
+	// Check that the session has not been revoked
+	var active bool
+	err = s.db.QueryRow(
+		"SELECT revoked_at IS NULL AND expires_at > $1 FROM user_sessions WHERE id = $2 AND user_id = $3",
+		time.Now(), sessionID, userID,
+	).Scan(&active)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrSessionRevoked
 		}
-		return userID, nil
+		return nil, fmt.Errorf("error checking session: %w", err)
+	}
+	if !active {
+		return nil, ErrSessionRevoked
 	}
 
-	return "", errors.New("invalid token")
+	// Track activity at most once a minute
+	now := time.Now()
+	_, err = s.db.Exec(
+		"UPDATE user_sessions SET last_seen_at = $1 WHERE id = $2 AND (last_seen_at IS NULL OR last_seen_at < $3)",
+		now, sessionID, now.Add(-time.Minute),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error updating session: %w", err)
+	}
+
+	return &TokenClaims{UserID: userID, SessionID: sessionID}, nil
 }
 
-// generateToken generates a new JWT token for the given user ID
-func (s *UserService) generateToken(userID string) (string, error) {
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		return "", errors.New("JWT_SECRET is not set")
+// createSession stores a new session for the user and returns its token pair
+func (s *UserService) createSession(userID string, client models.ClientInfo) (*models.TokenPair, error) {
+	secret, err := generateSecret()
+	if err != nil {
+		return nil, fmt.Errorf("error generating refresh token: %w", err)
+	}
+
+	sessionID := uuid.New().String()
+	now := time.Now()
+	_, err = s.db.Exec(
+		"INSERT INTO user_sessions (id, user_id, refresh_token_hash, expires_at, user_agent, ip_address, last_seen_at, created_at, updated_at) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		sessionID, userID, hashToken(secret), now.Add(refreshTokenTTL), client.UserAgent, client.IPAddress, now, now, now,
+	)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create token claims
-	claims := jwt.MapClaims{
+	token, expiresAt, err := s.generateToken(userID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error generating token: %w", err)
+	}
+
+	return &models.TokenPair{
+		Token:        token,
+		RefreshToken: sessionID + "." + secret,
+		ExpiresAt:    expiresAt,
+	}, nil
+}
+
+// revokeSession revokes a session regardless of its owner
+func (s *UserService) revokeSession(sessionID string) error {
+	_, err := s.db.Exec(
+		"UPDATE user_sessions SET revoked_at = $1, updated_at = $1 WHERE id = $2 AND revoked_at IS NULL",
+		time.Now(), sessionID,
+	)
+	return err
+}
+
+// generateToken generates a new access token for the given user and session
+func (s *UserService) generateToken(userID, sessionID string) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(accessTokenTTL)
+	token, err := s.signToken(tokenPurposeAccess, jwt.MapClaims{
 		"userID": userID,
-		"exp":    time.Now().Add(time.Hour * 24 * 7).Unix(), // Token valid for 7 days
+		"sid":    sessionID,
+		"iat":    now.Unix(),
+		"exp":    expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
 	}
+	return token, expiresAt, nil
+}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+// signToken signs the given claims for one purpose, setting the issuer, audience and "typ"
+func (s *UserService) signToken(purpose string, claims jwt.MapClaims) (string, error) {
+	claims["iss"] = tokenIssuer
+	claims["aud"] = purpose
+	claims["typ"] = purpose
+	return s.keys.Sign(claims)
+}
 
-	// Sign token with secret key
-	tokenString, err := token.SignedString([]byte(jwtSecret))
+// parseToken verifies a token's signature, expiry, issuer and purpose and returns its claims
+func (s *UserService) parseToken(tokenString, purpose string) (jwt.MapClaims, error) {
+	token, err := s.keys.Parse(tokenString,
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(purpose),
+		jwt.WithExpirationRequired(),
+	)
 	if err != nil {
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || claims["typ"] != purpose {
+		return nil, errors.New("invalid token")
+	}
+	return claims, nil
+}
+
+// JWKS returns the public keys other services use to verify our tokens
+func (s *UserService) JWKS() (JWKSet, error) {
+	return s.keys.JWKS()
+}
+
+// dummyPasswordHash is compared against for unknown emails so that they take as long as
+// a wrong password
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// generateSecret returns a random URL-safe secret
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
 		return "", err
 	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
 
-	return tokenString, nil
+// hashToken returns the hex encoded SHA-256 hash of a token, used to store secrets at rest
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
 }
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
 // WebSocketService はWebSocket接続を管理するサービス
@@ -135,6 +136,41 @@ func (s *WebSocketService) BroadcastMessageDelete(channelID string, messageID st
 	return s.broadcastMessage(channelID, wsMessage)
 }
 
+// BroadcastUserUpdate はユーザーのプロフィール変更を複数のチャンネルにブロードキャストする
+func (s *WebSocketService) BroadcastUserUpdate(channelIDs []string, profile interface{}) error {
+	wsMessage := models.WebSocketMessage{
+		Type:      "user_update",
+		Message:   profile,
+		Timestamp: time.Now(),
+	}
+
+	return s.broadcastToChannels(channelIDs, wsMessage)
+}
+
+// BroadcastServerEvent はチャンネルやカテゴリーの変更などのイベントを複数のチャンネルにブロードキャストする
+func (s *WebSocketService) BroadcastServerEvent(channelIDs []string, eventType string, payload interface{}) error {
+	wsMessage := models.WebSocketMessage{
+		Type:      eventType,
+		Message:   payload,
+		Timestamp: time.Now(),
+	}
+
+	return s.broadcastToChannels(channelIDs, wsMessage)
+}
+
+// broadcastToChannels は接続中のクライアントがいるチャンネルにだけメッセージを送信する
+func (s *WebSocketService) broadcastToChannels(channelIDs []string, wsMessage models.WebSocketMessage) error {
+	for _, channelID := range channelIDs {
+		if s.GetChannelClientsCount(channelID) == 0 {
+			continue
+		}
+		if err := s.broadcastMessage(channelID, wsMessage); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // broadcastMessage はメッセージをブロードキャストする
 func (s *WebSocketService) broadcastMessage(channelID string, message models.WebSocketMessage) error {
 	// メッセージをJSONに変換
@@ -152,6 +188,156 @@ func (s *WebSocketService) broadcastMessage(channelID string, message models.Web
 	return nil
 }
 
+// SendChannelState は登録前のクライアントに最初のchannel_stateを送信する
+func (s *WebSocketService) SendChannelState(client *models.WebSocketClient, state models.ChannelState) error {
+	frame, err := channelStateFrame(state)
+	if err != nil {
+		return err
+	}
+	client.Send <- frame
+	return nil
+}
+
+// BroadcastChannelState はチャンネルに接続中の各クライアントに、そのユーザーのchannel_stateを送信する。
+// statesに含まれないユーザーには送信しない。送信キューが一杯のクライアントには送らない。
+func (s *WebSocketService) BroadcastChannelState(channelID string, states map[string]models.ChannelState) error {
+	frames := make(map[string][]byte, len(states))
+	for userID, state := range states {
+		frame, err := channelStateFrame(state)
+		if err != nil {
+			return err
+		}
+		frames[userID] = frame
+	}
+
+	// 切断時にSendが閉じられないよう、ロックを保持したまま送信する
+	s.Hub.Mutex.RLock()
+	defer s.Hub.Mutex.RUnlock()
+	for _, client := range s.Hub.Channels[channelID] {
+		frame, ok := frames[client.UserID]
+		if !ok {
+			continue
+		}
+		select {
+		case client.Send <- frame:
+		default:
+			log.Printf("クライアント %s の送信キューが一杯のためchannel_stateを破棄しました", client.ID)
+		}
+	}
+	return nil
+}
+
+// channelStateFrame はchannel_stateメッセージをJSONに変換する
+func channelStateFrame(state models.ChannelState) ([]byte, error) {
+	frame, err := json.Marshal(models.WebSocketMessage{
+		Type:      "channel_state",
+		Message:   state,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("メッセージのJSONへの変換に失敗しました: %w", err)
+	}
+	return frame, nil
+}
+
+// ChannelUsers はチャンネルに接続中のユーザーIDを返す
+func (s *WebSocketService) ChannelUsers(channelID string) []string {
+	s.Hub.Mutex.RLock()
+	defer s.Hub.Mutex.RUnlock()
+
+	seen := make(map[string]bool)
+	var userIDs []string
+	for _, client := range s.Hub.Channels[channelID] {
+		if !seen[client.UserID] {
+			seen[client.UserID] = true
+			userIDs = append(userIDs, client.UserID)
+		}
+	}
+	return userIDs
+}
+
+// DisconnectUser はユーザーのすべてのWebSocket接続を切断する
+func (s *WebSocketService) DisconnectUser(userID, reason string) {
+	s.disconnectClients(func(client *models.WebSocketClient) bool {
+		return client.UserID == userID
+	}, reason)
+}
+
+// DisconnectSession はログインセッションに紐づくWebSocket接続を切断する
+func (s *WebSocketService) DisconnectSession(sessionID, reason string) {
+	if sessionID == "" {
+		return
+	}
+	s.disconnectClients(func(client *models.WebSocketClient) bool {
+		return client.SessionID == sessionID
+	}, reason)
+}
+
+// DisconnectUserFromChannels は指定したチャンネルへのユーザーのWebSocket接続を切断する
+func (s *WebSocketService) DisconnectUserFromChannels(userID string, channelIDs []string, reason string) {
+	channels := make(map[string]bool, len(channelIDs))
+	for _, channelID := range channelIDs {
+		channels[channelID] = true
+	}
+	s.disconnectClients(func(client *models.WebSocketClient) bool {
+		return client.UserID == userID && channels[client.ChannelID]
+	}, reason)
+}
+
+// DisconnectChannels は指定したチャンネルのすべてのWebSocket接続を切断する
+func (s *WebSocketService) DisconnectChannels(channelIDs []string, reason string) {
+	channels := make(map[string]bool, len(channelIDs))
+	for _, channelID := range channelIDs {
+		channels[channelID] = true
+	}
+	s.disconnectClients(func(client *models.WebSocketClient) bool {
+		return channels[client.ChannelID]
+	}, reason)
+}
+
+// disconnectClients は条件に一致するクライアントにクローズフレームを送って接続を閉じる。
+// 登録解除は各クライアントのreadPumpが行う。
+func (s *WebSocketService) disconnectClients(match func(*models.WebSocketClient) bool, reason string) {
+	s.Hub.Mutex.RLock()
+	var targets []*models.WebSocketClient
+	for _, clients := range s.Hub.Channels {
+		for _, client := range clients {
+			if match(client) {
+				targets = append(targets, client)
+			}
+		}
+	}
+	s.Hub.Mutex.RUnlock()
+
+	message := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, reason)
+	for _, client := range targets {
+		client.Conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		client.Conn.Close()
+		log.Printf("クライアント %s をチャンネル %s から切断しました: %s", client.ID, client.ChannelID, reason)
+	}
+}
+
+// OnlineUsers は指定したユーザーのうち、いずれかのチャンネルに接続しているユーザーを返す
+func (s *WebSocketService) OnlineUsers(userIDs []string) map[string]bool {
+	wanted := make(map[string]bool, len(userIDs))
+	for _, userID := range userIDs {
+		wanted[userID] = true
+	}
+
+	s.Hub.Mutex.RLock()
+	defer s.Hub.Mutex.RUnlock()
+
+	online := make(map[string]bool)
+	for _, clients := range s.Hub.Channels {
+		for _, client := range clients {
+			if wanted[client.UserID] {
+				online[client.UserID] = true
+			}
+		}
+	}
+	return online
+}
+
 // GenerateClientID はクライアントIDを生成する
 func (s *WebSocketService) GenerateClientID() string {
 	return uuid.New().String()